@@ -4,7 +4,11 @@ package atum
 import (
 	"github.com/bwesterb/go-pow"
 
+	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/json"
+	"strings"
 	"time"
 )
 
@@ -22,11 +26,24 @@ type Timestamp struct {
 	// The signature.
 	Sig Signature
 
+	// Additional signatures on the same message for a hybrid timestamp,
+	// for instance an Ed25519 and an XMSSMT signature.  See Request.SigAlgs.
+	ExtraSigs []Signature `json:",omitempty"`
+
 	// The Atum server only signs short nonces.  To timestamp a longer message,
 	// the Atum server first hashes the long message to a nonce, which
 	// in turn is signed by the Atum server.  If this is the case, the following
 	// field contains the hash used.
 	Hashing *Hashing `json:",omitempty"`
+
+	// Several nonces can be timestamped at once by requesting a timestamp
+	// on the root of a Merkle tree over them.  If this is the case, the
+	// following field contains the proof that the nonce is in the tree.
+	Batch *BatchProof `json:",omitempty"`
+
+	// Proof that the timestamp is included in the transparency log of
+	// the server, if the server keeps one.
+	Log *LogProof `json:",omitempty"`
 }
 
 // See the Timestamp.Hashing field
@@ -82,6 +99,101 @@ type Request struct {
 	// is not supported or this field is omitted, the server will revert
 	// to the default.
 	PreferredSigAlg *SignatureAlgorithm
+
+	// Signature algorithms to request a hybrid timestamp for.  If set,
+	// the server will sign the timestamp with each of the listed signature
+	// algorithms it supports and PreferredSigAlg is ignored.
+	SigAlgs []SignatureAlgorithm `json:",omitempty"`
+}
+
+// A signed tree head of the transparency log of an Atum server.  It is
+// published at <server url>/log/treeHead.
+type TreeHead struct {
+	// The number of entries in the log
+	Size uint64
+
+	// The RFC 6962 Merkle tree hash of the entries
+	RootHash []byte
+
+	// Unix time at which the tree head was signed
+	Time int64
+
+	// Signature on the message returned by SignedMessage().
+	Sig Signature
+
+	// Cosignatures of witnesses on the tree head.  Only present on
+	// the tree head published at <server url>/log/cosignedTreeHead.
+	Cosignatures []Cosignature `json:",omitempty"`
+}
+
+// A cosignature by a witness on a tree head of an Atum server.  By cosigning,
+// the witness states it checked that the tree head is consistent with all
+// tree heads of the server it saw before.
+type Cosignature struct {
+	// The Ed25519 public key of the witness
+	PublicKey []byte
+
+	// Unix time at which the witness cosigned the tree head
+	Time int64
+
+	// Ed25519 signature on the message returned by
+	// TreeHead.CosignedMessage().
+	Sig []byte
+}
+
+// Request of a witness to add its cosignature to a tree head of an Atum
+// server, which is POSTed to <server url>/log/cosign.
+type CosignRequest struct {
+	// The tree head, without cosignatures
+	TreeHead TreeHead
+
+	// The cosignature of the witness
+	Cosignature Cosignature
+}
+
+// See the Timestamp.Batch field
+type BatchProof struct {
+	// The index of the nonce in the batch
+	Index uint64
+
+	// The number of nonces in the batch
+	Size uint64
+
+	// The RFC 6962 inclusion proof of the nonce in the Merkle tree over
+	// the nonces of the batch
+	InclusionProof [][]byte
+}
+
+// Proof that a timestamp is included in the transparency log of its server.
+type LogProof struct {
+	// The index of the timestamp's entry in the log.  See Timestamp.LogEntry().
+	Index uint64
+
+	// The tree head the entry is included in.  Zero if the server had not
+	// signed a tree head including the entry yet.  See Complete().
+	TreeHead TreeHead
+
+	// The RFC 6962 inclusion proof of the entry in TreeHead
+	InclusionProof [][]byte
+
+	// The tree head of the same log the client saw before, if any.
+	PreviousTreeHead *TreeHead `json:",omitempty"`
+
+	// The RFC 6962 consistency proof that TreeHead extends PreviousTreeHead.
+	ConsistencyProof [][]byte `json:",omitempty"`
+}
+
+// Response of the Atum server to a request for an inclusion proof
+// (<server url>/log/inclusion) or a consistency proof
+// (<server url>/log/consistency).
+type LogProofResponse struct {
+	Proof [][]byte
+}
+
+// Response of the Atum server to a request for log entries
+// (<server url>/log/entries).
+type LogEntriesResponse struct {
+	Entries [][]byte
 }
 
 // The response of the Atum server to a request
@@ -94,6 +206,10 @@ type Response struct {
 
 	// In case of most errors, the server will include server information.
 	Info *ServerInfo
+
+	// The index of the timestamp in the transparency log of the server,
+	// if the server keeps one.
+	LogIndex *uint64 `json:",omitempty"`
 }
 
 // Response of the Atum server to a public key check
@@ -103,6 +219,78 @@ type PublicKeyCheckResponse struct {
 
 	// When should you check again?
 	Expires time.Time
+
+	// Whether the public key has been revoked.  Trusted is false in
+	// that case.
+	Revoked bool `json:",omitempty"`
+
+	// When the public key was revoked, if it was.
+	RevokedAt *time.Time `json:",omitempty"`
+
+	// Why the public key was revoked, if it was.
+	RevocationReason RevocationReason `json:",omitempty"`
+}
+
+// Why a public key was revoked.
+type RevocationReason string
+
+const (
+	// The key was taken out of use.  Timestamps set with the key before
+	// it was revoked remain valid.
+	KeyRetired RevocationReason = "key retired"
+
+	// The private key was (possibly) compromised.  None of the timestamps
+	// set with the key can be trusted, as anyone with the private key can
+	// backdate a timestamp.
+	KeyCompromised RevocationReason = "key compromised"
+)
+
+// The revocation of a public key.
+type PublicKeyRevocation struct {
+	// When the key was revoked
+	RevokedAt time.Time
+
+	// Why the key was revoked
+	Reason RevocationReason
+}
+
+// An entry in the public key history of an Atum server.
+//
+// All times are unix times.
+type KeyHistoryEntry struct {
+	// The signature algorithm of the key
+	Alg SignatureAlgorithm
+
+	// The serialized public key
+	PublicKey []byte
+
+	// The key is used for timestamps set from this time on.
+	ValidFrom int64
+
+	// If set, the key is not used for timestamps set from this time on.
+	ValidUntil int64 `json:",omitempty"`
+
+	// If set, the time at which the key was revoked.
+	RevokedAt int64 `json:",omitempty"`
+
+	// Why the key was revoked, if it was.
+	RevocationReason RevocationReason `json:",omitempty"`
+}
+
+// The public keys an Atum server used, uses or will use, signed by one
+// of its current keys.  It is published at <server url>/keyHistory.
+type KeyHistory struct {
+	// The keys of the server
+	Keys []KeyHistoryEntry
+
+	// Unix time at which the history was signed
+	Issued int64
+
+	// Unix time at which the client should fetch the history again
+	Expires int64
+
+	// Signature on the message returned by SignedMessage().
+	Sig Signature
 }
 
 type ErrorCode string
@@ -116,8 +304,19 @@ const (
 	ErrorNonceTooLong ErrorCode = "nonce is too long"
 	ErrorMissingPow   ErrorCode = "proof of work is missing"
 	ErrorPowInvalid   ErrorCode = "proof of work is invalid"
+	ErrorInternal     ErrorCode = "internal error"
 )
 
+// Returns whether sending the same request again is bound to fail with
+// the same error.  Unknown error codes are not considered permanent.
+func (code ErrorCode) Permanent() bool {
+	switch code {
+	case ErrorCodeLag, ErrorMissingNonce, ErrorNonceTooLong:
+		return true
+	}
+	return false
+}
+
 // Supported signature algorithms.
 type SignatureAlgorithm string
 
@@ -128,8 +327,47 @@ const (
 	// XMSS[MT] signatures.
 	// See https://tools.ietf.org/html/draft-irtf-cfrg-xmss-hash-based-signatures-11
 	XMSSMT = "xmssmt"
+
+	// LMS signatures in the HSS format.  See rfc8554 and NIST SP 800-208.
+	LMS SignatureAlgorithm = "lms"
+)
+
+// Determines which signatures on a hybrid timestamp must be valid.
+type SignaturePolicy int
+
+const (
+	// All signatures on the timestamp must be valid.
+	AllSignatures SignaturePolicy = iota
+
+	// At least one of the signatures on the timestamp must be valid.
+	AnySignature
 )
 
+// Policy used to verify a timestamp.
+//
+// The zero value is a sensible default.
+type VerificationPolicy struct {
+	// Which signatures on a hybrid timestamp must be valid.
+	Signatures SignaturePolicy
+
+	// Signature algorithms of which the timestamp must carry a valid
+	// signature.  The signatures don't cover which algorithms were
+	// requested, so without this, a hybrid timestamp stripped of some
+	// of its signatures passes as a timestamp with fewer signatures.
+	SigAlgs []SignatureAlgorithm
+
+	// Reject timestamps without a proof of inclusion in the transparency
+	// log of the server.  A proof that is present is always checked.
+	RequireLogProof bool
+
+	// Ed25519 public keys of trusted witnesses.  See MinCosignatures.
+	Witnesses [][]byte
+
+	// If non-zero, require the timestamp to be included in a tree head of
+	// the transparency log cosigned by at least this many of the Witnesses.
+	MinCosignatures int
+}
+
 // Information published by an Atum server.
 type ServerInfo struct {
 	// The maximum size of nonce accepted
@@ -141,11 +379,31 @@ type ServerInfo struct {
 	// Default signature algorithm the server uses
 	DefaultSigAlg SignatureAlgorithm
 
+	// The signature algorithms the server supports.  Not set by older
+	// servers: see Supports().
+	SigAlgs []SignatureAlgorithm `json:",omitempty"`
+
 	// The necessary proof-of-work required for the different signature
 	// algorithms.
 	RequiredProofOfWork map[SignatureAlgorithm]pow.Request
 }
 
+// Returns whether the server supports the signature algorithm.  If the
+// server doesn't list the algorithms it supports, only the default and
+// those for which it requires proof of work are known to be supported.
+func (info *ServerInfo) Supports(alg SignatureAlgorithm) bool {
+	if len(info.SigAlgs) == 0 {
+		_, ok := info.RequiredProofOfWork[alg]
+		return ok || alg == info.DefaultSigAlg
+	}
+	for _, supported := range info.SigAlgs {
+		if supported == alg {
+			return true
+		}
+	}
+	return false
+}
+
 // Convenience function to set the Error field
 func (resp *Response) SetError(err ErrorCode) {
 	resp.Error = &err
@@ -165,3 +423,122 @@ func EncodeTimeNonce(time int64, nonce []byte) []byte {
 func (ts *Timestamp) GetTime() time.Time {
 	return time.Unix(ts.Time, 0)
 }
+
+// Returns all signatures on the timestamp: Sig followed by ExtraSigs.
+func (ts *Timestamp) Signatures() []Signature {
+	ret := make([]Signature, 0, 1+len(ts.ExtraSigs))
+	ret = append(ret, ts.Sig)
+	return append(ret, ts.ExtraSigs...)
+}
+
+// Returns the message signed by KeyHistory.Sig.
+func (h *KeyHistory) SignedMessage() []byte {
+	buf, _ := json.Marshal(struct {
+		Keys    []KeyHistoryEntry
+		Issued  int64
+		Expires int64
+	}{h.Keys, h.Issued, h.Expires})
+	return append([]byte("atum key history\n"), buf...)
+}
+
+// Returns the entry of the given public key, if it is in the history.
+func (h *KeyHistory) Find(alg SignatureAlgorithm, pk []byte) *KeyHistoryEntry {
+	for i := range h.Keys {
+		if h.Keys[i].Alg == alg && bytes.Equal(h.Keys[i].PublicKey, pk) {
+			return &h.Keys[i]
+		}
+	}
+	return nil
+}
+
+// Returns whether the key should be trusted for a timestamp set at
+// the given unix time.
+func (e *KeyHistoryEntry) ValidAt(time int64) bool {
+	if time < e.ValidFrom {
+		return false
+	}
+	if e.ValidUntil != 0 && time >= e.ValidUntil {
+		return false
+	}
+	if e.RevokedAt != 0 {
+		return e.Revocation().Allows(time)
+	}
+	return true
+}
+
+// Returns the revocation of the key, or nil if it is not revoked.
+func (e *KeyHistoryEntry) Revocation() *PublicKeyRevocation {
+	if e.RevokedAt == 0 {
+		return nil
+	}
+	return &PublicKeyRevocation{
+		RevokedAt: time.Unix(e.RevokedAt, 0),
+		Reason:    e.RevocationReason,
+	}
+}
+
+// Returns the revocation of the key, or nil if it is not revoked.
+func (resp *PublicKeyCheckResponse) Revocation() *PublicKeyRevocation {
+	if !resp.Revoked {
+		return nil
+	}
+	var ret PublicKeyRevocation
+	if resp.RevokedAt != nil {
+		ret.RevokedAt = *resp.RevokedAt
+	}
+	ret.Reason = resp.RevocationReason
+	return &ret
+}
+
+// Returns whether a timestamp set at the given unix time with the revoked
+// key can still be trusted.  This is only the case for a retired key and
+// a timestamp set before the revocation.  Any other reason is treated as
+// a compromise of the key.
+func (rev *PublicKeyRevocation) Allows(time int64) bool {
+	return rev.Reason == KeyRetired && time < rev.RevokedAt.Unix()
+}
+
+// Returns the entry the server appends to its transparency log for
+// this timestamp on the given nonce.
+//
+// The entry consists of the time (as 64-bit big endian unix time),
+// the SHA-256 hash of the nonce and the SHA-256 hash of the signatures.
+func (ts *Timestamp) LogEntry(nonce []byte) []byte {
+	nonceHash := sha256.Sum256(nonce)
+	h := sha256.New()
+	var buf [4]byte
+	for _, sig := range ts.Signatures() {
+		for _, field := range [][]byte{[]byte(sig.Alg), sig.PublicKey,
+			sig.Data} {
+			binary.BigEndian.PutUint32(buf[:], uint32(len(field)))
+			h.Write(buf[:])
+			h.Write(field)
+		}
+	}
+	ret := EncodeTimeNonce(ts.Time, nonceHash[:])
+	return h.Sum(ret)
+}
+
+// Returns the message signed by TreeHead.Sig.
+func (th *TreeHead) SignedMessage() []byte {
+	ret := []byte("atum tree head\n")
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], th.Size)
+	ret = append(ret, buf[:]...)
+	binary.BigEndian.PutUint64(buf[:], uint64(th.Time))
+	ret = append(ret, buf[:]...)
+	return append(ret, th.RootHash...)
+}
+
+// Returns the message a witness signs to cosign the tree head of the
+// server with the given url at the given unix time.
+func (th *TreeHead) CosignedMessage(serverUrl string, time int64) []byte {
+	if !strings.HasSuffix(serverUrl, "/") {
+		serverUrl += "/"
+	}
+	ret := []byte("atum cosigned tree head\n" + serverUrl + "\n")
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(time))
+	ret = append(ret, buf[:]...)
+	return append(ret, th.SignedMessage()...)
+}
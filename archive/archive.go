@@ -0,0 +1,211 @@
+// Embed Atum timestamps in ZIP and tar archives.
+//
+// Instead of shipping an archive together with a separate .atum-timestamp
+// file, the timestamp is stored in the archive itself: in the comment of a
+// ZIP archive, or as a trailing entry named .atum-timestamp of a tar
+// archive.  The timestamp is on a canonical digest of the contents of the
+// archive (see Digest), which leaves out the embedded timestamp itself.
+// Thus the archive can be stamped again, and extracting and repacking the
+// same files (in any order, with other modification times) preserves the
+// digest.
+package archive
+
+import (
+	"github.com/bwesterb/go-atum"
+
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Name of the entry of a tar archive with the embedded timestamp.
+const StampName = ".atum-timestamp"
+
+// Format of an archive.
+type Format string
+
+const (
+	Zip Format = "zip"
+	Tar Format = "tar"
+)
+
+// Returned by Extract() and Verify() for archives without an embedded
+// timestamp.
+var ErrNoTimestamp = errors.New("The archive has no embedded timestamp")
+
+// Returned by Detect() for files that are not a ZIP or tar archive.
+var ErrUnknownFormat = errors.New("Not a ZIP or (uncompressed) tar archive")
+
+// Returns the format of the archive.
+func Detect(f *os.File) (Format, error) {
+	var buf [262]byte
+	n, err := f.ReadAt(buf[:], 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if n >= 4 && (bytes.Equal(buf[:4], []byte("PK\x03\x04")) ||
+		bytes.Equal(buf[:4], []byte("PK\x05\x06"))) {
+		return Zip, nil
+	}
+	if n >= 262 && bytes.Equal(buf[257:262], []byte("ustar")) {
+		return Tar, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// A file, directory or link in an archive, for the canonical digest.
+type entry struct {
+	// Path in the archive, without leading ./ or trailing /
+	name string
+
+	// 'f' for a regular file, 'd' for a directory, 'l' for a symbolic
+	// link and 'h' for a hard link
+	kind byte
+
+	// Size of the contents, or the link target
+	size int64
+
+	// SHA-256 of the contents, or the link target
+	digest []byte
+}
+
+// Returns the canonical digest of the contents of the archive, which is
+// the nonce of its embedded timestamp.
+//
+// The digest is the SHA-256 hash of "atum archive\n" followed by, for every
+// file, directory and link sorted by path, the length of its path (as 32-bit
+// big endian), the path, its kind ('f' for a file, 'd' for a directory,
+// 'l' for a symbolic link and 'h' for a hard link), the size (as 64-bit big
+// endian) and the SHA-256 hash of its contents (or target, for links).
+// Other metadata, such as modification times and permissions, and the
+// embedded timestamp are left out.
+func Digest(f *os.File) ([]byte, error) {
+	format, err := Detect(f)
+	if err != nil {
+		return nil, err
+	}
+	var entries []entry
+	switch format {
+	case Zip:
+		entries, err = zipEntries(f)
+	case Tar:
+		entries, _, _, err = tarEntries(f)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+
+	h := sha256.New()
+	var buf [8]byte
+	h.Write([]byte("atum archive\n"))
+	for _, e := range entries {
+		binary.BigEndian.PutUint32(buf[:4], uint32(len(e.name)))
+		h.Write(buf[:4])
+		h.Write([]byte(e.name))
+		h.Write([]byte{e.kind})
+		binary.BigEndian.PutUint64(buf[:], uint64(e.size))
+		h.Write(buf[:])
+		h.Write(e.digest)
+	}
+	return h.Sum(nil), nil
+}
+
+// Returns the SHA-256 hash and size of the contents.
+func hashContents(r io.Reader) ([]byte, int64, error) {
+	h := sha256.New()
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return nil, 0, err
+	}
+	return h.Sum(nil), size, nil
+}
+
+// Returns the timestamp embedded in the archive, or ErrNoTimestamp.
+func Extract(f *os.File) (*atum.Timestamp, error) {
+	format, err := Detect(f)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case Zip:
+		return zipExtract(f)
+	default:
+		return tarExtract(f)
+	}
+}
+
+// Embeds the timestamp in the archive, replacing the one embedded before,
+// if any.  The timestamp should be on the nonce returned by Digest().
+func Embed(f *os.File, ts *atum.Timestamp) error {
+	format, err := Detect(f)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case Zip:
+		return zipEmbed(f, ts)
+	default:
+		return tarEmbed(f, ts)
+	}
+}
+
+// Requests a timestamp on the contents of the archive and embeds it.
+// The other fields of req, such as SigAlgs, are used for the request.
+func Stamp(ctx context.Context, f *os.File, serverUrl string,
+	req atum.Request, opts atum.RequestOptions) (*atum.Timestamp, error) {
+	format, err := Detect(f)
+	if err != nil {
+		return nil, err
+	}
+	if format == Zip {
+		// Don't bother the server if we can't embed the timestamp.
+		if _, err = zipCheckComment(f); err != nil {
+			return nil, err
+		}
+	}
+	if req.Nonce, err = Digest(f); err != nil {
+		return nil, err
+	}
+	ts, err2 := atum.SendRequestContext(ctx, serverUrl, req, opts)
+	if err2 != nil {
+		return nil, err2
+	}
+	if err = Embed(f, ts); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// Checks the timestamp embedded in the archive, which is returned as well.
+//
+// NOTE As with atum.Timestamp.Verify(), you should check that you trust
+//      the server, which is set in Timestamp.ServerUrl.
+func Verify(f *os.File, policy atum.VerificationPolicy) (
+	valid bool, ts *atum.Timestamp, err error) {
+	if ts, err = Extract(f); err != nil {
+		return false, nil, err
+	}
+	nonce, err := Digest(f)
+	if err != nil {
+		return false, ts, err
+	}
+	valid, err2 := ts.VerifyFromWithPolicy(bytes.NewReader(nonce), policy)
+	if err2 != nil {
+		return false, ts, err2
+	}
+	return valid, ts, nil
+}
+
+// Returns an error for a malformed embedded timestamp.
+func parseError(err error) error {
+	return fmt.Errorf("Failed to parse embedded timestamp: %v", err)
+}
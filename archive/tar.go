@@ -0,0 +1,151 @@
+package archive
+
+import (
+	"github.com/bwesterb/go-atum"
+
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Size of the blocks of a tar archive.
+const tarBlockSize = 512
+
+// Embedded timestamps larger than this are not believed.
+const maxStampSize = 1 << 24
+
+// Counts the bytes read, to find the offsets of the entries.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// Returns the files, directories and links in the tar archive except for
+// the embedded timestamp, the contents of the embedded timestamp, if any,
+// and the offset at which it starts or, if there is none, at which the
+// archive ends.
+func tarEntries(f *os.File) (entries []entry, stamp []byte, end int64,
+	err error) {
+	cr := &countingReader{r: io.NewSectionReader(f, 0, 1<<62)}
+	tr := tar.NewReader(cr)
+	stampAt := int64(-1)
+	for {
+		// The entry starts at the next block after the previous one.
+		start := (cr.n + tarBlockSize - 1) / tarBlockSize * tarBlockSize
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			end = start
+			break
+		}
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		name := normalizeName(hdr.Name)
+		stampAt = -1
+
+		e := entry{name: name}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			e.kind = 'f'
+		case tar.TypeDir:
+			if name == "" {
+				continue // the root directory
+			}
+			e.kind = 'd'
+		case tar.TypeSymlink:
+			e.kind = 'l'
+		case tar.TypeLink:
+			e.kind = 'h'
+		default:
+			continue // devices, fifos and global headers
+		}
+
+		if e.kind == 'l' || e.kind == 'h' {
+			e.digest, e.size, err = hashContents(
+				bytes.NewReader([]byte(hdr.Linkname)))
+		} else if e.kind == 'f' && name == StampName &&
+			hdr.Size <= maxStampSize {
+			// Perhaps the embedded timestamp, if it's the last entry.
+			stamp, err = ioutil.ReadAll(tr)
+			h := sha256.Sum256(stamp)
+			e.digest, e.size = h[:], int64(len(stamp))
+			stampAt = start
+		} else {
+			e.digest, e.size, err = hashContents(tr)
+		}
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("%s: %v", hdr.Name, err)
+		}
+		entries = append(entries, e)
+	}
+
+	if stampAt == -1 {
+		return entries, nil, end, nil
+	}
+	return entries[:len(entries)-1], stamp, stampAt, nil
+}
+
+func tarExtract(f *os.File) (*atum.Timestamp, error) {
+	_, stamp, _, err := tarEntries(f)
+	if err != nil {
+		return nil, err
+	}
+	if stamp == nil {
+		return nil, ErrNoTimestamp
+	}
+	var ts atum.Timestamp
+	if err = json.Unmarshal(stamp, &ts); err != nil {
+		return nil, parseError(err)
+	}
+	return &ts, nil
+}
+
+// Writes the timestamp as the last entry of the tar archive, replacing the
+// embedded timestamp that was there, if any.
+func tarEmbed(f *os.File, ts *atum.Timestamp) error {
+	_, _, end, err := tarEntries(f)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(ts)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Seek(end, io.SeekStart); err != nil {
+		return err
+	}
+	tw := tar.NewWriter(f)
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     StampName,
+		Mode:     0644,
+		Size:     int64(len(buf)),
+		ModTime:  ts.GetTime(),
+	})
+	if err == nil {
+		_, err = tw.Write(buf)
+	}
+	if err == nil {
+		err = tw.Close() // writes the end of the archive
+	}
+	if err != nil {
+		return err
+	}
+	// Remove what was left of the previous end of the archive.
+	end, err = f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	return f.Truncate(end)
+}
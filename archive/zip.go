@@ -0,0 +1,159 @@
+package archive
+
+import (
+	"github.com/bwesterb/go-atum"
+
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// The comment of a ZIP archive with an embedded timestamp is this prefix
+// followed by the timestamp as JSON.
+const zipCommentPrefix = "atum timestamp\n"
+
+// Size of the end of central directory record without the comment.
+const zipEocdSize = 22
+
+// Returns the files, directories and links in the ZIP archive.
+func zipEntries(f *os.File) ([]entry, error) {
+	size, err := fileSize(f)
+	if err != nil {
+		return nil, err
+	}
+	r, err := zip.NewReader(f, size)
+	if err != nil {
+		return nil, err
+	}
+	var ret []entry
+	for _, zf := range r.File {
+		e := entry{name: normalizeName(zf.Name), kind: 'f'}
+		if e.name == "" {
+			continue
+		}
+		mode := zf.Mode()
+		if mode.IsDir() || strings.HasSuffix(zf.Name, "/") {
+			e.kind = 'd'
+		} else if mode&os.ModeSymlink != 0 {
+			e.kind = 'l' // the contents are the target
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", zf.Name, err)
+		}
+		e.digest, e.size, err = hashContents(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", zf.Name, err)
+		}
+		ret = append(ret, e)
+	}
+	return ret, nil
+}
+
+// Returns the offset of the end of central directory record and the
+// comment of the ZIP archive.
+func zipComment(f *os.File) (int64, []byte, error) {
+	size, err := fileSize(f)
+	if err != nil {
+		return 0, nil, err
+	}
+	// The comment is at most 65535 bytes.
+	start := size - zipEocdSize - 65535
+	if start < 0 {
+		start = 0
+	}
+	buf, err := ioutil.ReadAll(io.NewSectionReader(f, start, size-start))
+	if err != nil {
+		return 0, nil, err
+	}
+	for i := len(buf) - zipEocdSize; i >= 0; i-- {
+		if !bytes.Equal(buf[i:i+4], []byte("PK\x05\x06")) {
+			continue
+		}
+		commentLen := int(binary.LittleEndian.Uint16(buf[i+20 : i+22]))
+		if i+zipEocdSize+commentLen == len(buf) {
+			return start + int64(i), buf[i+zipEocdSize:], nil
+		}
+	}
+	return 0, nil, fmt.Errorf("Failed to find the end of the ZIP archive")
+}
+
+func zipExtract(f *os.File) (*atum.Timestamp, error) {
+	_, comment, err := zipComment(f)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(comment, []byte(zipCommentPrefix)) {
+		return nil, ErrNoTimestamp
+	}
+	var ts atum.Timestamp
+	if err = json.Unmarshal(comment[len(zipCommentPrefix):], &ts); err != nil {
+		return nil, parseError(err)
+	}
+	return &ts, nil
+}
+
+// Checks that the comment of the ZIP archive can be replaced by
+// a timestamp.  Returns the offset of the end of central directory record.
+func zipCheckComment(f *os.File) (int64, error) {
+	eocd, comment, err := zipComment(f)
+	if err != nil {
+		return 0, err
+	}
+	if len(comment) != 0 &&
+		!bytes.HasPrefix(comment, []byte(zipCommentPrefix)) {
+		return 0, fmt.Errorf(
+			"The archive has a comment, which I won't overwrite")
+	}
+	return eocd, nil
+}
+
+// Replaces the comment of the ZIP archive by the timestamp.
+func zipEmbed(f *os.File, ts *atum.Timestamp) error {
+	eocd, err := zipCheckComment(f)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(ts)
+	if err != nil {
+		return err
+	}
+	comment := append([]byte(zipCommentPrefix), buf...)
+	if len(comment) > 65535 {
+		return fmt.Errorf("The timestamp is too large for a ZIP comment")
+	}
+	var lenBuf [2]byte
+	binary.LittleEndian.PutUint16(lenBuf[:], uint16(len(comment)))
+	if _, err = f.WriteAt(append(lenBuf[:], comment...), eocd+20); err != nil {
+		return err
+	}
+	return f.Truncate(eocd + zipEocdSize + int64(len(comment)))
+}
+
+// Removes a leading ./ or / and trailing / from the path of an entry.
+// Returns the empty string for the root directory, which is skipped.
+func normalizeName(name string) string {
+	for strings.HasPrefix(name, "./") {
+		name = name[2:]
+	}
+	name = strings.Trim(name, "/")
+	if name == "." {
+		return ""
+	}
+	return name
+}
+
+func fileSize(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
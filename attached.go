@@ -0,0 +1,164 @@
+package atum
+
+import (
+	"golang.org/x/crypto/sha3"
+
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+)
+
+// A timestamp bundled with the message it is on, so that it can be verified
+// without keeping the message and timestamp together.  For large messages,
+// the message can be left out, in which case only its name, size and digest
+// are bundled.  The timestamp is on these: it shows that a message with that
+// name, size and digest existed at the time of the timestamp.
+type AttachedTimestamp struct {
+	// Name of the file the message was read from, if any
+	Name string `json:",omitempty"`
+
+	// Size of the message in bytes
+	Size int64
+
+	// The hash function used for the digest
+	Hash Hash
+
+	// Digest of the message
+	Digest []byte
+
+	// The message itself, if it is attached
+	Data []byte `json:",omitempty"`
+
+	// Timestamp on the name, size and digest.  See Message().
+	Timestamp Timestamp
+}
+
+// Reads the message and returns an AttachedTimestamp with its name, size and
+// digest, but without a timestamp yet.  If attach is set, the message itself
+// is included as well.
+func NewAttachedTimestamp(name string, msg io.Reader, attach bool) (
+	*AttachedTimestamp, Error) {
+	ret := AttachedTimestamp{
+		Name: name,
+		Hash: Shake256,
+	}
+	var buf bytes.Buffer
+	if attach {
+		msg = io.TeeReader(msg, &buf)
+	}
+	var err Error
+	if ret.Size, ret.Digest, err = computeDigest(ret.Hash, msg); err != nil {
+		return nil, err
+	}
+	if attach {
+		ret.Data = buf.Bytes()
+	}
+	return &ret, nil
+}
+
+// Reads the message and creates an attached timestamp for it.
+//
+// For more flexibility, use NewAttachedTimestamp() and Stamp().
+func StampAttached(serverUrl, name string, msg io.Reader, attach bool) (
+	*AttachedTimestamp, Error) {
+	ret, err := NewAttachedTimestamp(name, msg, attach)
+	if err != nil {
+		return nil, err
+	}
+	if err = ret.Stamp(context.Background(), serverUrl, Request{},
+		RequestOptions{}); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Requests the timestamp on the name, size and digest.  The other fields
+// of req, such as SigAlgs, are used for the request.
+func (a *AttachedTimestamp) Stamp(ctx context.Context, serverUrl string,
+	req Request, opts RequestOptions) Error {
+	hashing := Hashing{
+		Hash:   Shake256,
+		Prefix: make([]byte, 32),
+	}
+	rand.Read(hashing.Prefix)
+	var err Error
+	req.Nonce, err = hashing.ComputeNonce(bytes.NewReader(a.Message()))
+	if err != nil {
+		return err
+	}
+	ts, err := SendRequestContext(ctx, serverUrl, req, opts)
+	if err != nil {
+		return err
+	}
+	ts.Hashing = &hashing
+	a.Timestamp = *ts
+	return nil
+}
+
+// Returns the message the timestamp is on, which encodes the name,
+// size and digest.
+func (a *AttachedTimestamp) Message() []byte {
+	var buf bytes.Buffer
+	var tmp [8]byte
+	buf.WriteString("atum attached\n")
+	binary.BigEndian.PutUint32(tmp[:4], uint32(len(a.Name)))
+	buf.Write(tmp[:4])
+	buf.WriteString(a.Name)
+	binary.BigEndian.PutUint64(tmp[:], uint64(a.Size))
+	buf.Write(tmp[:])
+	binary.BigEndian.PutUint32(tmp[:4], uint32(len(a.Hash)))
+	buf.Write(tmp[:4])
+	buf.WriteString(string(a.Hash))
+	buf.Write(a.Digest)
+	return buf.Bytes()
+}
+
+// Verifies the timestamp and, if the message is attached, that it matches
+// the digest.
+//
+// NOTE As with Timestamp.Verify(), you should check that you trust the
+//      server, which is set in Timestamp.ServerUrl.
+func (a *AttachedTimestamp) Verify() (valid bool, err Error) {
+	return a.VerifyWithPolicy(VerificationPolicy{})
+}
+
+// Like Verify(), but with the given policy.
+func (a *AttachedTimestamp) VerifyWithPolicy(policy VerificationPolicy) (
+	valid bool, err Error) {
+	if a.Data != nil {
+		if valid, err = a.Matches(bytes.NewReader(a.Data)); !valid {
+			return false, err
+		}
+	}
+	return a.Timestamp.VerifyFromWithPolicy(bytes.NewReader(a.Message()),
+		policy)
+}
+
+// Returns whether the message read from msg has the size and digest of the
+// attached timestamp.  Use this to check a message that is not attached.
+func (a *AttachedTimestamp) Matches(msg io.Reader) (bool, Error) {
+	size, digest, err := computeDigest(a.Hash, msg)
+	if err != nil {
+		return false, err
+	}
+	return size == a.Size && bytes.Equal(digest, a.Digest), nil
+}
+
+// Returns the size and digest of the message.
+func computeDigest(hash Hash, msg io.Reader) (int64, []byte, Error) {
+	switch hash {
+	case Shake256:
+		ret := make([]byte, 64)
+		shake := sha3.NewShake256()
+		size, err := io.Copy(shake, msg)
+		if err != nil {
+			return 0, nil, wrapErrorf(err, "hashing failed")
+		}
+		shake.Read(ret)
+		return size, ret, nil
+	default:
+		return 0, nil, errorf("Hash %s not supported", hash)
+	}
+}
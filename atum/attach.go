@@ -0,0 +1,189 @@
+package main
+
+import (
+	"github.com/bwesterb/go-atum"
+
+	"github.com/urfave/cli"
+
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Returns the attached timestamp in buf, or nil if it is not one.
+func parseAttached(buf []byte) *atum.AttachedTimestamp {
+	var a atum.AttachedTimestamp
+	if err := json.Unmarshal(buf, &a); err != nil {
+		return nil
+	}
+	if len(a.Digest) == 0 || a.Timestamp.ServerUrl == "" {
+		return nil
+	}
+	return &a
+}
+
+// Puts a timestamp on the file or the data read from stdin, and writes it
+// together with the data (or its digest, if it is large) to FILE.atum.
+func cmdStampAttached(c *cli.Context, stdin bool) error {
+	for _, flag := range []string{"hex-nonce", "base64-nonce", "recursive",
+		"embed"} {
+		if c.IsSet(flag) {
+			return cli.NewExitError(fmt.Sprintf(
+				"--%s can't be combined with --attach", flag), exitUsage)
+		}
+	}
+	if c.IsSet("file") == stdin {
+		return cli.NewExitError(
+			"--attach requires either --file or data on stdin", exitUsage)
+	}
+
+	var msg io.Reader = os.Stdin
+	var name string
+	if c.IsSet("file") {
+		file, err := os.Open(c.String("file"))
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Failed to open file: %v",
+				err), exitIO)
+		}
+		defer file.Close()
+		msg = file
+		name = filepath.Base(c.String("file"))
+	}
+
+	// Only attach the data if it's small enough.
+	limit := c.Int64("attach-max-size")
+	buf, err := ioutil.ReadAll(io.LimitReader(msg, limit+1))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Failed to read data: %v", err),
+			exitIO)
+	}
+	attach := int64(len(buf)) <= limit
+	a, err2 := atum.NewAttachedTimestamp(name,
+		io.MultiReader(bytes.NewReader(buf), msg), attach)
+	if err2 != nil {
+		return cli.NewExitError(fmt.Sprintf("Failed to read data: %v", err2),
+			exitIO)
+	}
+
+	var req atum.Request
+	requestFromFlags(c, &req)
+	ctx, cancel := interruptContext()
+	defer cancel()
+	opts, done := requestOptionsFromFlags(c)
+	err2 = a.Stamp(ctx, c.String("server"), req, opts)
+	done()
+	if err2 != nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to create timestamp: %v", err2), exitServer)
+	}
+
+	aBuf, err := json.Marshal(a)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to convert timestamp to JSON: %v", err), exitIO)
+	}
+	outFile := c.String("output")
+	if outFile == "" && c.IsSet("file") {
+		outFile = c.String("file") + ".atum"
+	}
+	if outFile == "" {
+		os.Stdout.Write(aBuf)
+		os.Stdout.Write([]byte{10})
+		return nil
+	}
+	if err = ioutil.WriteFile(outFile, aBuf, 0644); err != nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to write to %s: %v", outFile, err), exitIO)
+	}
+	reportStamped(c, c.String("file"), outFile, &a.Timestamp)
+	return nil
+}
+
+// Checks the attached timestamp and, if data is set, that it is the
+// message of the timestamp.  On failure, returns the exit code as well.
+func verifyAttached(c *cli.Context, a *atum.AttachedTimestamp, data io.Reader,
+	policy atum.VerificationPolicy) (int, error) {
+	if err := checkServer(c, &a.Timestamp); err != nil {
+		return exitInvalid, err
+	}
+	if data != nil {
+		ok, err := a.Matches(data)
+		if err != nil {
+			return exitIO, err
+		}
+		if !ok {
+			return exitInvalid, fmt.Errorf("The data does not match the timestamp")
+		}
+	}
+	valid, err := a.VerifyWithPolicy(policy)
+	if err != nil {
+		return exitServer, err
+	}
+	if !valid {
+		return exitInvalid, fmt.Errorf("Invalid signature")
+	}
+	return 0, nil
+}
+
+// Writes the data attached to a timestamp.
+func cmdExtract(c *cli.Context) error {
+	if c.NArg() > 1 {
+		return cli.NewExitError("I expect at most one timestamp", exitUsage)
+	}
+	var buf []byte
+	var err error
+	path := c.Args().First()
+	if path == "" || path == "-" {
+		buf, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		buf, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to read timestamp: %v", err), exitIO)
+	}
+	a := parseAttached(buf)
+	if a == nil {
+		return cli.NewExitError(
+			"This is not a timestamp with attached data", exitParse)
+	}
+	if a.Data == nil && a.Size != 0 {
+		return cli.NewExitError(
+			"The data is not attached to this timestamp; only its digest",
+			exitUsage)
+	}
+	if ok, _ := a.Matches(bytes.NewReader(a.Data)); !ok {
+		return cli.NewExitError(
+			"The attached data does not match the timestamp", exitInvalid)
+	}
+
+	outFile := c.String("output")
+	if outFile == "" && a.Name != "" {
+		outFile = filepath.Base(a.Name)
+	}
+	if outFile == "" || outFile == "-" {
+		os.Stdout.Write(a.Data)
+		return nil
+	}
+	file, err := os.OpenFile(outFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return cli.NewExitError(fmt.Sprintf(
+			"%s already exists; I won't overwrite it", outFile), exitIO)
+	}
+	if err == nil {
+		_, err = file.Write(a.Data)
+		if err2 := file.Close(); err == nil {
+			err = err2
+		}
+	}
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to write to %s: %v", outFile, err), exitIO)
+	}
+	fmt.Printf("Extracted %s (%d bytes)\n", outFile, len(a.Data))
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"github.com/bwesterb/go-atum"
+	"github.com/bwesterb/go-atum/tlog"
+
+	"github.com/urfave/cli"
+
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Size of an entry in the transparency log.  See atum.Timestamp.LogEntry().
+const logEntrySize = 8 + 32 + 32
+
+func cmdAudit(c *cli.Context) error {
+	if c.NArg() != 0 {
+		return cli.NewExitError("I don't expect arguments; only flags", exitUsage)
+	}
+	if !c.IsSet("dir") {
+		return cli.NewExitError("Please specify --dir", exitUsage)
+	}
+	dir := c.String("dir")
+	headPath := filepath.Join(dir, "treehead.json")
+	serverUrl := c.String("server")
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return cli.NewExitError(fmt.Sprintf("os.MkdirAll(%s): %v", dir, err), exitIO)
+	}
+	l, err := tlog.OpenLog(filepath.Join(dir, "entries"))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("tlog.OpenLog(): %v", err), exitIO)
+	}
+	defer l.Close()
+
+	// Check the local copy against the tree head we verified last time.
+	var head *atum.TreeHead
+	headBuf, err := ioutil.ReadFile(headPath)
+	if err == nil {
+		head = new(atum.TreeHead)
+		if err = json.Unmarshal(headBuf, head); err != nil {
+			return cli.NewExitError(fmt.Sprintf(
+				"Failed to parse %s: %v", headPath, err), exitParse)
+		}
+		if err = checkRoot(l, head); err != nil {
+			return cli.NewExitError(fmt.Sprintf(
+				"Local copy of the log does not match %s: %v",
+				headPath, err), exitInvalid)
+		}
+	} else if !os.IsNotExist(err) {
+		return cli.NewExitError(fmt.Sprintf(
+			"ioutil.ReadFile(%s): %v", headPath, err), exitIO)
+	}
+
+	if !c.Bool("offline") {
+		newHead, err := atum.FetchTreeHead(serverUrl)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf(
+				"Failed to fetch tree head: %v", err), exitServer)
+		}
+		if newHead == nil {
+			return cli.NewExitError(fmt.Sprintf(
+				"%s does not keep a transparency log", serverUrl), exitServer)
+		}
+
+		// Download the entries we lack.
+		for l.Size() < newHead.Size {
+			entries, err := atum.FetchLogEntries(serverUrl, l.Size(),
+				newHead.Size)
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf(
+					"Failed to fetch log entries: %v", err), exitServer)
+			}
+			if len(entries) == 0 {
+				return cli.NewExitError("Server returned no log entries", exitServer)
+			}
+			for _, entry := range entries {
+				if _, err := l.Append(entry); err != nil {
+					return cli.NewExitError(fmt.Sprintf(
+						"Failed to store log entry: %v", err), exitIO)
+				}
+			}
+		}
+
+		// As the local copy matched the previous tree head, this also
+		// shows the new tree head is consistent with the previous one.
+		if err := checkRoot(l, newHead); err != nil {
+			return cli.NewExitError(fmt.Sprintf(
+				"Log is inconsistent with tree head of %s: %v",
+				serverUrl, err), exitInvalid)
+		}
+
+		if head == nil || newHead.Size >= head.Size {
+			head = newHead
+			headBuf, _ := json.Marshal(head)
+			if err := ioutil.WriteFile(headPath, headBuf, 0600); err != nil {
+				return cli.NewExitError(fmt.Sprintf(
+					"ioutil.WriteFile(%s): %v", headPath, err), exitIO)
+			}
+		}
+	}
+
+	if head == nil {
+		return cli.NewExitError("There is no local copy of the log to audit", exitUsage)
+	}
+
+	// Check the entries themselves.  Entries are appended in the order
+	// the timestamps are set, so an entry with a time far before those
+	// of the entries preceding it was backdated beyond the acceptable lag.
+	entries, err := l.Entries(0, l.Size())
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Failed to read log: %v", err), exitIO)
+	}
+	lag := int64(c.Int("lag"))
+	var maxTime int64
+	var problems int
+	for i, entry := range entries {
+		if len(entry) != logEntrySize {
+			fmt.Printf("Entry %d is malformed\n", i)
+			problems++
+			continue
+		}
+		t := int64(binary.BigEndian.Uint64(entry))
+		if t+2*lag < maxTime {
+			fmt.Printf("Entry %d is backdated to %s\n", i, time.Unix(t, 0))
+			problems++
+		}
+		if t > maxTime {
+			maxTime = t
+		}
+	}
+
+	fmt.Printf("The log has %d entries and matches the tree head signed at %s\n",
+		l.Size(), time.Unix(head.Time, 0))
+	if problems != 0 {
+		return cli.NewExitError(fmt.Sprintf(
+			"Found %d suspicious entries", problems), exitInvalid)
+	}
+	return nil
+}
+
+// Checks that the first entries of the log have the root hash of the tree head.
+func checkRoot(l *tlog.Log, head *atum.TreeHead) error {
+	if l.Size() < head.Size {
+		return fmt.Errorf("log has %d entries instead of %d",
+			l.Size(), head.Size)
+	}
+	root, err := l.RootHash(head.Size)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(root, head.RootHash) {
+		return fmt.Errorf("root hash differs")
+	}
+	return nil
+}
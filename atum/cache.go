@@ -0,0 +1,239 @@
+package main
+
+import (
+	"github.com/bwesterb/go-atum"
+
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli"
+
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Server URLs are stored with a trailing slash in the cache.
+func normalizeServerUrl(serverUrl string) string {
+	if serverUrl != "" && !strings.HasSuffix(serverUrl, "/") {
+		return serverUrl + "/"
+	}
+	return serverUrl
+}
+
+// Returns the cache, if it can list and forget what it has cached.
+func enumerableCache() (atum.EnumerableCache, error) {
+	c, ok := atum.GetCache().(atum.EnumerableCache)
+	if !ok {
+		return nil, cli.NewExitError(
+			"The cache can't list or purge its contents", exitIO)
+	}
+	return c, nil
+}
+
+// Lists the servers in the cache and what is cached on them.
+func cmdCacheList(c *cli.Context) error {
+	if c.NArg() != 0 {
+		return cli.NewExitError("I don't expect arguments", exitUsage)
+	}
+	cache, err := enumerableCache()
+	if err != nil {
+		return err
+	}
+	entries := cache.Entries()
+	if len(entries) == 0 {
+		fmt.Println("The cache is empty")
+		return nil
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		var what []string
+		if len(entry.PublicKeys) != 0 {
+			expired := 0
+			for _, pk := range entry.PublicKeys {
+				if !pk.Expires.After(now) {
+					expired++
+				}
+			}
+			what = append(what, fmt.Sprintf("%d public keys (%d expired)",
+				len(entry.PublicKeys), expired))
+		}
+		if len(entry.Revocations) != 0 {
+			what = append(what, fmt.Sprintf("%d revoked",
+				len(entry.Revocations)))
+		}
+		if entry.ServerInfo != nil {
+			what = append(what, "server info")
+		}
+		if entry.KeyHistory != nil {
+			what = append(what, "key history")
+		}
+		if entry.TreeHead != nil {
+			what = append(what, fmt.Sprintf("tree head of size %d",
+				entry.TreeHead.Size))
+		}
+		fmt.Printf("%s\n  %s\n", entry.ServerUrl, strings.Join(what, ", "))
+	}
+	return nil
+}
+
+// Shows everything cached on a server.
+func cmdCacheShow(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError("I expect a single server URL", exitUsage)
+	}
+	cache, err := enumerableCache()
+	if err != nil {
+		return err
+	}
+	serverUrl := normalizeServerUrl(c.Args().First())
+	var entry *atum.CacheEntry
+	for _, e := range cache.Entries() {
+		if e.ServerUrl == serverUrl {
+			entry = &e
+			break
+		}
+	}
+	if entry == nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Nothing is cached on %s", serverUrl), exitUsage)
+	}
+
+	fmt.Printf("Server:        %s\n", entry.ServerUrl)
+	if info := entry.ServerInfo; info != nil {
+		fmt.Println()
+		fmt.Printf("Server info\n")
+		fmt.Printf("  Default alg: %s\n", info.DefaultSigAlg)
+		fmt.Printf("  Nonce size:  at most %d bytes\n", info.MaxNonceSize)
+		fmt.Printf("  Lag:         at most %d seconds\n", info.AcceptableLag)
+		for alg, req := range info.RequiredProofOfWork {
+			fmt.Printf("  Work:        difficulty %d for %s\n",
+				req.Difficulty, alg)
+		}
+	}
+
+	now := time.Now()
+	for _, pk := range entry.PublicKeys {
+		fmt.Println()
+		fmt.Printf("Public key\n")
+		fmt.Printf("  Algorithm:   %s\n", pk.Alg)
+		fmt.Printf("  Public key:  %s\n",
+			base64.StdEncoding.EncodeToString(pk.PublicKey))
+		fmt.Printf("  Fingerprint: %s\n", fingerprint(pk.PublicKey))
+		if pk.Expires.After(now) {
+			fmt.Printf("  Trusted:     until %s (%s)\n", pk.Expires,
+				humanize.Time(pk.Expires))
+		} else {
+			fmt.Printf("  Trusted:     no; expired %s\n",
+				humanize.Time(pk.Expires))
+		}
+	}
+
+	for _, rev := range entry.Revocations {
+		fmt.Println()
+		fmt.Printf("Revoked public key\n")
+		fmt.Printf("  Algorithm:   %s\n", rev.Alg)
+		fmt.Printf("  Public key:  %s\n",
+			base64.StdEncoding.EncodeToString(rev.PublicKey))
+		fmt.Printf("  Fingerprint: %s\n", fingerprint(rev.PublicKey))
+		fmt.Printf("  Revoked at:  %s\n", rev.Revocation.RevokedAt)
+		fmt.Printf("  Reason:      %s\n", rev.Revocation.Reason)
+	}
+
+	if history := entry.KeyHistory; history != nil {
+		fmt.Println()
+		fmt.Printf("Key history\n")
+		fmt.Printf("  Keys:        %d\n", len(history.Keys))
+		fmt.Printf("  Issued:      %s\n", time.Unix(history.Issued, 0))
+		fmt.Printf("  Expires:     %s\n", time.Unix(history.Expires, 0))
+	}
+
+	if head := entry.TreeHead; head != nil {
+		fmt.Println()
+		fmt.Printf("Tree head\n")
+		fmt.Printf("  Size:        %d\n", head.Size)
+		fmt.Printf("  Root hash:   %s\n",
+			base64.StdEncoding.EncodeToString(head.RootHash))
+		fmt.Printf("  Signed:      %s\n", time.Unix(head.Time, 0))
+	}
+	return nil
+}
+
+// Removes entries from the cache.
+func cmdCachePurge(c *cli.Context) error {
+	if c.NArg() != 0 {
+		return cli.NewExitError("I don't expect arguments; only flags",
+			exitUsage)
+	}
+	cache, err := enumerableCache()
+	if err != nil {
+		return err
+	}
+	n := cache.Purge(normalizeServerUrl(c.String("server")), c.Bool("expired"))
+	fmt.Printf("Purged %d records\n", n)
+	return nil
+}
+
+// Writes the contents of the cache as JSON.
+func cmdCacheExport(c *cli.Context) error {
+	if c.NArg() > 1 {
+		return cli.NewExitError("I expect at most one file", exitUsage)
+	}
+	cache, err := enumerableCache()
+	if err != nil {
+		return err
+	}
+	entries := cache.Entries()
+	if entries == nil {
+		entries = []atum.CacheEntry{}
+	}
+	buf, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to serialize cache: %v", err), exitIO)
+	}
+	buf = append(buf, '\n')
+	path := c.Args().First()
+	if path == "" || path == "-" {
+		_, err = os.Stdout.Write(buf)
+	} else {
+		err = ioutil.WriteFile(path, buf, 0644)
+	}
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to write cache: %v", err), exitIO)
+	}
+	return nil
+}
+
+// Adds the entries written by atum cache export to the cache.
+func cmdCacheImport(c *cli.Context) error {
+	if c.NArg() > 1 {
+		return cli.NewExitError("I expect at most one file", exitUsage)
+	}
+	var buf []byte
+	var err error
+	path := c.Args().First()
+	if path == "" || path == "-" {
+		buf, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		buf, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to read cache: %v", err), exitIO)
+	}
+	var entries []atum.CacheEntry
+	if err = json.Unmarshal(buf, &entries); err != nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to parse cache: %v", err), exitParse)
+	}
+	for i := range entries {
+		entries[i].ServerUrl = normalizeServerUrl(entries[i].ServerUrl)
+	}
+	atum.ImportCacheEntries(atum.GetCache(), entries)
+	fmt.Printf("Imported %d servers\n", len(entries))
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"github.com/bwesterb/go-atum"
+
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli"
+
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Checks whether the public key of a server, or the public keys on
+// a timestamp, are trusted.
+func cmdCheckKey(c *cli.Context) error {
+	if c.NArg() != 0 {
+		return cli.NewExitError("I don't expect arguments; only flags", exitUsage)
+	}
+
+	var serverUrl string
+	var sigs []atum.Signature
+	var ts *atum.Timestamp
+	if c.IsSet("timestamp") {
+		for _, flag := range []string{"server", "alg", "pk"} {
+			if c.IsSet(flag) {
+				return cli.NewExitError(fmt.Sprintf(
+					"--%s can't be combined with --timestamp", flag), exitUsage)
+			}
+		}
+		tsBuf, err := ioutil.ReadFile(c.String("timestamp"))
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf(
+				"Failed to read timestamp: %v", err), exitIO)
+		}
+		ts = new(atum.Timestamp)
+		if err = json.Unmarshal(tsBuf, ts); err != nil {
+			return cli.NewExitError(fmt.Sprintf(
+				"Failed to parse timestamp: %v", err), exitParse)
+		}
+		serverUrl = ts.ServerUrl
+		sigs = ts.Signatures()
+	} else {
+		if !c.IsSet("server") || !c.IsSet("pk") {
+			return cli.NewExitError(
+				"Please specify --server and --pk, or --timestamp", exitUsage)
+		}
+		pk, err := parsePublicKey(c.String("pk"))
+		if err != nil {
+			return cli.NewExitError(err.Error(), exitUsage)
+		}
+		serverUrl = c.String("server")
+		sigs = []atum.Signature{{
+			Alg:       atum.SignatureAlgorithm(c.String("alg")),
+			PublicKey: pk,
+		}}
+	}
+	serverUrl = normalizeServerUrl(serverUrl)
+
+	code := 0
+	for i, sig := range sigs {
+		if i != 0 {
+			fmt.Println()
+		}
+		keyCode := checkKey(c, serverUrl, sig.Alg, sig.PublicKey, ts)
+		if code == 0 {
+			code = keyCode
+		}
+	}
+	if code != 0 {
+		return cli.NewExitError("", code)
+	}
+	return nil
+}
+
+// Parses a hex or base64 encoded public key.
+func parsePublicKey(s string) ([]byte, error) {
+	if pk, err := hex.DecodeString(s); err == nil {
+		return pk, nil
+	}
+	if pk, err := base64.StdEncoding.DecodeString(s); err == nil {
+		return pk, nil
+	}
+	return nil, fmt.Errorf("--pk should be hex or base64 encoded")
+}
+
+// Prints what the cache and the server say about the public key.  If ts
+// is set, also checks whether the key can be trusted for that timestamp.
+// Returns the exit code.
+func checkKey(c *cli.Context, serverUrl string, alg atum.SignatureAlgorithm,
+	pk []byte, ts *atum.Timestamp) int {
+	fmt.Printf("Server:        %s\n", serverUrl)
+	fmt.Printf("Algorithm:     %s\n", alg)
+	fmt.Printf("Public key:    %s\n", base64.StdEncoding.EncodeToString(pk))
+	fmt.Printf("Fingerprint:   %s\n", fingerprint(pk))
+
+	now := time.Now()
+	cache := atum.GetCache()
+	var rev *atum.PublicKeyRevocation
+	if xc, ok := cache.(atum.ExtendedCache); ok {
+		rev = xc.GetRevocation(serverUrl, alg, pk)
+	}
+	expires := cache.GetPublicKey(serverUrl, alg, pk)
+	if rev != nil {
+		fmt.Printf("Cache:         revoked at %s (%s)\n", rev.RevokedAt,
+			rev.Reason)
+	} else if expires == nil {
+		fmt.Printf("Cache:         not cached\n")
+	} else if expires.After(now) {
+		fmt.Printf("Cache:         trusted until %s (%s)\n", *expires,
+			humanize.Time(*expires))
+	} else {
+		fmt.Printf("Cache:         trusted until %s, which has passed\n",
+			*expires)
+	}
+
+	var resp *atum.PublicKeyCheckResponse
+	source := "cache"
+	if !c.Bool("no-cache") && rev != nil {
+		resp = &atum.PublicKeyCheckResponse{
+			Revoked:          true,
+			RevokedAt:        &rev.RevokedAt,
+			RevocationReason: rev.Reason,
+		}
+	} else if !c.Bool("no-cache") && expires != nil && expires.After(now) {
+		resp = &atum.PublicKeyCheckResponse{
+			Trusted: true,
+			Expires: *expires,
+		}
+	} else {
+		var err atum.Error
+		resp, err = atum.CheckPublicKey(serverUrl, alg, pk)
+		if err != nil {
+			fmt.Printf("Status:        failed to ask the server: %v\n", err)
+			return exitServer
+		}
+		source = "server"
+	}
+
+	if rev := resp.Revocation(); rev != nil {
+		fmt.Printf("Status:        revoked at %s (%s), according to the %s\n",
+			rev.RevokedAt, rev.Reason, source)
+		if ts == nil {
+			return exitInvalid
+		}
+		if rev.Allows(ts.Time) {
+			fmt.Printf("Timestamp:     trusted, as it was set before the key was retired\n")
+			return 0
+		}
+		fmt.Printf("Timestamp:     not trusted\n")
+		return exitInvalid
+	}
+	if !resp.Trusted {
+		fmt.Printf("Status:        not trusted, according to the %s\n", source)
+		return exitInvalid
+	}
+	fmt.Printf("Status:        trusted, according to the %s\n", source)
+	fmt.Printf("Expires:       %s (%s)\n", resp.Expires,
+		humanize.Time(resp.Expires))
+	return 0
+}
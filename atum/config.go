@@ -0,0 +1,205 @@
+package main
+
+import (
+	"github.com/bwesterb/go-atum"
+
+	"github.com/BurntSushi/toml"
+	"github.com/urfave/cli"
+
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The Atum server used if neither the flags nor the configuration file
+// set one.  Hosted by SIDN.nl.
+const defaultServerUrl = "https://keyshare.privacybydesign.foundation/atumd"
+
+// The flags whose default is set by each setting of the configuration
+// file, as command/flag, where command may include a subcommand, such as
+// "git stamp".  The environment variables are set on the flags themselves
+// in main.go, which urfave/cli lets take precedence over the defaults.
+var configFlags = map[string][]string{
+	"server":           {"stamp/server", "audit/server", "git stamp/server", "watch/server"},
+	"alg":              {"stamp/alg", "git stamp/alg", "watch/alg"},
+	"hash":             {"stamp/hash", "git stamp/hash", "watch/hash"},
+	"trusted-servers":  {"verify/trusted-server", "git verify/trusted-server"},
+	"witnesses":        {"verify/witness", "git verify/witness"},
+	"min-cosignatures": {"verify/min-cosignatures", "git verify/min-cosignatures"},
+	"output-format":    {"stamp/output-format", "verify/output-format"},
+}
+
+// The lists set in the configuration file, by command/flag.  See
+// stringSliceFlag().
+var configSlices = make(map[string][]string)
+
+// Returns the values of the string slice flag, or the list set in the
+// configuration file if the flag is not set.  The configuration file can't
+// set the default of these flags, as urfave/cli would add the values passed
+// on the command line to it instead of replacing it.
+func stringSliceFlag(c *cli.Context, name string) []string {
+	if c.IsSet(name) {
+		return c.StringSlice(name)
+	}
+	return configSlices[c.Command.FullName()+"/"+name]
+}
+
+// Returns the path of the configuration file used if --config is not set.
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "atum", "config.toml")
+}
+
+// Reads the configuration file and sets the defaults of the flags and the
+// global settings accordingly.  Runs before any command.
+func loadConfig(c *cli.Context) error {
+	path := c.GlobalString("config")
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	settings := make(map[string]interface{})
+	if path != "" {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil && (explicit || !os.IsNotExist(err)) {
+			return cli.NewExitError(fmt.Sprintf(
+				"Failed to read configuration: %v", err), exitIO)
+		}
+		if err == nil {
+			if _, err = toml.Decode(string(buf), &settings); err != nil {
+				return cli.NewExitError(fmt.Sprintf("%s: %v", path, err),
+					exitParse)
+			}
+		}
+	}
+
+	for key, value := range settings {
+		var err error
+		switch key {
+		case "cache", "timeout":
+			// Set below, as the global flags have been parsed already
+		case "server", "alg", "hash", "output-format":
+			value, err = configString(value)
+		case "trusted-servers", "witnesses":
+			value, err = configStringSlice(value)
+		case "min-cosignatures":
+			if _, ok := value.(int64); !ok {
+				err = fmt.Errorf("should be a number")
+			}
+		default:
+			err = fmt.Errorf("unknown setting")
+		}
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("%s: %s: %v", path, key, err),
+				exitParse)
+		}
+		for _, cmdFlag := range configFlags[key] {
+			bits := strings.SplitN(cmdFlag, "/", 2)
+			setFlagDefault(c.App, bits[0], bits[1], value)
+		}
+	}
+
+	// Settings of the client library
+	cachePath := c.GlobalString("cache")
+	if cachePath == "" && settings["cache"] != nil {
+		var err error
+		if cachePath, err = configString(settings["cache"]); err != nil {
+			return cli.NewExitError(fmt.Sprintf("%s: cache: %v", path, err),
+				exitParse)
+		}
+	}
+	if cachePath != "" {
+		atum.SetCache(atum.NewBoltCache(cachePath))
+	}
+
+	timeout := c.GlobalDuration("timeout")
+	if !c.GlobalIsSet("timeout") && settings["timeout"] != nil {
+		var err error
+		if timeout, err = configDuration(settings["timeout"]); err != nil {
+			return cli.NewExitError(fmt.Sprintf("%s: timeout: %v", path, err),
+				exitParse)
+		}
+	}
+	http.DefaultClient.Timeout = timeout
+	return nil
+}
+
+func configString(value interface{}) (string, error) {
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return "", fmt.Errorf("should be a string")
+}
+
+func configStringSlice(value interface{}) ([]string, error) {
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("should be a list of strings")
+	}
+	ret := make([]string, len(values))
+	for i, v := range values {
+		if ret[i], ok = v.(string); !ok {
+			return nil, fmt.Errorf("should be a list of strings")
+		}
+	}
+	return ret, nil
+}
+
+// Parses a duration such as "30s", or a number of seconds.
+func configDuration(value interface{}) (time.Duration, error) {
+	switch v := value.(type) {
+	case int64:
+		return time.Duration(v) * time.Second, nil
+	case string:
+		return time.ParseDuration(v)
+	}
+	return 0, fmt.Errorf("should be a duration, such as \"30s\"")
+}
+
+// Sets the default value of the flag of the command.
+func setFlagDefault(app *cli.App, command, name string, value interface{}) {
+	setCommandFlagDefault(app.Commands, command, command, name, value)
+}
+
+// Sets the default value of the flag of the command in commands, where
+// path is the part of command that is still to be looked up.
+func setCommandFlagDefault(commands []cli.Command, command, path, name string,
+	value interface{}) {
+	bits := strings.SplitN(path, " ", 2)
+	for _, cmd := range commands {
+		if cmd.Name != bits[0] {
+			continue
+		}
+		if len(bits) == 2 {
+			setCommandFlagDefault(cmd.Subcommands, command, bits[1], name,
+				value)
+			continue
+		}
+		for i, flag := range cmd.Flags {
+			if strings.Split(flag.GetName(), ",")[0] != name {
+				continue
+			}
+			switch f := flag.(type) {
+			case cli.StringFlag:
+				f.Value = value.(string)
+				cmd.Flags[i] = f
+			case cli.IntFlag:
+				f.Value = int(value.(int64))
+				cmd.Flags[i] = f
+			case cli.StringSliceFlag:
+				configSlices[command+"/"+name] = value.([]string)
+			}
+		}
+	}
+}
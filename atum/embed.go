@@ -0,0 +1,135 @@
+package main
+
+import (
+	"github.com/bwesterb/go-atum"
+	"github.com/bwesterb/go-atum/archive"
+
+	"github.com/urfave/cli"
+
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Returns whether the file is a ZIP or tar archive, which can have an
+// embedded timestamp.
+func isArchive(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	_, err = archive.Detect(file)
+	return err == nil
+}
+
+// Returns the timestamp embedded in the archive as JSON.
+func embeddedTimestampJson(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	ts, err := archive.Extract(file)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ts)
+}
+
+// Puts a timestamp on the contents of the archive set with --file and
+// embeds it in the archive.
+func cmdStampEmbedded(c *cli.Context) error {
+	for _, flag := range []string{"hex-nonce", "base64-nonce", "stdin-data",
+		"recursive", "output"} {
+		if c.IsSet(flag) {
+			return cli.NewExitError(fmt.Sprintf(
+				"--%s can't be combined with --embed", flag), exitUsage)
+		}
+	}
+	path := c.String("file")
+	if path == "" {
+		return cli.NewExitError("--embed requires --file", exitUsage)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Failed to open file: %v", err),
+			exitIO)
+	}
+	defer file.Close()
+	if _, err = archive.Detect(file); err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s: %v", path, err), exitUsage)
+	}
+
+	var req atum.Request
+	requestFromFlags(c, &req)
+	ctx, cancel := interruptContext()
+	defer cancel()
+	opts, done := requestOptionsFromFlags(c)
+	ts, err := archive.Stamp(ctx, file, c.String("server"), req, opts)
+	done()
+	if _, ok := err.(atum.Error); ok {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to create timestamp: %v", err), exitServer)
+	}
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to embed timestamp in %s: %v", path, err), exitIO)
+	}
+	reportStamped(c, path, path, ts)
+	return nil
+}
+
+// Checks the timestamp embedded in the archive.  On failure, returns the
+// exit code as well.
+func verifyEmbedded(c *cli.Context, path string,
+	policy atum.VerificationPolicy) (*atum.Timestamp, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, exitIO, err
+	}
+	defer file.Close()
+	ts, err := archive.Extract(file)
+	if err == archive.ErrNoTimestamp {
+		return nil, exitIO, fmt.Errorf("There is no timestamp for %s", path)
+	}
+	if err != nil {
+		return nil, exitParse, err
+	}
+	if err = checkServer(c, ts); err != nil {
+		return nil, exitInvalid, err
+	}
+	valid, _, err := archive.Verify(file, policy)
+	if err != nil {
+		return nil, exitServer, err
+	}
+	if !valid {
+		return nil, exitInvalid, fmt.Errorf(
+			"Invalid signature or the archive was modified")
+	}
+	return ts, 0, nil
+}
+
+// Verifies the timestamp embedded in the archive set with --file.
+func cmdVerifyEmbedded(c *cli.Context) error {
+	for _, flag := range []string{"hex-nonce", "base64-nonce", "data-stdin"} {
+		if c.IsSet(flag) {
+			return cli.NewExitError(fmt.Sprintf(
+				"--%s can't be combined with an embedded timestamp", flag),
+				exitUsage)
+		}
+	}
+	policy, err := policyFromFlags(c)
+	if err != nil {
+		return err
+	}
+	ts, code, err := verifyEmbedded(c, c.String("file"), policy)
+	if err != nil {
+		return cli.NewExitError(err.Error(), code)
+	}
+	printValid(c, c.String("file"), ts)
+	return nil
+}
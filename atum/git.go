@@ -0,0 +1,249 @@
+package main
+
+import (
+	"github.com/bwesterb/go-atum"
+
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli"
+
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// The git notes ref the timestamps are stored under.
+const gitNotesRef = "refs/notes/atum"
+
+// Error returned by runGit() when git fails.
+type gitError struct {
+	command  string
+	msg      string
+	exitCode int // -1 if git did not exit normally
+}
+
+func (err *gitError) Error() string {
+	return fmt.Sprintf("git %s: %s", err.command, err.msg)
+}
+
+// Runs git with the given input and arguments, and returns its output
+// without trailing whitespace.
+func runGit(input []byte, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		gerr := &gitError{command: args[0], msg: err.Error(), exitCode: -1}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			gerr.exitCode = exitErr.ExitCode()
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			gerr.msg = msg
+		}
+		return "", gerr
+	}
+	return strings.TrimRight(string(out), " \t\r\n"), nil
+}
+
+// Returns the object ID of the revision, which is a commit or tag object.
+func gitObjectId(rev string) (string, error) {
+	return runGit(nil, "rev-parse", "--verify", "--end-of-options", rev)
+}
+
+// Returns the timestamp stored in the note on the object, if any.
+func gitNote(oid string) (*atum.Timestamp, error) {
+	note, err := runGit(nil, "notes", "--ref="+gitNotesRef, "list", oid)
+	if err != nil {
+		// git notes list exits with status 1 if there is no note, and with
+		// 128 on other failures, such as a corrupt repository.
+		if gerr, ok := err.(*gitError); ok && gerr.exitCode == 1 {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if note == "" {
+		return nil, nil
+	}
+	buf, err := runGit(nil, "notes", "--ref="+gitNotesRef, "show", oid)
+	if err != nil {
+		return nil, err
+	}
+	var ts atum.Timestamp
+	if err = json.Unmarshal([]byte(buf), &ts); err != nil {
+		return nil, fmt.Errorf("Failed to parse timestamp in note: %v", err)
+	}
+	return &ts, nil
+}
+
+// Puts a timestamp on the object ID of a commit or tag and stores it in
+// a git note.
+func cmdGitStamp(c *cli.Context) error {
+	if c.NArg() > 1 {
+		return cli.NewExitError("I expect at most one revision", exitUsage)
+	}
+	rev := c.Args().First()
+	if rev == "" {
+		rev = "HEAD"
+	}
+	oid, err := gitObjectId(rev)
+	if err != nil {
+		return cli.NewExitError(err.Error(), exitUsage)
+	}
+	if !c.Bool("force") {
+		ts, err := gitNote(oid)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf(
+				"Failed to check for an existing timestamp: %v", err), exitIO)
+		}
+		if ts != nil {
+			return cli.NewExitError(fmt.Sprintf(
+				"%s already has a timestamp; use --force to replace it", rev),
+				exitUsage)
+		}
+	}
+
+	// The timestamp is on the hex encoded object ID.
+	hashing, err := hashingFromFlags(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), exitUsage)
+	}
+	var req atum.Request
+	var err2 atum.Error
+	req.Nonce, err2 = hashing.ComputeNonce(strings.NewReader(oid))
+	if err2 != nil {
+		return cli.NewExitError(err2.Error(), exitUsage)
+	}
+	requestFromFlags(c, &req)
+	ctx, cancel := interruptContext()
+	defer cancel()
+	opts, done := requestOptionsFromFlags(c)
+	ts, err2 := atum.SendRequestContext(ctx, c.String("server"), req, opts)
+	done()
+	if err2 != nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to create timestamp: %v", err2), exitServer)
+	}
+	ts.Hashing = hashing
+
+	buf, err := json.Marshal(ts)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to convert timestamp to JSON: %v", err), exitIO)
+	}
+	if _, err = runGit(append(buf, '\n'), "notes", "--ref="+gitNotesRef,
+		"add", "-f", "-F", "-", oid); err != nil {
+		return cli.NewExitError(err.Error(), exitIO)
+	}
+	fmt.Printf("Stamped %s (%s) at %s; stored in %s\n", rev, oid,
+		ts.GetTime(), gitNotesRef)
+	return nil
+}
+
+// Checks the timestamp of a commit or tag, and reports the earliest time
+// at which it is known to have existed according to the timestamps on it
+// and on the commits and tags that contain it.
+func cmdGitVerify(c *cli.Context) error {
+	if c.NArg() > 1 {
+		return cli.NewExitError("I expect at most one revision", exitUsage)
+	}
+	rev := c.Args().First()
+	if rev == "" {
+		rev = "HEAD"
+	}
+	oid, err := gitObjectId(rev)
+	if err != nil {
+		return cli.NewExitError(err.Error(), exitUsage)
+	}
+	commit, err := runGit(nil, "rev-parse", "--verify", oid+"^{commit}")
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s is not a commit or tag", rev),
+			exitUsage)
+	}
+	policy, err := policyFromFlags(c)
+	if err != nil {
+		return err
+	}
+
+	// Lines of the form "<note blob> <annotated object>"
+	list, err := runGit(nil, "notes", "--ref="+gitNotesRef, "list")
+	if err != nil {
+		return cli.NewExitError(err.Error(), exitIO)
+	}
+	var earliest *atum.Timestamp
+	var earliestOid string
+	code := 0
+	for _, line := range strings.Split(list, "\n") {
+		bits := strings.Fields(line)
+		if len(bits) != 2 {
+			continue
+		}
+		obj := bits[1]
+
+		// Only consider timestamps on objects that contain the commit.
+		if obj != oid {
+			objCommit, err := runGit(nil, "rev-parse", "--verify", "--quiet",
+				obj+"^{commit}")
+			if err != nil {
+				continue
+			}
+			if _, err = runGit(nil, "merge-base", "--is-ancestor", commit,
+				objCommit); err != nil {
+				continue
+			}
+		}
+
+		ts, err := gitNote(obj)
+		if err == nil {
+			err = checkServer(c, ts)
+		}
+		if err == nil {
+			var valid bool
+			valid, err = ts.VerifyFromWithPolicy(strings.NewReader(obj),
+				policy)
+			if err == nil && !valid {
+				err = fmt.Errorf("Invalid signature")
+			}
+		}
+		if err != nil {
+			if obj == oid {
+				fmt.Printf("invalid   %s: %v\n", rev, err)
+				code = exitInvalid
+			} else {
+				fmt.Printf("ignoring  invalid timestamp on %s: %v\n", obj, err)
+			}
+			continue
+		}
+		if obj == oid {
+			fmt.Printf("valid     %s  (%s, by %s)\n", rev, ts.GetTime(),
+				ts.ServerUrl)
+		}
+		if earliest == nil || ts.Time < earliest.Time {
+			earliest = ts
+			earliestOid = obj
+		}
+	}
+
+	if earliest == nil {
+		if code == 0 {
+			fmt.Printf("There is no valid timestamp on %s or on commits or tags "+
+				"containing it\n", rev)
+		}
+		return cli.NewExitError("", exitInvalid)
+	}
+	at := earliest.GetTime()
+	if earliestOid == oid {
+		fmt.Printf("%s existed at %s (%s)\n", rev, at, humanize.Time(at))
+	} else {
+		fmt.Printf("%s existed at %s (%s), according to the timestamp on %s\n",
+			rev, at, humanize.Time(at), earliestOid)
+	}
+	if code != 0 {
+		return cli.NewExitError("", code)
+	}
+	return nil
+}
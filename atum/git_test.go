@@ -0,0 +1,169 @@
+package main
+
+import (
+	"github.com/bwesterb/go-atum/server"
+	"github.com/bwesterb/go-atum/stamper"
+
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/ed25519"
+
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// Runs the command line interface with the given arguments and returns
+// the exit code.
+func runAtum(t *testing.T, args ...string) int {
+	t.Helper()
+	code := 0
+	cli.OsExiter = func(c int) { code = c }
+	defer func() { cli.OsExiter = os.Exit }()
+	newApp().Run(append([]string{"atum"}, args...))
+	return code
+}
+
+// Creates an empty commit and returns its object ID.
+func gitCommit(t *testing.T, msg string) string {
+	t.Helper()
+	if _, err := runGit(nil, "commit", "-q", "--allow-empty", "-m", msg); err != nil {
+		t.Fatal(err)
+	}
+	oid, err := gitObjectId("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return oid
+}
+
+// Creates a git repository in a temporary directory, changes to it and
+// starts an Atum server.  Returns the url of the server.
+func setupGitTest(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	// Keep the configuration and cache of the user out of it.
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", home)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "Test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	if _, err = runGit(nil, "init", "-q"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, sk, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := server.New(server.Config{
+		Signers: []stamper.Signer{stamper.NewEd25519Signer(sk)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func TestGitStampVerify(t *testing.T) {
+	url := setupGitTest(t)
+	first := gitCommit(t, "first")
+
+	if ts, err := gitNote(first); err != nil || ts != nil {
+		t.Fatalf("gitNote() on a commit without note: %v, %v", ts, err)
+	}
+	if code := runAtum(t, "git", "verify"); code != exitInvalid {
+		t.Fatalf("verify without timestamp: exit code %d", code)
+	}
+
+	if code := runAtum(t, "git", "stamp", "-S", url); code != 0 {
+		t.Fatalf("stamp: exit code %d", code)
+	}
+	if ts, err := gitNote(first); err != nil || ts == nil {
+		t.Fatalf("gitNote() after stamp: %v, %v", ts, err)
+	}
+	if code := runAtum(t, "git", "stamp", "-S", url); code != exitUsage {
+		t.Fatalf("stamp of stamped commit: exit code %d", code)
+	}
+	if code := runAtum(t, "git", "stamp", "-S", url, "--force"); code != 0 {
+		t.Fatalf("stamp --force: exit code %d", code)
+	}
+	if code := runAtum(t, "git", "verify", first); code != 0 {
+		t.Fatalf("verify: exit code %d", code)
+	}
+}
+
+func TestGitVerifyContaining(t *testing.T) {
+	url := setupGitTest(t)
+	first := gitCommit(t, "first")
+	second := gitCommit(t, "second")
+
+	// The timestamp on the second commit covers the first too.
+	if code := runAtum(t, "git", "stamp", "-S", url, second); code != 0 {
+		t.Fatalf("stamp: exit code %d", code)
+	}
+	if code := runAtum(t, "git", "verify", first); code != 0 {
+		t.Fatalf("verify of contained commit: exit code %d", code)
+	}
+
+	// But not a later commit.
+	third := gitCommit(t, "third")
+	if code := runAtum(t, "git", "verify", third); code != exitInvalid {
+		t.Fatalf("verify of later commit: exit code %d", code)
+	}
+}
+
+func TestGitVerifyInvalid(t *testing.T) {
+	setupGitTest(t)
+	oid := gitCommit(t, "first")
+
+	if _, err := runGit(nil, "notes", "--ref="+gitNotesRef, "add", "-m",
+		"not a timestamp", oid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gitNote(oid); err == nil {
+		t.Fatal("gitNote() accepted a malformed note")
+	}
+	if code := runAtum(t, "git", "verify"); code != exitInvalid {
+		t.Fatalf("verify of malformed note: exit code %d", code)
+	}
+}
+
+func TestGitNoteOutsideRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	t.Setenv("GIT_CEILING_DIRECTORIES", dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// The object ID of the empty tree.
+	oid := "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+	if _, err := gitNote(oid); err == nil {
+		t.Fatal("gitNote() outside a repository reported no note")
+	}
+}
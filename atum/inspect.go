@@ -0,0 +1,175 @@
+package main
+
+import (
+	"github.com/bwesterb/go-atum"
+	"github.com/bwesterb/go-atum/lms"
+
+	"github.com/bwesterb/go-xmssmt"
+	"github.com/urfave/cli"
+
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Prints the fields of a timestamp without verifying it.
+func cmdInspect(c *cli.Context) error {
+	if c.NArg() > 1 {
+		return cli.NewExitError("I expect at most one timestamp", exitUsage)
+	}
+
+	var tsBuf []byte
+	var err error
+	path := c.Args().First()
+	if path == "" || path == "-" {
+		tsBuf, err = ioutil.ReadAll(os.Stdin)
+	} else if isArchive(path) {
+		tsBuf, err = embeddedTimestampJson(path)
+	} else {
+		tsBuf, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to read timestamp: %v", err), exitIO)
+	}
+
+	// This might be the manifest of a directory or an attached timestamp.
+	var m manifest
+	var ts atum.Timestamp
+	a := parseAttached(tsBuf)
+	if a != nil {
+		ts = a.Timestamp
+	} else if err = json.Unmarshal(tsBuf, &m); err == nil && m.Timestamp != nil {
+		ts = *m.Timestamp
+	} else if err = json.Unmarshal(tsBuf, &ts); err != nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to parse timestamp: %v", err), exitParse)
+	}
+	if ts.ServerUrl == "" || ts.Sig.Alg == "" {
+		return cli.NewExitError("This is not an Atum timestamp", exitParse)
+	}
+	if m.Timestamp != nil {
+		fmt.Printf("Manifest:      %d files\n", len(m.Files))
+	}
+	if a != nil {
+		if a.Name != "" {
+			fmt.Printf("File:          %s\n", a.Name)
+		}
+		fmt.Printf("Size:          %d bytes\n", a.Size)
+		fmt.Printf("Digest:        %s %s\n", a.Hash,
+			base64.StdEncoding.EncodeToString(a.Digest))
+		if a.Data != nil || a.Size == 0 {
+			fmt.Printf("Data:          attached\n")
+		} else {
+			fmt.Printf("Data:          not attached; only the digest\n")
+		}
+	}
+
+	at := ts.GetTime()
+	fmt.Printf("Time:          %s\n", at.UTC().Format(time.RFC1123))
+	fmt.Printf("               %s (local)\n", at.Local().Format(time.RFC1123))
+	fmt.Printf("Server:        %s\n", ts.ServerUrl)
+	for i, sig := range ts.Signatures() {
+		fmt.Println()
+		if len(ts.ExtraSigs) == 0 {
+			fmt.Printf("Signature\n")
+		} else {
+			fmt.Printf("Signature %d of %d\n", i+1, len(ts.ExtraSigs)+1)
+		}
+		printSignature(sig)
+	}
+
+	fmt.Println()
+	if ts.Hashing != nil {
+		fmt.Printf("Hashing:       %s\n", ts.Hashing.Hash)
+		fmt.Printf("  Prefix:      %s\n",
+			base64.StdEncoding.EncodeToString(ts.Hashing.Prefix))
+	} else {
+		fmt.Printf("Hashing:       none; the nonce was signed directly\n")
+	}
+	if ts.Batch != nil {
+		fmt.Printf("Batch:         nonce %d of %d\n", ts.Batch.Index+1,
+			ts.Batch.Size)
+	}
+	if ts.Log != nil && !ts.Log.Complete() {
+		fmt.Printf("Log:           entry %d; not yet in a signed tree head\n",
+			ts.Log.Index)
+	} else if ts.Log != nil {
+		head := &ts.Log.TreeHead
+		fmt.Printf("Log:           entry %d of %d\n", ts.Log.Index, head.Size)
+		fmt.Printf("  Tree head:   signed %s\n",
+			time.Unix(head.Time, 0).UTC().Format(time.RFC1123))
+		if len(head.Cosignatures) != 0 {
+			fmt.Printf("  Cosigned by: %d witnesses\n", len(head.Cosignatures))
+			for _, cosig := range head.Cosignatures {
+				fmt.Printf("               %s\n", fingerprint(cosig.PublicKey))
+			}
+		}
+		if ts.Log.PreviousTreeHead != nil {
+			fmt.Printf("  Extends:     tree head of size %d\n",
+				ts.Log.PreviousTreeHead.Size)
+		}
+	} else {
+		fmt.Printf("Log:           no proof of inclusion in a transparency log\n")
+	}
+	return nil
+}
+
+// Prints the details of the signature.
+func printSignature(sig atum.Signature) {
+	fmt.Printf("  Algorithm:   %s\n", sig.Alg)
+	fmt.Printf("  Public key:  %s\n",
+		base64.StdEncoding.EncodeToString(sig.PublicKey))
+	fmt.Printf("  Fingerprint: %s\n", fingerprint(sig.PublicKey))
+
+	switch sig.Alg {
+	case atum.XMSSMT:
+		xsig, err := parseXmssmtSignature(sig.Data)
+		if err != nil {
+			fmt.Printf("  Corrupted XMSS[MT] signature: %v\n", err)
+			return
+		}
+		params := xsig.Context().Params()
+		max := params.MaxSignatureSeqNo()
+		fmt.Printf("  Parameters:  %s\n", params)
+		printIndex(uint64(xsig.SeqNo()), max+1)
+	case atum.LMS:
+		info, err := lms.ParseSignature(sig.Data)
+		if err != nil {
+			fmt.Printf("  Corrupted LMS signature: %v\n", err)
+			return
+		}
+		fmt.Printf("  Parameters:  %s\n", info.Params)
+		printIndex(info.Index(), info.Params.MaxSignatures())
+	}
+}
+
+// Prints the index of a signature of a stateful hash-based signature scheme
+// and how many signatures the key can still set.
+func printIndex(index, max uint64) {
+	fmt.Printf("  Index:       %d of %d\n", index, max)
+	left := max - index - 1
+	fmt.Printf("  Remaining:   %d signatures (%.4f%% used)\n", left,
+		100*float64(index+1)/float64(max))
+}
+
+// Parses an XMSS[MT] signature.  xmssmt.Signature.UnmarshalBinary() panics
+// on truncated signatures, so we recover from that.
+func parseXmssmtSignature(buf []byte) (sig *xmssmt.Signature, err error) {
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("Signature is too short")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			sig, err = nil, fmt.Errorf("Signature is too short")
+		}
+	}()
+	sig = new(xmssmt.Signature)
+	if err = sig.UnmarshalBinary(buf); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
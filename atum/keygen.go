@@ -0,0 +1,112 @@
+package main
+
+import (
+	"github.com/bwesterb/go-atum"
+	"github.com/bwesterb/go-atum/lms"
+	"github.com/bwesterb/go-atum/stamper"
+
+	"github.com/urfave/cli"
+
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Generates a private key in the layout used by atum serve --key-dir.
+func cmdKeygen(c *cli.Context) error {
+	if c.NArg() != 0 {
+		return cli.NewExitError("I don't expect arguments; only flags", exitUsage)
+	}
+	if !c.IsSet("out") {
+		return cli.NewExitError("Please specify --out", exitUsage)
+	}
+	dir := c.String("out")
+	alg := atum.SignatureAlgorithm(c.String("alg"))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return cli.NewExitError(fmt.Sprintf("os.MkdirAll(%s): %v", dir, err),
+			exitIO)
+	}
+
+	var pk []byte
+	var path string
+	switch alg {
+	case atum.Ed25519:
+		if c.IsSet("params") {
+			return cli.NewExitError("Ed25519 has no parameters", exitUsage)
+		}
+		path = filepath.Join(dir, "ed25519.key")
+		sk, err := generateEd25519Key(path)
+		if os.IsExist(err) {
+			return existsError(path)
+		}
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf(
+				"Failed to generate key: %v", err), exitIO)
+		}
+		pk = stamper.NewEd25519Signer(sk).PublicKey()
+
+	case atum.XMSSMT:
+		// A KeyStore generates its first key when it is opened on
+		// an empty directory.
+		path = filepath.Join(dir, "xmssmt")
+		existing, _ := filepath.Glob(filepath.Join(path, "xmssmt-*"))
+		if len(existing) != 0 {
+			return existsError(path)
+		}
+		fmt.Fprintf(os.Stderr, "Generating XMSS[MT] key; this might "+
+			"take a while ...\n")
+		ks, err := stamper.OpenKeyStore(path, stamper.KeyStoreOptions{
+			Params: c.String("params"),
+		})
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf(
+				"Failed to generate key: %v", err), exitIO)
+		}
+		pk = ks.PublicKey()
+		if err = ks.Close(); err != nil {
+			return cli.NewExitError(fmt.Sprintf(
+				"Failed to store key: %v", err), exitIO)
+		}
+
+	case atum.LMS:
+		params := defaultLMSParams
+		if c.IsSet("params") {
+			var err error
+			params, err = lms.ParseParams(c.String("params"))
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf(
+					"Failed to parse --params: %v", err), exitUsage)
+			}
+		}
+		path = filepath.Join(dir, "lms.key")
+		key, err := stamper.GenerateLMSKey(path, params)
+		if os.IsExist(err) {
+			return existsError(path)
+		}
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf(
+				"Failed to generate key: %v", err), exitIO)
+		}
+		pk = key.PublicKey()
+		if err = key.Close(); err != nil {
+			return cli.NewExitError(fmt.Sprintf(
+				"Failed to store key: %v", err), exitIO)
+		}
+
+	default:
+		return cli.NewExitError(fmt.Sprintf(
+			"Unknown signature algorithm %s", alg), exitUsage)
+	}
+
+	fmt.Printf("Generated %s key in %s\n\n", alg, path)
+	fmt.Printf("Public key (base64): %s\n", base64.StdEncoding.EncodeToString(pk))
+	fmt.Printf("Public key (hex):    %s\n", hex.EncodeToString(pk))
+	return nil
+}
+
+func existsError(path string) error {
+	return cli.NewExitError(fmt.Sprintf(
+		"There already is a key at %s; I won't overwrite it", path), exitIO)
+}
@@ -2,25 +2,58 @@ package main
 
 import (
 	"os"
+	"strings"
+	"time"
 
+	"github.com/bwesterb/go-atum"
 	"github.com/urfave/cli"
 )
 
 func main() {
+	app := newApp()
+	app.Run(moveStdinArg(app, os.Args))
+}
 
+// Returns the command line interface with all its commands.
+func newApp() *cli.App {
 	app := cli.NewApp()
 
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "config, c",
+			Usage:  "Read settings from `FILE` (default: $XDG_CONFIG_HOME/atum/config.toml)",
+			EnvVar: "ATUM_CONFIG",
+		},
+		cli.StringFlag{
+			Name:   "cache",
+			Usage:  "Cache server information and public keys in `FILE` (default: ~/.cache/atum/cache.bolt)",
+			EnvVar: "ATUM_CACHE",
+		},
+		cli.DurationFlag{
+			Name:   "timeout",
+			Usage:  "Give up on requests to servers after `DURATION`, e.g. 30s (default: never)",
+			EnvVar: "ATUM_TIMEOUT",
+		},
+	}
+	app.Before = func(c *cli.Context) error {
+		// Exits right away on errors, instead of showing the help
+		// as urfave/cli does for errors returned by Before.
+		cli.HandleExitCoder(loadConfig(c))
+		return nil
+	}
+
 	app.Commands = []cli.Command{
 		{
-			Name:   "stamp",
-			Usage:  "Request an Atum timestamp",
-			Action: cmdStamp,
+			Name:      "stamp",
+			Usage:     "Request an Atum timestamp",
+			ArgsUsage: "[FILE... | -]",
+			Action:    withOutputFormat(cmdStamp, true),
 			Flags: []cli.Flag{
 				cli.StringFlag{
-					Name:  "server, S",
-					Usage: "Atum server `URL`",
-					// Hosted by SIDN.nl
-					Value: "https://keyshare.privacybydesign.foundation/atumd",
+					Name:   "server, S",
+					Usage:  "Atum server `URL`",
+					EnvVar: "ATUM_SERVER",
+					Value:  defaultServerUrl,
 				},
 				cli.StringFlag{
 					Name:  "file, f",
@@ -34,24 +67,83 @@ func main() {
 					Name:  "hex-nonce, H",
 					Usage: "Hex encoded nonce",
 				},
+				cli.BoolFlag{
+					Name:  "stdin-data",
+					Usage: "Put timestamp on the data read from stdin; same as passing -",
+				},
 				cli.IntFlag{
 					Name:  "time, t",
 					Usage: "UNIX time to request timestamp for",
 				},
 				cli.StringFlag{
-					Name:  "alg, a",
-					Usage: "Preferred signature algorithm (xmssmt, ed25519)",
+					Name:   "alg, a",
+					Usage:  "Preferred signature algorithm (xmssmt, ed25519, lms) or comma separated list for a hybrid timestamp",
+					EnvVar: "ATUM_ALG",
+				},
+				cli.StringFlag{
+					Name:   "hash",
+					Usage:  "Compress files to a nonce with hash `NAME`",
+					EnvVar: "ATUM_HASH",
+					Value:  string(atum.Shake256),
 				},
 				cli.StringFlag{
 					Name:  "output, o",
 					Usage: "Write output to `FILE`",
 				},
+				cli.IntFlag{
+					Name:  "threads",
+					Usage: "Number of threads to use for the proof of work (default: number of CPUs)",
+				},
+				cli.IntFlag{
+					Name:  "max-difficulty",
+					Usage: "Refuse proofs of work of higher difficulty than `N`",
+				},
+				cli.BoolFlag{
+					Name:  "quiet, q",
+					Usage: "Don't show the progress of the proof of work",
+				},
+				cli.StringFlag{
+					Name:  "recursive, r",
+					Usage: "Put timestamp on all files in `DIR` and write " + manifestName,
+				},
+				cli.StringSliceFlag{
+					Name:  "ignore",
+					Usage: "With --recursive, skip files matching `PATTERN`",
+				},
+				cli.IntFlag{
+					Name:  "jobs, j",
+					Usage: "With several files, hash `N` files at the same time (default: number of CPUs)",
+				},
+				cli.BoolFlag{
+					Name:  "no-batch",
+					Usage: "With several files, request a separate timestamp for each",
+				},
+				cli.BoolFlag{
+					Name:  "attach",
+					Usage: "Write the data (or its digest, if it is large) together with the timestamp to FILE.atum",
+				},
+				cli.Int64Flag{
+					Name:  "attach-max-size",
+					Usage: "With --attach, only write the digest of data larger than `N` bytes",
+					Value: 1 << 20,
+				},
+				cli.BoolFlag{
+					Name:  "embed",
+					Usage: "Embed the timestamp in the ZIP or tar archive set with --file",
+				},
+				cli.StringFlag{
+					Name:   "output-format",
+					Usage:  "Print the result as `FORMAT`: text or json",
+					EnvVar: "ATUM_OUTPUT_FORMAT",
+					Value:  "text",
+				},
 			},
 		},
 		{
-			Name:   "verify",
-			Usage:  "Verify an Atum timestamp",
-			Action: cmdVerify,
+			Name:      "verify",
+			Usage:     "Verify an Atum timestamp",
+			ArgsUsage: "[FILE...]",
+			Action:    withOutputFormat(cmdVerify, false),
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "file, f",
@@ -69,6 +161,10 @@ func main() {
 					Name:  "stdin, s",
 					Usage: "Read timestamp from stdin",
 				},
+				cli.BoolFlag{
+					Name:  "data-stdin",
+					Usage: "Checks the timestamp for the data read from stdin",
+				},
 				cli.StringFlag{
 					Name:  "timestamp, t",
 					Usage: "Read timestamp from `FILE`",
@@ -77,13 +173,471 @@ func main() {
 					Name:  "server, S",
 					Usage: "Ensures the timestamp is signed by server at `URL`",
 				},
+				cli.StringSliceFlag{
+					Name:   "trusted-server",
+					Usage:  "Only accept timestamps signed by the server at `URL` or other trusted servers",
+					EnvVar: "ATUM_TRUSTED_SERVERS",
+				},
 				cli.BoolFlag{
 					Name:  "verbose, v",
 					Usage: "Show additional information on the signature",
 				},
+				cli.BoolFlag{
+					Name:  "any-signature",
+					Usage: "Accept a hybrid timestamp if any (instead of all) of its signatures is valid",
+				},
+				cli.StringFlag{
+					Name:  "require-alg",
+					Usage: "Comma separated list of signature algorithms that must have signed the timestamp",
+				},
+				cli.BoolFlag{
+					Name:  "require-log",
+					Usage: "Reject timestamps without a proof of inclusion in the transparency log",
+				},
+				cli.StringSliceFlag{
+					Name:   "witness, w",
+					Usage:  "Base64 encoded Ed25519 public `KEY` of a trusted witness",
+					EnvVar: "ATUM_WITNESSES",
+				},
+				cli.IntFlag{
+					Name:   "min-cosignatures, m",
+					Usage:  "Require the timestamp to be in a tree head cosigned by `N` of the witnesses",
+					EnvVar: "ATUM_MIN_COSIGNATURES",
+				},
+				cli.StringFlag{
+					Name:  "recursive, r",
+					Usage: "Checks the " + manifestName + " of `DIR` and reports changed files",
+				},
+				cli.StringSliceFlag{
+					Name:  "ignore",
+					Usage: "With --recursive, skip files matching `PATTERN`",
+				},
+				cli.IntFlag{
+					Name:  "jobs, j",
+					Usage: "With several files, check `N` files at the same time (default: number of CPUs)",
+				},
+				cli.StringFlag{
+					Name:   "output-format",
+					Usage:  "Print the result as `FORMAT`: text or json",
+					EnvVar: "ATUM_OUTPUT_FORMAT",
+					Value:  "text",
+				},
+			},
+		},
+		{
+			Name:      "extract",
+			Usage:     "Write the data attached to an Atum timestamp",
+			ArgsUsage: "[TIMESTAMP]",
+			Action:    cmdExtract,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output, o",
+					Usage: "Write the data to `FILE` (default: the name stored in the timestamp)",
+				},
+			},
+		},
+		{
+			Name:      "inspect",
+			Usage:     "Show the contents of an Atum timestamp without verifying it",
+			ArgsUsage: "[TIMESTAMP]",
+			Action:    cmdInspect,
+		},
+		{
+			Name:   "check-key",
+			Usage:  "Check whether a public key of an Atum server is trusted",
+			Action: cmdCheckKey,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "server, S",
+					Usage: "Atum server `URL`",
+				},
+				cli.StringFlag{
+					Name:  "alg, a",
+					Usage: "Signature algorithm of the public key (xmssmt, ed25519, lms)",
+					Value: "ed25519",
+				},
+				cli.StringFlag{
+					Name:  "pk",
+					Usage: "Hex or base64 encoded public `KEY`",
+				},
+				cli.StringFlag{
+					Name:  "timestamp, t",
+					Usage: "Check the public keys on the timestamp in `FILE`",
+				},
+				cli.BoolFlag{
+					Name:  "no-cache",
+					Usage: "Always ask the server instead of trusting the cache",
+				},
+			},
+		},
+		{
+			Name:  "git",
+			Usage: "Put timestamps on git commits and tags, stored in " + gitNotesRef,
+			Subcommands: []cli.Command{
+				{
+					Name:      "stamp",
+					Usage:     "Put a timestamp on a commit or tag",
+					ArgsUsage: "[REV]",
+					Action:    cmdGitStamp,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:   "server, S",
+							Usage:  "Atum server `URL`",
+							EnvVar: "ATUM_SERVER",
+							Value:  defaultServerUrl,
+						},
+						cli.StringFlag{
+							Name:   "alg, a",
+							Usage:  "Preferred signature algorithm (xmssmt, ed25519, lms) or comma separated list for a hybrid timestamp",
+							EnvVar: "ATUM_ALG",
+						},
+						cli.StringFlag{
+							Name:   "hash",
+							Usage:  "Compress the object ID to a nonce with hash `NAME`",
+							EnvVar: "ATUM_HASH",
+							Value:  string(atum.Shake256),
+						},
+						cli.IntFlag{
+							Name:  "time, t",
+							Usage: "UNIX time to request timestamp for",
+						},
+						cli.IntFlag{
+							Name:  "threads",
+							Usage: "Number of threads to use for the proof of work (default: number of CPUs)",
+						},
+						cli.IntFlag{
+							Name:  "max-difficulty",
+							Usage: "Refuse proofs of work of higher difficulty than `N`",
+						},
+						cli.BoolFlag{
+							Name:  "quiet, q",
+							Usage: "Don't show the progress of the proof of work",
+						},
+						cli.BoolFlag{
+							Name:  "force, f",
+							Usage: "Replace an existing timestamp",
+						},
+					},
+				},
+				{
+					Name:      "verify",
+					Usage:     "Check the timestamps on a commit or tag and the commits and tags containing it",
+					ArgsUsage: "[REV]",
+					Action:    cmdGitVerify,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "server, S",
+							Usage: "Only accept timestamps signed by server at `URL`",
+						},
+						cli.StringSliceFlag{
+							Name:   "trusted-server",
+							Usage:  "Only accept timestamps signed by the server at `URL` or other trusted servers",
+							EnvVar: "ATUM_TRUSTED_SERVERS",
+						},
+						cli.BoolFlag{
+							Name:  "any-signature",
+							Usage: "Accept a hybrid timestamp if any (instead of all) of its signatures is valid",
+						},
+						cli.StringFlag{
+							Name:  "require-alg",
+							Usage: "Comma separated list of signature algorithms that must have signed the timestamp",
+						},
+						cli.BoolFlag{
+							Name:  "require-log",
+							Usage: "Reject timestamps without a proof of inclusion in the transparency log",
+						},
+						cli.StringSliceFlag{
+							Name:   "witness, w",
+							Usage:  "Base64 encoded Ed25519 public `KEY` of a trusted witness",
+							EnvVar: "ATUM_WITNESSES",
+						},
+						cli.IntFlag{
+							Name:   "min-cosignatures, m",
+							Usage:  "Require the timestamps to be in a tree head cosigned by `N` of the witnesses",
+							EnvVar: "ATUM_MIN_COSIGNATURES",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:      "watch",
+			Usage:     "Put timestamps on files in a directory as they are created or modified",
+			ArgsUsage: "DIR",
+			Action:    cmdWatch,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "server, S",
+					Usage:  "Atum server `URL`",
+					EnvVar: "ATUM_SERVER",
+					Value:  defaultServerUrl,
+				},
+				cli.StringFlag{
+					Name:   "alg, a",
+					Usage:  "Preferred signature algorithm (xmssmt, ed25519, lms) or comma separated list for a hybrid timestamp",
+					EnvVar: "ATUM_ALG",
+				},
+				cli.StringFlag{
+					Name:   "hash",
+					Usage:  "Compress files to a nonce with hash `NAME`",
+					EnvVar: "ATUM_HASH",
+					Value:  string(atum.Shake256),
+				},
+				cli.StringSliceFlag{
+					Name:  "include",
+					Usage: "Only stamp files matching `PATTERN`",
+				},
+				cli.StringSliceFlag{
+					Name:  "ignore",
+					Usage: "Skip files matching `PATTERN`, in addition to those in " + ignoreFileName,
+				},
+				cli.StringFlag{
+					Name:  "index",
+					Usage: "Append the timestamps to `FILE` instead of writing FILE.atum-timestamp next to each file",
+				},
+				cli.DurationFlag{
+					Name:  "interval",
+					Usage: "Request timestamps for the files that changed at most once every `DURATION`",
+					Value: 10 * time.Second,
+				},
+				cli.DurationFlag{
+					Name:  "settle",
+					Usage: "Wait until a file hasn't changed for `DURATION` before stamping it",
+					Value: 2 * time.Second,
+				},
+				cli.BoolFlag{
+					Name:  "initial",
+					Usage: "Also stamp the files that are present at the start without a timestamp",
+				},
+				cli.BoolFlag{
+					Name:  "poll",
+					Usage: "Look for changes periodically instead of using filesystem notifications",
+				},
+				cli.DurationFlag{
+					Name:  "poll-interval",
+					Usage: "With --poll, look for changes every `DURATION`",
+					Value: 2 * time.Second,
+				},
+				cli.IntFlag{
+					Name:  "threads",
+					Usage: "Number of threads to use for the proof of work (default: number of CPUs)",
+				},
+				cli.IntFlag{
+					Name:  "max-difficulty",
+					Usage: "Refuse proofs of work of higher difficulty than `N`",
+				},
+				cli.BoolFlag{
+					Name:  "quiet, q",
+					Usage: "Don't show the progress of the proof of work",
+				},
+			},
+		},
+		{
+			Name:  "cache",
+			Usage: "Inspect and manage the cache of server information and public keys",
+			Subcommands: []cli.Command{
+				{
+					Name:   "list",
+					Usage:  "List the servers in the cache",
+					Action: cmdCacheList,
+				},
+				{
+					Name:      "show",
+					Usage:     "Show everything cached on a server",
+					ArgsUsage: "SERVER",
+					Action:    cmdCacheShow,
+				},
+				{
+					Name:   "purge",
+					Usage:  "Remove entries from the cache",
+					Action: cmdCachePurge,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "server, S",
+							Usage: "Only remove the entries of the server at `URL`",
+						},
+						cli.BoolFlag{
+							Name:  "expired",
+							Usage: "Only remove public keys that are no longer trusted",
+						},
+					},
+				},
+				{
+					Name:      "export",
+					Usage:     "Write the cache as JSON, for instance to import on an offline machine",
+					ArgsUsage: "[FILE]",
+					Action:    cmdCacheExport,
+				},
+				{
+					Name:      "import",
+					Usage:     "Add the entries written by atum cache export to the cache",
+					ArgsUsage: "[FILE]",
+					Action:    cmdCacheImport,
+				},
+			},
+		},
+		{
+			Name:   "audit",
+			Usage:  "Check the transparency log of an Atum server using a local copy",
+			Action: cmdAudit,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "server, S",
+					Usage:  "Atum server `URL`",
+					EnvVar: "ATUM_SERVER",
+					Value:  defaultServerUrl,
+				},
+				cli.StringFlag{
+					Name:  "dir, d",
+					Usage: "Keep the local copy of the log in `DIR`",
+				},
+				cli.BoolFlag{
+					Name:  "offline",
+					Usage: "Only check the local copy; don't fetch new entries",
+				},
+				cli.IntFlag{
+					Name:  "lag",
+					Usage: "Acceptable lag of the server in seconds",
+					Value: 60,
+				},
+			},
+		},
+		{
+			Name:   "serve",
+			Usage:  "Run an Atum server",
+			Action: cmdServe,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "listen, l",
+					Usage: "Listen on `ADDRESS`",
+					Value: ":8080",
+				},
+				cli.StringFlag{
+					Name:  "key-dir, k",
+					Usage: "Read private keys from `DIR`; generated if they do not exist",
+				},
+				cli.StringFlag{
+					Name:  "alg, a",
+					Usage: "Comma separated list of signature algorithms (xmssmt, ed25519, lms) to support; the first is the default",
+					Value: "ed25519",
+				},
+				cli.StringFlag{
+					Name:  "xmssmt-params",
+					Usage: "XMSS[MT] instance to use for new keys, e.g. XMSSMT-SHA2_40/2_512",
+				},
+				cli.StringFlag{
+					Name:  "url, u",
+					Usage: "Public `URL` of the server (default: derived from the requests)",
+				},
+				cli.StringFlag{
+					Name:  "data-dir, d",
+					Usage: "Keep the key history and transparency log in `DIR` (default: data in the key directory)",
+				},
+				cli.IntFlag{
+					Name:  "max-nonce-size",
+					Usage: "Maximum size of nonces in bytes",
+					Value: 128,
+				},
+				cli.IntFlag{
+					Name:  "acceptable-lag",
+					Usage: "Maximum difference in seconds between the requested and actual time",
+					Value: 60,
+				},
+				cli.StringSliceFlag{
+					Name:  "pow",
+					Usage: "Require a proof of work for an algorithm, e.g. xmssmt=16",
+				},
+				cli.StringSliceFlag{
+					Name:  "witness, w",
+					Usage: "Accept cosignatures of the witness with base64 encoded Ed25519 public `KEY`",
+				},
+				cli.StringFlag{
+					Name:  "tls-cert",
+					Usage: "Serve HTTPS with the certificate in `FILE`",
+				},
+				cli.StringFlag{
+					Name:  "tls-key",
+					Usage: "Private key for the certificate in `FILE`",
+				},
+			},
+		},
+		{
+			Name:   "keygen",
+			Usage:  "Generate a private key for atum serve",
+			Action: cmdKeygen,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "alg, a",
+					Usage: "Signature algorithm: xmssmt, ed25519 or lms",
+					Value: "ed25519",
+				},
+				cli.StringFlag{
+					Name:  "params, p",
+					Usage: "Parameters, e.g. XMSSMT-SHA2_40/2_512 (default) for xmssmt or H10_W4/H10_W4 (default) for lms",
+				},
+				cli.StringFlag{
+					Name:  "out, o",
+					Usage: "Write the key to `DIR`",
+				},
+			},
+		},
+		{
+			Name:   "witness",
+			Usage:  "Cosign the tree heads of the transparency logs of Atum servers",
+			Action: cmdWitness,
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "server, S",
+					Usage: "Witness Atum server at `URL`",
+				},
+				cli.StringFlag{
+					Name:  "key, k",
+					Usage: "Read Ed25519 private key from `FILE`; generated if it does not exist",
+				},
+				cli.StringFlag{
+					Name:  "state",
+					Usage: "Keep the latest tree heads seen in `FILE`",
+				},
+				cli.DurationFlag{
+					Name:  "interval, i",
+					Usage: "Time between two rounds",
+					Value: 5 * time.Minute,
+				},
+				cli.BoolFlag{
+					Name:  "once",
+					Usage: "Cosign the tree heads once and exit",
+				},
 			},
 		},
 	}
 
-	app.Run(os.Args)
+	return app
+}
+
+// urfave/cli stops parsing flags at the first argument, so to allow
+// pg_dump | atum stamp - -o dump.atum, we move the - to the end.
+func moveStdinArg(app *cli.App, args []string) []string {
+	stamp := app.Command("stamp")
+	i := 1
+	for ; i < len(args) && args[i] != "stamp"; i++ {
+	}
+	for i++; i < len(args)-1; i++ {
+		if !strings.HasPrefix(args[i], "-") {
+			return args // the first argument is not -
+		}
+		if args[i] == "-" {
+			return append(append(args[:i:i], args[i+1:]...), "-")
+		}
+		// Skip the value of the flag
+		name := strings.TrimLeft(args[i], "-")
+		for _, flag := range stamp.Flags {
+			for _, flagName := range strings.Split(flag.GetName(), ",") {
+				_, isBool := flag.(cli.BoolFlag)
+				if strings.TrimSpace(flagName) == name && !isBool {
+					i++
+				}
+			}
+		}
+	}
+	return args
 }
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"github.com/bwesterb/go-atum"
+
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Name of the manifest written by `atum stamp -r DIR`.
+const manifestName = "manifest.atum"
+
+// Name of the file with ignore patterns in a directory to be stamped.
+const ignoreFileName = ".atumignore"
+
+// A file in a manifest.
+type manifestEntry struct {
+	// Path relative to the directory, separated by slashes
+	Path string
+
+	Size int64
+
+	// SHA-256 hash of the contents
+	Digest []byte
+}
+
+// The files in a directory together with a timestamp on all of them.
+type manifest struct {
+	// Sorted by Path
+	Files []manifestEntry
+
+	// Patterns of the files that were ignored.  These are covered by
+	// the timestamp as well.
+	Ignore []string `json:",omitempty"`
+
+	// Timestamp on the nonce returned by Nonce()
+	Timestamp *atum.Timestamp
+}
+
+// Returns the nonce that is timestamped for the manifest.
+//
+// The nonce is the SHA-256 hash of "atum manifest\n" followed by, for every
+// file, the length of its path (as 32-bit big endian), the path, the size
+// (as 64-bit big endian) and the SHA-256 hash of its contents.
+//
+// If files were ignored, the prefix is "atum manifest with ignore\n"
+// instead, which is followed by the number of ignore patterns (as 32-bit
+// big endian) and then, for every pattern in sorted order, its length (as
+// 32-bit big endian) and the pattern itself, before the files.
+func (m *manifest) Nonce() []byte {
+	h := sha256.New()
+	var buf [8]byte
+	if len(m.Ignore) == 0 {
+		h.Write([]byte("atum manifest\n"))
+	} else {
+		h.Write([]byte("atum manifest with ignore\n"))
+		ignore := append([]string(nil), m.Ignore...)
+		sort.Strings(ignore)
+		binary.BigEndian.PutUint32(buf[:4], uint32(len(ignore)))
+		h.Write(buf[:4])
+		for _, p := range ignore {
+			binary.BigEndian.PutUint32(buf[:4], uint32(len(p)))
+			h.Write(buf[:4])
+			h.Write([]byte(p))
+		}
+	}
+	for _, file := range m.Files {
+		binary.BigEndian.PutUint32(buf[:4], uint32(len(file.Path)))
+		h.Write(buf[:4])
+		h.Write([]byte(file.Path))
+		binary.BigEndian.PutUint64(buf[:], uint64(file.Size))
+		h.Write(buf[:])
+		h.Write(file.Digest)
+	}
+	return h.Sum(nil)
+}
+
+// Patterns of files to skip when building a manifest.
+//
+// A pattern without a slash is matched (see path.Match) against the name
+// of every file and directory; a pattern with a slash against the path
+// relative to the directory.  A trailing slash restricts the pattern
+// to directories.
+type ignorePatterns []string
+
+// Reads the patterns in the ignore file of dir, if there is one.
+// Empty lines and lines starting with # are skipped.
+func readIgnoreFile(dir string) (ignorePatterns, error) {
+	file, err := os.Open(filepath.Join(dir, ignoreFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var ret ignorePatterns
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ret = append(ret, line)
+	}
+	return ret, scanner.Err()
+}
+
+// Checks whether the patterns are well-formed.
+func (ps ignorePatterns) check() error {
+	for _, p := range ps {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("Invalid ignore pattern %q: %v", p, err)
+		}
+	}
+	return nil
+}
+
+// Returns whether the file with the given relative path is ignored.
+func (ps ignorePatterns) match(relPath string, isDir bool) bool {
+	name := path.Base(relPath)
+	for _, p := range ps {
+		if strings.HasSuffix(p, "/") {
+			if !isDir {
+				continue
+			}
+			p = strings.TrimSuffix(p, "/")
+		}
+		subject := name
+		if strings.Contains(p, "/") {
+			subject = relPath
+			p = strings.TrimPrefix(p, "/")
+		}
+		if ok, _ := path.Match(p, subject); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Hashes the regular files in dir, skipping the ignored ones and the
+// manifest itself.  Symbolic links are not followed.
+func buildManifest(dir string, ignore ignorePatterns) (*manifest, error) {
+	if err := ignore.check(); err != nil {
+		return nil, err
+	}
+	var m manifest
+	m.Ignore = ignore
+	err := filepath.Walk(dir, func(fullPath string, info os.FileInfo,
+		err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, fullPath)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if ignore.match(rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || !info.Mode().IsRegular() ||
+			rel == manifestName || rel == manifestName+".tmp" {
+			return nil
+		}
+		digest, size, err := hashFile(fullPath)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, manifestEntry{
+			Path:   rel,
+			Size:   size,
+			Digest: digest,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(m.Files, func(i, j int) bool {
+		return m.Files[i].Path < m.Files[j].Path
+	})
+	return &m, nil
+}
+
+// Returns the SHA-256 hash and size of the file.
+func hashFile(path string) ([]byte, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return nil, 0, err
+	}
+	return h.Sum(nil), size, nil
+}
+
+func readManifest(path string) (*manifest, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err = json.Unmarshal(buf, &m); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", path, err)
+	}
+	if m.Timestamp == nil {
+		return nil, fmt.Errorf("%s does not contain a timestamp", path)
+	}
+	return &m, nil
+}
+
+func writeManifest(path string, m *manifest) error {
+	buf, err := json.MarshalIndent(m, "", " ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Differences between a manifest and the current contents of a directory.
+type manifestDiff struct {
+	Added, Removed, Modified []string
+}
+
+func (d *manifestDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// Compares the manifest with the current one.
+func diffManifests(old, cur *manifest) manifestDiff {
+	var ret manifestDiff
+	i, j := 0, 0
+	for i < len(old.Files) || j < len(cur.Files) {
+		switch {
+		case j == len(cur.Files) ||
+			(i < len(old.Files) && old.Files[i].Path < cur.Files[j].Path):
+			ret.Removed = append(ret.Removed, old.Files[i].Path)
+			i++
+		case i == len(old.Files) || cur.Files[j].Path < old.Files[i].Path:
+			ret.Added = append(ret.Added, cur.Files[j].Path)
+			j++
+		default:
+			if old.Files[i].Size != cur.Files[j].Size ||
+				!bytes.Equal(old.Files[i].Digest, cur.Files[j].Digest) {
+				ret.Modified = append(ret.Modified, old.Files[i].Path)
+			}
+			i++
+			j++
+		}
+	}
+	return ret
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"github.com/bwesterb/go-atum"
+
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli"
+
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// Result of stamping or verifying one of several files.
+type fileResult struct {
+	path string
+	ts   *atum.Timestamp
+	err  error
+	code int // exit code, if err is set
+}
+
+// Sets the error of the result, unless it already has one.
+func (r *fileResult) fail(err error, code int) {
+	if r.err == nil {
+		r.err = err
+		r.code = code
+	}
+}
+
+// Calls f on every index below n using the number of goroutines set
+// with --jobs.
+func forEachConcurrently(c *cli.Context, n int, f func(i int)) {
+	jobs := c.Int("jobs")
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	idx := make(chan int)
+	var wg sync.WaitGroup
+	for j := 0; j < jobs; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idx {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		idx <- i
+	}
+	close(idx)
+	wg.Wait()
+}
+
+// Puts a timestamp on each of the files given as arguments.
+func cmdStampFiles(c *cli.Context) error {
+	for _, flag := range []string{"file", "hex-nonce", "base64-nonce",
+		"stdin-data", "recursive", "output", "attach", "embed"} {
+		if c.IsSet(flag) {
+			return cli.NewExitError(fmt.Sprintf(
+				"--%s can't be combined with file arguments", flag), exitUsage)
+		}
+	}
+
+	if _, err := hashingFromFlags(c); err != nil {
+		return cli.NewExitError(err.Error(), exitUsage)
+	}
+
+	paths := c.Args()
+	for _, path := range paths {
+		if path == "-" {
+			return cli.NewExitError(
+				"- (stdin) can't be combined with other files", exitUsage)
+		}
+	}
+	results := make([]fileResult, len(paths))
+	hashings := make([]*atum.Hashing, len(paths))
+	nonces := make([][]byte, len(paths))
+	forEachConcurrently(c, len(paths), func(i int) {
+		results[i].path = paths[i]
+		file, err := os.Open(paths[i])
+		if err != nil {
+			results[i].fail(err, exitIO)
+			return
+		}
+		defer file.Close()
+		hashings[i], _ = hashingFromFlags(c)
+		var err2 atum.Error
+		nonces[i], err2 = hashings[i].ComputeNonce(file)
+		if err2 != nil {
+			results[i].fail(err2, exitIO)
+		}
+	})
+
+	// The files we could read
+	var todo []int
+	for i := range results {
+		if results[i].err == nil {
+			todo = append(todo, i)
+		}
+	}
+
+	var req atum.Request
+	requestFromFlags(c, &req)
+	ctx, cancel := interruptContext()
+	defer cancel()
+	opts, done := requestOptionsFromFlags(c)
+
+	if c.Bool("no-batch") {
+		forEachConcurrently(c, len(todo), func(j int) {
+			i := todo[j]
+			req := req
+			req.Nonce = nonces[i]
+			ts, err := atum.SendRequestContext(ctx, c.String("server"), req,
+				opts)
+			if err != nil {
+				results[i].fail(err, exitServer)
+				return
+			}
+			results[i].ts = ts
+		})
+	} else if len(todo) != 0 {
+		batch := make([][]byte, len(todo))
+		for j, i := range todo {
+			batch[j] = nonces[i]
+		}
+		tss, err := atum.SendBatchRequest(ctx, c.String("server"), req,
+			batch, opts)
+		for j, i := range todo {
+			if err != nil {
+				results[i].fail(err, exitServer)
+			} else {
+				results[i].ts = tss[j]
+			}
+		}
+	}
+	done()
+
+	failed := 0
+	code := 0
+	for i := range results {
+		res := &results[i]
+		outFile := res.path + ".atum-timestamp"
+		if res.err == nil {
+			res.ts.Hashing = hashings[i]
+			if err := writeTimestamp(outFile, res.ts); err != nil {
+				res.fail(err, exitIO)
+			}
+		}
+		if res.err != nil {
+			failed++
+			if code == 0 {
+				code = res.code
+			}
+		}
+
+		if jsonOutput(c) {
+			jres := jsonResult{File: res.path}
+			ok := res.err == nil
+			jres.Stamped = &ok
+			if ok {
+				jres.describe(res.ts)
+				jres.Output = outFile
+			} else {
+				jres.fail(res.err, res.code)
+			}
+			printJson(jres)
+			continue
+		}
+
+		if res.err != nil {
+			fmt.Printf("failed    %s: %v\n", res.path, res.err)
+			continue
+		}
+		fmt.Printf("stamped   %s\n", res.path)
+	}
+
+	if !jsonOutput(c) {
+		fmt.Printf("\n%d stamped, %d failed\n", len(results)-failed, failed)
+	}
+	if failed != 0 {
+		return cli.NewExitError("", code)
+	}
+	return nil
+}
+
+func writeTimestamp(path string, ts *atum.Timestamp) error {
+	buf, err := json.Marshal(ts)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf, 0644)
+}
+
+// Checks the timestamp of each of the files given as arguments.
+func cmdVerifyFiles(c *cli.Context) error {
+	for _, flag := range []string{"file", "hex-nonce", "base64-nonce",
+		"stdin", "data-stdin", "timestamp", "recursive"} {
+		if c.IsSet(flag) {
+			return cli.NewExitError(fmt.Sprintf(
+				"--%s can't be combined with file arguments", flag), exitUsage)
+		}
+	}
+	policy, err := policyFromFlags(c)
+	if err != nil {
+		return err
+	}
+
+	paths := c.Args()
+	results := make([]fileResult, len(paths))
+	forEachConcurrently(c, len(paths), func(i int) {
+		results[i].path = paths[i]
+		results[i].ts, results[i].code, results[i].err = verifyFile(c,
+			paths[i], policy)
+	})
+
+	failed := 0
+	code := 0
+	for _, res := range results {
+		if res.err != nil {
+			failed++
+			if code == 0 {
+				code = res.code
+			}
+		}
+
+		if jsonOutput(c) {
+			jres := jsonResult{File: res.path}
+			valid := res.err == nil
+			jres.Valid = &valid
+			if valid {
+				jres.describe(res.ts)
+			} else {
+				jres.fail(res.err, res.code)
+			}
+			printJson(jres)
+			continue
+		}
+
+		if res.err != nil {
+			fmt.Printf("invalid   %s: %v\n", res.path, res.err)
+			continue
+		}
+		at := res.ts.GetTime()
+		fmt.Printf("valid     %s  (%s, %s, by %s)\n", res.path, at,
+			humanize.Time(at), res.ts.ServerUrl)
+	}
+
+	if !jsonOutput(c) {
+		fmt.Printf("\n%d valid, %d invalid\n", len(results)-failed, failed)
+	}
+	if failed != 0 {
+		return cli.NewExitError("", code)
+	}
+	return nil
+}
+
+// Checks the timestamp of the file stored next to it.  On failure, returns
+// the exit code as well.
+func verifyFile(c *cli.Context, path string, policy atum.VerificationPolicy) (
+	*atum.Timestamp, int, error) {
+	var ts atum.Timestamp
+	tsPath := path + ".atum-timestamp"
+	tsBuf, err := ioutil.ReadFile(tsPath)
+	if os.IsNotExist(err) {
+		return verifyAttachedFile(c, path, policy)
+	}
+	if err != nil {
+		return nil, exitIO, err
+	}
+	if err = json.Unmarshal(tsBuf, &ts); err != nil {
+		return nil, exitParse, fmt.Errorf("Failed to parse %s: %v",
+			tsPath, err)
+	}
+	if err = checkServer(c, &ts); err != nil {
+		return nil, exitInvalid, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, exitIO, err
+	}
+	defer file.Close()
+	valid, err2 := ts.VerifyFromWithPolicy(file, policy)
+	if err2 != nil {
+		return nil, exitServer, err2
+	}
+	if !valid {
+		return nil, exitInvalid, fmt.Errorf("Invalid signature")
+	}
+	return &ts, 0, nil
+}
+
+// Checks the attached timestamp of the file, in FILE.atum, or the attached
+// or embedded timestamp in the file itself.
+func verifyAttachedFile(c *cli.Context, path string,
+	policy atum.VerificationPolicy) (*atum.Timestamp, int, error) {
+	var data io.Reader
+	buf, err := ioutil.ReadFile(path + ".atum")
+	if os.IsNotExist(err) {
+		if isArchive(path) {
+			return verifyEmbedded(c, path, policy)
+		}
+
+		// Perhaps the file is an attached timestamp itself.
+		buf, err = ioutil.ReadFile(path)
+		if err == nil && parseAttached(buf) == nil {
+			return nil, exitIO, fmt.Errorf(
+				"There is no timestamp for %s", path)
+		}
+	} else if err == nil {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, exitIO, err
+		}
+		defer file.Close()
+		data = file
+	}
+	if err != nil {
+		return nil, exitIO, err
+	}
+	a := parseAttached(buf)
+	if a == nil {
+		return nil, exitParse, fmt.Errorf("Failed to parse %s.atum", path)
+	}
+	if code, err := verifyAttached(c, a, data, policy); err != nil {
+		return nil, code, err
+	}
+	return &a.Timestamp, 0, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"github.com/bwesterb/go-atum"
+
+	"github.com/urfave/cli"
+
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Exit codes of the commands.  They are documented in the README,
+// so don't change them.
+const (
+	// The timestamp (or manifest or log) is invalid
+	exitInvalid = 1
+
+	// Wrong flags or arguments
+	exitUsage = 2
+
+	// Failed to read or write a file, or to serialize what is written
+	exitIO = 3
+
+	// Malformed timestamp, manifest or tree head
+	exitParse = 4
+
+	// Failed to reach the server, or it refused the request.  Also used
+	// when a timestamp could not be checked, for instance because the
+	// server of the timestamp could not be asked about its public key.
+	exitServer = 5
+)
+
+// Names of the exit codes used for the errorCode field of the JSON output.
+var exitCodeNames = map[int]string{
+	exitInvalid: "invalid",
+	exitUsage:   "usage",
+	exitIO:      "io",
+	exitParse:   "parse",
+	exitServer:  "server",
+}
+
+// The result of stamp or verify with --output-format json.  One is printed
+// per line: one for every file if several are given.
+type jsonResult struct {
+	// The file that was stamped or checked, if any
+	File string `json:"file,omitempty"`
+
+	// Set by stamp: whether a timestamp was created
+	Stamped *bool `json:"stamped,omitempty"`
+
+	// Set by verify: whether the timestamp is valid
+	Valid *bool `json:"valid,omitempty"`
+
+	// Unix time and RFC 3339 date of the timestamp
+	Time int64  `json:"time,omitempty"`
+	Date string `json:"date,omitempty"`
+
+	// Server that set the timestamp
+	Server string `json:"server,omitempty"`
+
+	// Signature algorithm and SHA-256 hash of the public key of the
+	// (first) signature
+	Algorithm            atum.SignatureAlgorithm `json:"algorithm,omitempty"`
+	PublicKeyFingerprint string                  `json:"publicKeyFingerprint,omitempty"`
+
+	// The other signatures of a hybrid timestamp
+	ExtraSignatures []jsonSignature `json:"extraSignatures,omitempty"`
+
+	// Hash used to compress the message to a nonce, if any
+	Hashing atum.Hash `json:"hashing,omitempty"`
+
+	// Index in the transparency log of the server, if known
+	LogIndex *uint64 `json:"logIndex,omitempty"`
+
+	// Set by verify --recursive: the files changed since the manifest
+	Added    []string `json:"added,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+	Modified []string `json:"modified,omitempty"`
+
+	// Set by stamp: the file the timestamp was written to, if any
+	Output string `json:"output,omitempty"`
+
+	// Set by stamp: the timestamp itself
+	Timestamp *atum.Timestamp `json:"timestamp,omitempty"`
+
+	// Set on failure: the error and one of invalid, usage, io, parse
+	// and server.  See exitCodeNames.
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"errorCode,omitempty"`
+}
+
+type jsonSignature struct {
+	Algorithm            atum.SignatureAlgorithm `json:"algorithm"`
+	PublicKeyFingerprint string                  `json:"publicKeyFingerprint"`
+}
+
+func fingerprint(pk []byte) string {
+	h := sha256.Sum256(pk)
+	return hex.EncodeToString(h[:])
+}
+
+// Fills in the fields describing the timestamp.
+func (r *jsonResult) describe(ts *atum.Timestamp) {
+	r.Time = ts.Time
+	r.Date = ts.GetTime().UTC().Format(time.RFC3339)
+	r.Server = ts.ServerUrl
+	r.Algorithm = ts.Sig.Alg
+	r.PublicKeyFingerprint = fingerprint(ts.Sig.PublicKey)
+	for _, sig := range ts.ExtraSigs {
+		r.ExtraSignatures = append(r.ExtraSignatures, jsonSignature{
+			Algorithm:            sig.Alg,
+			PublicKeyFingerprint: fingerprint(sig.PublicKey),
+		})
+	}
+	if ts.Hashing != nil {
+		r.Hashing = ts.Hashing.Hash
+	}
+	if ts.Log != nil {
+		index := ts.Log.Index
+		r.LogIndex = &index
+	}
+}
+
+// Marks the result as failed with the given error and exit code.
+func (r *jsonResult) fail(err error, code int) {
+	r.Error = err.Error()
+	r.ErrorCode = exitCodeNames[code]
+}
+
+func printJson(v interface{}) {
+	buf, _ := json.Marshal(v)
+	os.Stdout.Write(buf)
+	os.Stdout.Write([]byte{10})
+}
+
+// Returns whether --output-format json is set.
+func jsonOutput(c *cli.Context) bool {
+	return c.String("output-format") == "json"
+}
+
+// Wraps the action of stamp or verify to check --output-format and, with
+// json, to report errors as JSON too.  Errors with an empty message are
+// assumed to have been reported by the action already.
+func withOutputFormat(action func(*cli.Context) error,
+	stamp bool) func(*cli.Context) error {
+	return func(c *cli.Context) error {
+		switch c.String("output-format") {
+		case "text", "json":
+		default:
+			return cli.NewExitError(fmt.Sprintf(
+				"Unknown --output-format %s: should be text or json",
+				c.String("output-format")), exitUsage)
+		}
+		err := action(c)
+		if !jsonOutput(c) || err == nil {
+			return err
+		}
+		exitErr, ok := err.(*cli.ExitError)
+		if !ok || exitErr.Error() == "" {
+			return err
+		}
+		res := jsonResult{File: c.String("file")}
+		if c.IsSet("recursive") {
+			res.File = c.String("recursive")
+		}
+		no := false
+		if stamp {
+			res.Stamped = &no
+		} else {
+			res.Valid = &no
+		}
+		res.fail(exitErr, exitErr.ExitCode())
+		printJson(res)
+		return cli.NewExitError("", exitErr.ExitCode())
+	}
+}
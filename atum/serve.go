@@ -0,0 +1,182 @@
+package main
+
+import (
+	"github.com/bwesterb/go-atum"
+	"github.com/bwesterb/go-atum/lms"
+	"github.com/bwesterb/go-atum/server"
+	"github.com/bwesterb/go-atum/stamper"
+
+	"github.com/urfave/cli"
+
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parameters of the LMS keys generated by atum serve: two levels of
+// height 10, which allows for about a million signatures.
+var defaultLMSParams = lms.Params{
+	{Lms: lms.LMS_SHA256_M32_H10, Ots: lms.LMOTS_SHA256_N32_W4},
+	{Lms: lms.LMS_SHA256_M32_H10, Ots: lms.LMOTS_SHA256_N32_W4},
+}
+
+// Opens the key for the given signature algorithm in the key directory,
+// generating it if it does not exist.
+func openSigner(c *cli.Context, alg atum.SignatureAlgorithm) (
+	stamper.Signer, io.Closer, error) {
+	keyDir := c.String("key-dir")
+	switch alg {
+	case atum.Ed25519:
+		sk, err := loadEd25519Key(filepath.Join(keyDir, "ed25519.key"))
+		if err != nil {
+			return nil, nil, err
+		}
+		return stamper.NewEd25519Signer(sk), nil, nil
+	case atum.XMSSMT:
+		ks, err := stamper.OpenKeyStore(filepath.Join(keyDir, "xmssmt"),
+			stamper.KeyStoreOptions{Params: c.String("xmssmt-params")})
+		if err != nil {
+			return nil, nil, err
+		}
+		return ks, ks, nil
+	case atum.LMS:
+		path := filepath.Join(keyDir, "lms.key")
+		key, err := stamper.LoadLMSKey(path)
+		if os.IsNotExist(err) {
+			key, err = stamper.GenerateLMSKey(path, defaultLMSParams)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, key, nil
+	default:
+		return nil, nil, fmt.Errorf("Unknown signature algorithm %s", alg)
+	}
+}
+
+// The highest difficulty go-pow's sha2bday proof of work supports: it
+// compares the lower DIFFICULTY bits of 64-bit integers.
+const maxPowDifficulty = 63
+
+// Parses the --pow flags, which are of the form ALG=DIFFICULTY.
+func parsePowFlags(c *cli.Context) (
+	map[atum.SignatureAlgorithm]uint32, error) {
+	ret := make(map[atum.SignatureAlgorithm]uint32)
+	for _, flag := range c.StringSlice("pow") {
+		bits := strings.SplitN(flag, "=", 2)
+		if len(bits) != 2 {
+			return nil, fmt.Errorf("--pow %s should be of the form ALG=DIFFICULTY",
+				flag)
+		}
+		diff, err := strconv.ParseUint(bits[1], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("--pow %s: invalid difficulty", flag)
+		}
+		if diff > maxPowDifficulty {
+			return nil, fmt.Errorf("--pow %s: difficulty can't exceed %d",
+				flag, maxPowDifficulty)
+		}
+		ret[atum.SignatureAlgorithm(bits[0])] = uint32(diff)
+	}
+	return ret, nil
+}
+
+func cmdServe(c *cli.Context) error {
+	if c.NArg() != 0 {
+		return cli.NewExitError("I don't expect arguments; only flags", exitUsage)
+	}
+	if !c.IsSet("key-dir") {
+		return cli.NewExitError("Please specify --key-dir", exitUsage)
+	}
+	if c.IsSet("tls-cert") != c.IsSet("tls-key") {
+		return cli.NewExitError(
+			"--tls-cert and --tls-key should be set together", exitUsage)
+	}
+
+	cfg := server.Config{
+		Url:           c.String("url"),
+		MaxNonceSize:  int64(c.Int("max-nonce-size")),
+		AcceptableLag: int64(c.Int("acceptable-lag")),
+		DataDir:       c.String("data-dir"),
+	}
+	if cfg.DataDir == "" {
+		cfg.DataDir = filepath.Join(c.String("key-dir"), "data")
+	}
+	var err error
+	if cfg.PowDifficulty, err = parsePowFlags(c); err != nil {
+		return cli.NewExitError(err.Error(), exitUsage)
+	}
+	for _, witness := range c.StringSlice("witness") {
+		pk, err := base64.StdEncoding.DecodeString(witness)
+		if err != nil {
+			return cli.NewExitError("Failed to parse --witness", exitUsage)
+		}
+		cfg.Witnesses = append(cfg.Witnesses, pk)
+	}
+
+	if err = os.MkdirAll(c.String("key-dir"), 0700); err != nil {
+		return cli.NewExitError(fmt.Sprintf("os.MkdirAll(%s): %v",
+			c.String("key-dir"), err), exitIO)
+	}
+	for _, alg := range strings.Split(c.String("alg"), ",") {
+		alg := atum.SignatureAlgorithm(strings.TrimSpace(alg))
+		signer, closer, err := openSigner(c, alg)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf(
+				"Failed to open %s key: %v", alg, err), exitIO)
+		}
+		if closer != nil {
+			defer closer.Close()
+		}
+		cfg.Signers = append(cfg.Signers, signer)
+		log.Printf("%s public key: %s", alg,
+			base64.StdEncoding.EncodeToString(signer.PublicKey()))
+	}
+
+	s, err := server.New(cfg)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to start server: %v", err), exitIO)
+	}
+	defer s.Close()
+
+	httpServer := &http.Server{
+		Addr:    c.String("listen"),
+		Handler: s,
+	}
+
+	// Shut down gracefully on interrupt.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+	go func() {
+		<-sigs
+		log.Printf("Shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(),
+			10*time.Second)
+		defer cancel()
+		httpServer.Shutdown(ctx)
+	}()
+
+	log.Printf("Listening on %s", httpServer.Addr)
+	if c.IsSet("tls-cert") {
+		err = httpServer.ListenAndServeTLS(c.String("tls-cert"),
+			c.String("tls-key"))
+	} else {
+		err = httpServer.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		return cli.NewExitError(fmt.Sprintf("Server failed: %v", err),
+			exitServer)
+	}
+	return nil
+}
@@ -5,13 +5,18 @@ import (
 
 	"github.com/urfave/cli"
 
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -20,81 +25,127 @@ func cmdStamp(c *cli.Context) error {
 	var err error
 	var hashing *atum.Hashing
 
+	// A single - means the data is read from stdin.
+	stdin := c.Bool("stdin-data")
 	if c.NArg() != 0 {
-		return cli.NewExitError("I don't expect arguments; only flags", 13)
+		if c.NArg() != 1 || c.Args().First() != "-" {
+			return cmdStampFiles(c)
+		}
+		stdin = true
+	}
+
+	if c.Bool("attach") {
+		return cmdStampAttached(c, stdin)
+	}
+
+	if c.Bool("embed") {
+		return cmdStampEmbedded(c)
 	}
 
 	if c.IsSet("hex-nonce") {
 		req.Nonce, err = hex.DecodeString(c.String("hex-nonce"))
 		if err != nil {
-			return cli.NewExitError("Failed to parse --hex-nonce", 1)
+			return cli.NewExitError("Failed to parse --hex-nonce", exitUsage)
 		}
 	}
 
 	if c.IsSet("base64-nonce") {
 		if req.Nonce != nil {
 			return cli.NewExitError(
-				"--hex-nonce and --base64-nonce shouldn't both be set", 2)
+				"--hex-nonce and --base64-nonce shouldn't both be set", exitUsage)
 		}
 		req.Nonce, err = base64.StdEncoding.DecodeString(
 			c.String("base64-nonce"))
 		if err != nil {
-			return cli.NewExitError("Failed to parse --base64-nonce", 1)
+			return cli.NewExitError("Failed to parse --base64-nonce", exitUsage)
 		}
 	}
 
-	if c.IsSet("file") {
-		if req.Nonce != nil {
+	if c.IsSet("file") || stdin {
+		if req.Nonce != nil || c.IsSet("file") && stdin {
 			return cli.NewExitError(
-				"Only one of --hex-nonce, --file and --base64-nonce should be set", 7)
+				"Only one of --hex-nonce, --file, --base64-nonce and "+
+					"--stdin-data should be set", exitUsage)
+		}
+		var data io.Reader = os.Stdin
+		if c.IsSet("file") {
+			file, err := os.Open(c.String("file"))
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf("Failed to open file: %v",
+					err), exitIO)
+			}
+			defer file.Close()
+			data = file
+		}
+		if hashing, err = hashingFromFlags(c); err != nil {
+			return cli.NewExitError(err.Error(), exitUsage)
 		}
-		file, err := os.Open(c.String("file"))
+		req.Nonce, err = hashing.ComputeNonce(data)
 		if err != nil {
-			return cli.NewExitError(fmt.Sprintf("Failed to open file: %v",
-				err), 8)
+			return cli.NewExitError(fmt.Sprintf("ComputeNonce(): %v", err), exitIO)
 		}
-		defer file.Close()
-		hashing = &atum.Hashing{
-			Hash:   atum.Shake256,
-			Prefix: make([]byte, 32),
+	}
+
+	var m *manifest
+	if c.IsSet("recursive") {
+		if req.Nonce != nil {
+			return cli.NewExitError(
+				"--recursive can't be combined with --hex-nonce, --file, "+
+					"--base64-nonce or --stdin-data", exitUsage)
 		}
-		rand.Read(hashing.Prefix)
-		req.Nonce, err = hashing.ComputeNonce(file)
+		dir := c.String("recursive")
+		ignore, err := readIgnoreFile(dir)
 		if err != nil {
-			return cli.NewExitError(fmt.Sprintf("ComputeNonce(): %v", err), 9)
+			return cli.NewExitError(fmt.Sprintf(
+				"Failed to read %s: %v", ignoreFileName, err), exitIO)
 		}
+		ignore = append(ignore, c.StringSlice("ignore")...)
+		m, err = buildManifest(dir, ignore)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf(
+				"Failed to hash files: %v", err), exitIO)
+		}
+		req.Nonce = m.Nonce()
 	}
 
 	if req.Nonce == nil {
 		return cli.NewExitError(
-			"Either --base64-nonce, --hex-nonce or --file should be set", 3)
-	}
-
-	var theTime int64
-	if c.IsSet("time") {
-		theTime = int64(c.Int("time"))
-	} else {
-		theTime = time.Now().Unix()
+			"Either --base64-nonce, --hex-nonce, --file, --stdin-data "+
+				"or --recursive should be set", exitUsage)
 	}
-	req.Time = &theTime
 
-	if c.IsSet("alg") {
-		var preferredAlg = atum.SignatureAlgorithm(c.String("alg"))
-		req.PreferredSigAlg = &preferredAlg
-	}
+	requestFromFlags(c, &req)
 
-	ts, err := atum.SendRequest(c.String("server"), req)
+	ctx, cancel := interruptContext()
+	defer cancel()
+	opts, done := requestOptionsFromFlags(c)
+	ts, err := atum.SendRequestContext(ctx, c.String("server"), req, opts)
+	done()
 	if err != nil {
 		return cli.NewExitError(fmt.Sprintf(
-			"Failed to create timestamp: %v", err), 4)
+			"Failed to create timestamp: %v", err), exitServer)
 	}
 
 	ts.Hashing = hashing
 
+	if m != nil {
+		m.Timestamp = ts
+		outFile := filepath.Join(c.String("recursive"), manifestName)
+		if c.IsSet("output") {
+			outFile = c.String("output")
+		}
+		if err = writeManifest(outFile, m); err != nil {
+			return cli.NewExitError(fmt.Sprintf(
+				"Failed to write to %s: %v", outFile, err), exitIO)
+		}
+		reportStamped(c, c.String("recursive"), outFile, ts)
+		return nil
+	}
+
 	tsBuf, err := json.Marshal(ts)
 	if err != nil {
 		return cli.NewExitError(fmt.Sprintf(
-			"Failed to convert timestamp to JSON: %v", err), 5)
+			"Failed to convert timestamp to JSON: %v", err), exitIO)
 	}
 
 	var outFile string
@@ -102,6 +153,9 @@ func cmdStamp(c *cli.Context) error {
 		outFile = c.String("output")
 	} else if c.IsSet("file") {
 		outFile = c.String("file") + ".atum-timestamp"
+	} else if jsonOutput(c) {
+		reportStamped(c, "", "", ts)
+		return nil
 	} else {
 		os.Stdout.Write(tsBuf)
 		os.Stdout.Write([]byte{10})
@@ -111,8 +165,112 @@ func cmdStamp(c *cli.Context) error {
 	err = ioutil.WriteFile(outFile, tsBuf, 0644)
 	if err != nil {
 		return cli.NewExitError(fmt.Sprintf(
-			"Failed to write to %s: %v", outFile, err), 6)
+			"Failed to write to %s: %v", outFile, err), exitIO)
 	}
 
+	reportStamped(c, c.String("file"), outFile, ts)
 	return nil
 }
+
+// With --output-format json, prints the timestamp created for the file
+// (if any) that was written to outFile (if any).
+func reportStamped(c *cli.Context, file, outFile string, ts *atum.Timestamp) {
+	if !jsonOutput(c) {
+		return
+	}
+	stamped := true
+	res := jsonResult{
+		File:      file,
+		Stamped:   &stamped,
+		Output:    outFile,
+		Timestamp: ts,
+	}
+	res.describe(ts)
+	printJson(res)
+}
+
+// Sets the time and signature algorithms of the request from the flags.
+func requestFromFlags(c *cli.Context, req *atum.Request) {
+	var theTime int64
+	if c.IsSet("time") {
+		theTime = int64(c.Int("time"))
+	} else {
+		theTime = time.Now().Unix()
+	}
+	req.Time = &theTime
+
+	if c.String("alg") != "" {
+		algs := strings.Split(c.String("alg"), ",")
+		if len(algs) == 1 {
+			var preferredAlg = atum.SignatureAlgorithm(algs[0])
+			req.PreferredSigAlg = &preferredAlg
+		} else {
+			for _, alg := range algs {
+				req.SigAlgs = append(req.SigAlgs, atum.SignatureAlgorithm(alg))
+			}
+		}
+	}
+}
+
+// Returns the hashing, with a random prefix, to compress a file to a nonce
+// using the hash set with --hash.
+func hashingFromFlags(c *cli.Context) (*atum.Hashing, error) {
+	hash := atum.Hash(c.String("hash"))
+	if hash != atum.Shake256 {
+		return nil, fmt.Errorf("Unsupported --hash %s: only %s is supported",
+			hash, atum.Shake256)
+	}
+	ret := &atum.Hashing{
+		Hash:   hash,
+		Prefix: make([]byte, 32),
+	}
+	rand.Read(ret.Prefix)
+	return ret, nil
+}
+
+// Returns a context that is cancelled on interrupt, which cancels
+// the request (and proof of work).
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		select {
+		case <-sigs:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigs)
+	}()
+	return ctx, cancel
+}
+
+// Returns the request options set by the flags.  Unless --quiet is set,
+// the progress of the proof of work is shown on stderr; call the returned
+// function when the request is done to end the progress line.
+func requestOptionsFromFlags(c *cli.Context) (atum.RequestOptions, func()) {
+	opts := atum.RequestOptions{
+		ProofOfWork: atum.PowOptions{
+			Threads:       c.Int("threads"),
+			MaxDifficulty: uint32(c.Int("max-difficulty")),
+		},
+	}
+	showedProgress := false
+	if !c.Bool("quiet") {
+		opts.ProofOfWork.Progress = func(p atum.PowProgress) {
+			showedProgress = true
+			pct := 100 * float64(p.Hashes) / float64(p.ExpectedHashes)
+			if pct > 99 {
+				pct = 99 // it's an estimate
+			}
+			fmt.Fprintf(os.Stderr,
+				"\rProof of work (difficulty %d): %2.0f%%, about %s left   ",
+				p.Difficulty, pct, p.Remaining.Round(time.Second))
+		}
+	}
+	return opts, func() {
+		if showedProgress {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
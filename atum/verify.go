@@ -14,6 +14,8 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 func cmdVerify(c *cli.Context) error {
@@ -22,20 +24,29 @@ func cmdVerify(c *cli.Context) error {
 	var err error
 
 	if c.NArg() != 0 {
-		return cli.NewExitError("I don't expect arguments; only flags", 13)
+		return cmdVerifyFiles(c)
+	}
+
+	if c.IsSet("recursive") {
+		return cmdVerifyDir(c)
 	}
 
 	// Read timestamp
 	if c.IsSet("timestamp") && c.IsSet("stdin") {
 		return cli.NewExitError(
-			"--timestamp and --stdin can't both be set", 11)
+			"--timestamp and --stdin can't both be set", exitUsage)
+	}
+
+	if c.IsSet("data-stdin") && !c.IsSet("timestamp") {
+		return cli.NewExitError(
+			"--data-stdin requires --timestamp", exitUsage)
 	}
 
 	if c.IsSet("stdin") {
 		tsBuf, err = ioutil.ReadAll(os.Stdin)
 		if err != nil {
 			return cli.NewExitError(fmt.Sprintf(
-				"ioutil.ReadAll(stdin): %v", err), 10)
+				"ioutil.ReadAll(stdin): %v", err), exitIO)
 		}
 	} else if c.IsSet("timestamp") || c.IsSet("file") {
 		var tsPath string
@@ -43,26 +54,35 @@ func cmdVerify(c *cli.Context) error {
 			tsPath = c.String("timestamp")
 		} else {
 			tsPath = c.String("file") + ".atum-timestamp"
+			if _, err := os.Stat(tsPath); os.IsNotExist(err) {
+				tsPath = c.String("file") + ".atum"
+			}
+			if _, err := os.Stat(tsPath); os.IsNotExist(err) &&
+				isArchive(c.String("file")) {
+				return cmdVerifyEmbedded(c)
+			}
 		}
 		tsBuf, err = ioutil.ReadFile(tsPath)
 		if err != nil {
 			return cli.NewExitError(fmt.Sprintf("ioutil.ReadFile(%s): %v",
-				tsPath, err), 10)
+				tsPath, err), exitIO)
 		}
 	}
 
+	if a := parseAttached(tsBuf); a != nil {
+		return cmdVerifyAttached(c, a)
+	}
+
 	// Parse timestamp
 	err = json.Unmarshal(tsBuf, &ts)
 	if err != nil {
 		return cli.NewExitError(fmt.Sprintf(
-			"Failed to parse timestamp file: %v", err), 11)
+			"Failed to parse timestamp file: %v", err), exitParse)
 	}
 
 	// Check if the server is ok
-	if c.IsSet("server") && c.String("server") != ts.ServerUrl {
-		return cli.NewExitError(fmt.Sprintf(
-			"The timestamp is from %v instead of %v",
-			ts.ServerUrl, c.String("server")), 12)
+	if err := checkServer(c, &ts); err != nil {
+		return cli.NewExitError(err.Error(), exitInvalid)
 	}
 
 	// Check the timestamp
@@ -70,7 +90,7 @@ func cmdVerify(c *cli.Context) error {
 	if c.IsSet("hex-nonce") {
 		nonce, err := hex.DecodeString(c.String("hex-nonce"))
 		if err != nil {
-			return cli.NewExitError("Failed to parse --hex-nonce", 1)
+			return cli.NewExitError("Failed to parse --hex-nonce", exitUsage)
 		}
 		msgReader = bytes.NewReader(nonce)
 	}
@@ -78,11 +98,11 @@ func cmdVerify(c *cli.Context) error {
 	if c.IsSet("base64-nonce") {
 		if msgReader != nil {
 			return cli.NewExitError(
-				"--hex-nonce and --base64-nonce shouldn't both be set", 2)
+				"--hex-nonce and --base64-nonce shouldn't both be set", exitUsage)
 		}
 		nonce, err := base64.StdEncoding.DecodeString(c.String("base64-nonce"))
 		if err != nil {
-			return cli.NewExitError("Failed to parse --base64-nonce", 1)
+			return cli.NewExitError("Failed to parse --base64-nonce", exitUsage)
 		}
 		msgReader = bytes.NewReader(nonce)
 	}
@@ -90,25 +110,89 @@ func cmdVerify(c *cli.Context) error {
 	if c.IsSet("file") {
 		if msgReader != nil {
 			return cli.NewExitError(
-				"--hex-nonce, --file and --base64-nonce can't be set together", 2)
+				"--hex-nonce, --file and --base64-nonce can't be set together", exitUsage)
 		}
 		file, err := os.Open(c.String("file"))
 		if err != nil {
 			return cli.NewExitError(
-				fmt.Sprintf("os.Open(%s): %v", c.String("file"), err), 8)
+				fmt.Sprintf("os.Open(%s): %v", c.String("file"), err), exitIO)
 		}
 		defer file.Close()
 		msgReader = file
 	}
 
-	valid, err := ts.VerifyFrom(msgReader)
+	if c.IsSet("data-stdin") {
+		if msgReader != nil {
+			return cli.NewExitError(
+				"--hex-nonce, --file, --base64-nonce and --data-stdin "+
+					"can't be set together", exitUsage)
+		}
+		msgReader = os.Stdin
+	}
+
+	policy, err := policyFromFlags(c)
+	if err != nil {
+		return err
+	}
+
+	valid, err := ts.VerifyFromWithPolicy(msgReader, policy)
 	if err != nil {
 		return cli.NewExitError(
-			fmt.Sprintf("Verify: %v", err), 12)
+			fmt.Sprintf("Verify: %v", err), exitServer)
 	}
 
 	if !valid {
-		return cli.NewExitError("Invalid signature", 12)
+		return cli.NewExitError("Invalid signature", exitInvalid)
+	}
+
+	printValid(c, c.String("file"), &ts)
+	return nil
+}
+
+// Verifies an attached timestamp, and that the file or data read from
+// stdin is its message, if set.
+func cmdVerifyAttached(c *cli.Context, a *atum.AttachedTimestamp) error {
+	if c.IsSet("hex-nonce") || c.IsSet("base64-nonce") {
+		return cli.NewExitError("The timestamp is on a file; not on a nonce",
+			exitUsage)
+	}
+	var data io.Reader
+	if c.IsSet("file") {
+		file, err := os.Open(c.String("file"))
+		if err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("os.Open(%s): %v", c.String("file"), err), exitIO)
+		}
+		defer file.Close()
+		data = file
+	} else if c.IsSet("data-stdin") {
+		data = os.Stdin
+	}
+
+	policy, err := policyFromFlags(c)
+	if err != nil {
+		return err
+	}
+	if code, err := verifyAttached(c, a, data, policy); err != nil {
+		return cli.NewExitError(err.Error(), code)
+	}
+	file := c.String("file")
+	if file == "" {
+		file = a.Name
+	}
+	printValid(c, file, &a.Timestamp)
+	return nil
+}
+
+// Prints that the timestamp (of the given file, if any) is valid, with
+// details if --verbose is set.
+func printValid(c *cli.Context, file string, ts *atum.Timestamp) {
+	if jsonOutput(c) {
+		valid := true
+		res := jsonResult{File: file, Valid: &valid}
+		res.describe(ts)
+		printJson(res)
+		return
 	}
 
 	at := ts.GetTime()
@@ -117,8 +201,150 @@ func cmdVerify(c *cli.Context) error {
 		at, humanize.Time(at), ts.ServerUrl)
 
 	if c.IsSet("verbose") {
-		fmt.Printf("\n(%s)\n", ts.Sig)
+		fmt.Println()
+		for _, sig := range ts.Signatures() {
+			fmt.Printf("(%s)\n", sig)
+		}
+		if ts.Log != nil && ts.Log.Complete() {
+			fmt.Printf("(entry %d of transparency log with %d entries)\n",
+				ts.Log.Index, ts.Log.TreeHead.Size)
+		} else if ts.Log != nil {
+			fmt.Printf("(entry %d of transparency log, "+
+				"not yet in a signed tree head)\n", ts.Log.Index)
+		}
+	}
+}
+
+// Checks that the timestamp is from the server set with --server, and
+// from one of the servers set with --trusted-server, if any.
+func checkServer(c *cli.Context, ts *atum.Timestamp) error {
+	if c.IsSet("server") && c.String("server") != ts.ServerUrl {
+		return fmt.Errorf("The timestamp is from %v instead of %v",
+			ts.ServerUrl, c.String("server"))
+	}
+	trusted := stringSliceFlag(c, "trusted-server")
+	if len(trusted) == 0 {
+		return nil
+	}
+	for _, serverUrl := range trusted {
+		if normalizeServerUrl(serverUrl) == normalizeServerUrl(ts.ServerUrl) {
+			return nil
+		}
+	}
+	return fmt.Errorf("The timestamp is from %v, which is not a trusted server",
+		ts.ServerUrl)
+}
+
+// Returns the verification policy set by the flags.
+func policyFromFlags(c *cli.Context) (atum.VerificationPolicy, error) {
+	var policy atum.VerificationPolicy
+	if c.IsSet("any-signature") {
+		policy.Signatures = atum.AnySignature
+	}
+	if c.String("require-alg") != "" {
+		for _, alg := range strings.Split(c.String("require-alg"), ",") {
+			policy.SigAlgs = append(policy.SigAlgs,
+				atum.SignatureAlgorithm(strings.TrimSpace(alg)))
+		}
+	}
+	policy.RequireLogProof = c.IsSet("require-log")
+	for _, witness := range stringSliceFlag(c, "witness") {
+		pk, err := base64.StdEncoding.DecodeString(witness)
+		if err != nil {
+			return policy, cli.NewExitError("Failed to parse --witness", exitUsage)
+		}
+		policy.Witnesses = append(policy.Witnesses, pk)
+	}
+	policy.MinCosignatures = c.Int("min-cosignatures")
+	if policy.MinCosignatures > len(policy.Witnesses) {
+		return policy, cli.NewExitError(
+			"--min-cosignatures exceeds the number of --witness keys", exitUsage)
+	}
+	return policy, nil
+}
+
+// Verifies the manifest of the directory set with --recursive.
+func cmdVerifyDir(c *cli.Context) error {
+	if c.IsSet("file") || c.IsSet("hex-nonce") || c.IsSet("base64-nonce") ||
+		c.IsSet("stdin") || c.IsSet("data-stdin") {
+		return cli.NewExitError("--recursive can't be combined with "+
+			"--file, --hex-nonce, --base64-nonce, --stdin or --data-stdin",
+			exitUsage)
+	}
+	dir := c.String("recursive")
+	manifestPath := filepath.Join(dir, manifestName)
+	if c.IsSet("timestamp") {
+		manifestPath = c.String("timestamp")
+	}
+	m, err := readManifest(manifestPath)
+	if _, ok := err.(*os.PathError); ok {
+		return cli.NewExitError(err.Error(), exitIO)
+	} else if err != nil {
+		return cli.NewExitError(err.Error(), exitParse)
+	}
+	ts := m.Timestamp
+
+	if err := checkServer(c, ts); err != nil {
+		return cli.NewExitError(err.Error(), exitInvalid)
+	}
+
+	policy, err := policyFromFlags(c)
+	if err != nil {
+		return err
+	}
+	valid, err := ts.VerifyFromWithPolicy(bytes.NewReader(m.Nonce()), policy)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Verify: %v", err), exitServer)
+	}
+	if !valid {
+		return cli.NewExitError("Invalid signature on manifest", exitInvalid)
+	}
+
+	ignore := append(ignorePatterns(m.Ignore), c.StringSlice("ignore")...)
+	cur, err := buildManifest(dir, ignore)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to hash files: %v", err), exitIO)
+	}
+	diff := diffManifests(m, cur)
+	if jsonOutput(c) {
+		valid := diff.Empty()
+		res := jsonResult{
+			File:     dir,
+			Valid:    &valid,
+			Added:    diff.Added,
+			Removed:  diff.Removed,
+			Modified: diff.Modified,
+		}
+		res.describe(ts)
+		if !valid {
+			res.fail(fmt.Errorf("Files were changed since the manifest "+
+				"was stamped"), exitInvalid)
+			printJson(res)
+			return cli.NewExitError("", exitInvalid)
+		}
+		printJson(res)
+		return nil
+	}
+	for _, p := range diff.Added {
+		fmt.Printf("added     %s\n", p)
+	}
+	for _, p := range diff.Removed {
+		fmt.Printf("removed   %s\n", p)
+	}
+	for _, p := range diff.Modified {
+		fmt.Printf("modified  %s\n", p)
+	}
+	if !diff.Empty() {
+		fmt.Println()
+		printValid(c, dir, ts)
+		return cli.NewExitError(fmt.Sprintf(
+			"The manifest is valid, but %d files were added, %d removed "+
+				"and %d modified since", len(diff.Added),
+			len(diff.Removed), len(diff.Modified)), exitInvalid)
 	}
 
+	printValid(c, dir, ts)
+	fmt.Printf("\nfor all %d files in %s\n", len(m.Files), dir)
 	return nil
 }
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"github.com/bwesterb/go-atum"
+
+	"github.com/urfave/cli"
+
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Reports the files in a directory tree that are created or modified.
+type watcher interface {
+	// Stops watching.
+	Close() error
+}
+
+// Returned by newNotifyWatcher() on platforms without filesystem
+// notifications.
+var errNotifyUnsupported = errors.New(
+	"filesystem notifications are not supported on this platform")
+
+// Returns whether the file or directory at the path should be watched.
+type watchFilter func(path string, isDir bool) bool
+
+// A line of the index written by `atum watch --index`.
+type watchIndexEntry struct {
+	// Path relative to the watched directory, separated by slashes
+	File string
+
+	Timestamp *atum.Timestamp
+}
+
+// Returns a watcher that sends the paths of the files in dir that are
+// created or modified to changed.  It uses filesystem notifications,
+// unless --poll is set or they are not available.
+func newWatcher(c *cli.Context, dir string, filter watchFilter,
+	changed chan<- string) (watcher, error) {
+	if !c.Bool("poll") {
+		w, err := newNotifyWatcher(dir, filter, changed)
+		if err == nil {
+			return w, nil
+		}
+		if err != errNotifyUnsupported {
+			fmt.Fprintf(os.Stderr, "Falling back to polling: %v\n", err)
+		}
+	}
+	return newPollWatcher(dir, c.Duration("poll-interval"), filter, changed)
+}
+
+// Watches a directory by looking for changed modification times and sizes
+// every interval.
+type pollWatcher struct {
+	dir     string
+	filter  watchFilter
+	changed chan<- string
+	seen    map[string]pollState
+	stop    chan struct{}
+}
+
+type pollState struct {
+	modTime time.Time
+	size    int64
+}
+
+func newPollWatcher(dir string, interval time.Duration, filter watchFilter,
+	changed chan<- string) (watcher, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("--poll-interval should be positive")
+	}
+	w := &pollWatcher{
+		dir:     dir,
+		filter:  filter,
+		changed: changed,
+		seen:    make(map[string]pollState),
+		stop:    make(chan struct{}),
+	}
+	if err := w.scan(false); err != nil {
+		return nil, err
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				if err := w.scan(true); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to scan %s: %v\n", dir, err)
+				}
+			}
+		}
+	}()
+	return w, nil
+}
+
+// Walks the directory and, if report is set, sends the files that are new
+// or changed since the previous scan.
+func (w *pollWatcher) scan(report bool) error {
+	seen := make(map[string]pollState)
+	err := filepath.Walk(w.dir, func(path string, info os.FileInfo,
+		err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path != w.dir {
+				return nil // removed while walking
+			}
+			return err
+		}
+		if path != w.dir && !w.filter(path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		state := pollState{modTime: info.ModTime(), size: info.Size()}
+		seen[path] = state
+		if old, ok := w.seen[path]; report && (!ok || old != state) {
+			w.changed <- path
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	w.seen = seen
+	return nil
+}
+
+func (w *pollWatcher) Close() error {
+	close(w.stop)
+	return nil
+}
+
+// Puts timestamps on the files in a directory when they are created or
+// modified, once they haven't changed for a while.
+func cmdWatch(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError("I expect a single directory to watch",
+			exitUsage)
+	}
+	dir := filepath.Clean(c.Args().First())
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return cli.NewExitError(fmt.Sprintf("%s is not a directory", dir),
+			exitUsage)
+	}
+	if _, err := hashingFromFlags(c); err != nil {
+		return cli.NewExitError(err.Error(), exitUsage)
+	}
+	interval := c.Duration("interval")
+	settle := c.Duration("settle")
+	if interval <= 0 || settle < 0 {
+		return cli.NewExitError(
+			"--interval should be positive and --settle not negative",
+			exitUsage)
+	}
+
+	include := ignorePatterns(c.StringSlice("include"))
+	ignore, err := readIgnoreFile(dir)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to read %s: %v", ignoreFileName, err), exitIO)
+	}
+	ignore = append(ignore, c.StringSlice("ignore")...)
+	for _, ps := range []ignorePatterns{include, ignore} {
+		if err := ps.check(); err != nil {
+			return cli.NewExitError(err.Error(), exitUsage)
+		}
+	}
+
+	indexPath := c.String("index")
+	var absIndexPath string // to recognise the index however dir is given
+	if indexPath != "" {
+		indexPath = filepath.Clean(indexPath)
+		if absIndexPath, err = filepath.Abs(indexPath); err != nil {
+			return cli.NewExitError(err.Error(), exitIO)
+		}
+	}
+	filter := func(path string, isDir bool) bool {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return false
+		}
+		rel = filepath.ToSlash(rel)
+		if ignore.match(rel, isDir) {
+			return false
+		}
+		if isDir {
+			return true
+		}
+		// Don't stamp our own output.
+		if absPath, err := filepath.Abs(path); err == nil &&
+			absPath == absIndexPath {
+			return false
+		}
+		if strings.HasSuffix(path, ".atum-timestamp") ||
+			strings.HasSuffix(path, ".atum") || rel == ignoreFileName {
+			return false
+		}
+		return len(include) == 0 || include.match(rel, false)
+	}
+
+	// The files stamped before, to skip them with --initial.
+	stamped := make(map[string]bool)
+	if indexPath != "" {
+		if stamped, err = readWatchIndex(dir, indexPath); err != nil {
+			return cli.NewExitError(fmt.Sprintf(
+				"Failed to read %s: %v", indexPath, err), exitIO)
+		}
+	}
+
+	changed := make(chan string, 64)
+	w, err := newWatcher(c, dir, filter, changed)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to watch %s: %v", dir, err), exitIO)
+	}
+	defer w.Close()
+
+	// The files changed since they were last stamped with the time of
+	// their last change.
+	pending := make(map[string]time.Time)
+
+	if c.Bool("initial") {
+		err = filepath.Walk(dir, func(path string, info os.FileInfo,
+			err error) error {
+			if err != nil || path == dir {
+				return err
+			}
+			if !filter(path, info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.Mode().IsRegular() || stamped[path] {
+				return nil
+			}
+			if indexPath == "" {
+				if _, err := os.Stat(path + ".atum-timestamp"); err == nil {
+					return nil
+				}
+			}
+			pending[path] = time.Time{}
+			return nil
+		})
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf(
+				"Failed to read %s: %v", dir, err), exitIO)
+		}
+	}
+
+	fmt.Printf("Watching %s; press Ctrl-C to stop\n", dir)
+	ctx, cancel := interruptContext()
+	defer cancel()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// SHA-256 of the files as they were stamped, to skip files that were
+	// touched without changing them.
+	digests := make(map[string][]byte)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case path := <-changed:
+			pending[path] = time.Now()
+			continue
+		case <-ticker.C:
+		}
+
+		// Stamp the files that settled.
+		var paths []string
+		for path, t := range pending {
+			if time.Since(t) < settle {
+				continue
+			}
+			delete(pending, path)
+			info, err := os.Lstat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				continue // removed in the meantime
+			}
+			digest, _, err := hashFile(path)
+			if err == nil && bytes.Equal(digest, digests[path]) {
+				continue
+			}
+			paths = append(paths, path)
+		}
+		if len(paths) == 0 {
+			continue
+		}
+		for _, path := range stampWatched(ctx, c, dir, indexPath, paths,
+			digests) {
+			pending[path] = time.Now() // try again
+		}
+	}
+}
+
+// Puts a timestamp on the files with a single request, and writes them
+// next to the files or to the index.  Returns the files that should be
+// retried.
+func stampWatched(ctx context.Context, c *cli.Context, dir, indexPath string,
+	paths []string, digests map[string][]byte) []string {
+	var todo []string
+	var hashings []*atum.Hashing
+	var nonces [][]byte
+	var fileDigests [][]byte
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Printf("failed    %s: %v\n", path, err)
+			continue
+		}
+		hashing, _ := hashingFromFlags(c)
+		nonce, err2 := hashing.ComputeNonce(file)
+		file.Close()
+		if err2 != nil {
+			fmt.Printf("failed    %s: %v\n", path, err2)
+			continue
+		}
+		digest, _, _ := hashFile(path)
+		todo = append(todo, path)
+		hashings = append(hashings, hashing)
+		nonces = append(nonces, nonce)
+		fileDigests = append(fileDigests, digest)
+	}
+
+	var req atum.Request
+	requestFromFlags(c, &req)
+	opts, done := requestOptionsFromFlags(c)
+	tss, err := atum.SendBatchRequest(ctx, c.String("server"), req, nonces,
+		opts)
+	done()
+	if err != nil {
+		fmt.Printf("failed    %d files: %v; retrying later\n", len(todo), err)
+		return todo
+	}
+
+	var index *os.File
+	if indexPath != "" {
+		var err error
+		index, err = os.OpenFile(indexPath,
+			os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Printf("failed    %d files: %v; retrying later\n", len(todo),
+				err)
+			return todo
+		}
+		defer index.Close()
+	}
+
+	for i, path := range todo {
+		ts := tss[i]
+		ts.Hashing = hashings[i]
+		var err error
+		if index != nil {
+			var buf []byte
+			rel, _ := filepath.Rel(dir, path)
+			buf, err = json.Marshal(watchIndexEntry{
+				File:      filepath.ToSlash(rel),
+				Timestamp: ts,
+			})
+			if err == nil {
+				_, err = index.Write(append(buf, '\n'))
+			}
+		} else {
+			err = writeTimestamp(path+".atum-timestamp", ts)
+		}
+		if err != nil {
+			fmt.Printf("failed    %s: %v\n", path, err)
+			continue
+		}
+		digests[path] = fileDigests[i]
+		fmt.Printf("stamped   %s  (%s)\n", path, ts.GetTime())
+	}
+	return nil
+}
+
+// Returns the files in the index written by `atum watch --index`.
+func readWatchIndex(dir, path string) (map[string]bool, error) {
+	ret := make(map[string]bool)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return ret, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<24) // timestamps can be large
+	for line := 1; scanner.Scan(); line++ {
+		var entry watchIndexEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		ret[filepath.Join(dir, filepath.FromSlash(entry.File))] = true
+	}
+	return ret, scanner.Err()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"golang.org/x/sys/unix"
+
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+)
+
+// The events we watch for: files written and closed, or moved into place,
+// and directories created.  Modifications postpone stamping a file until
+// it settles.
+const inotifyMask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_CREATE |
+	unix.IN_MODIFY
+
+// Watches a directory tree with inotify.
+type inotifyWatcher struct {
+	fd      int
+	filter  watchFilter
+	changed chan<- string
+	dirs    map[int]string // by watch descriptor
+	closed  int32
+}
+
+func newNotifyWatcher(dir string, filter watchFilter,
+	changed chan<- string) (watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify: %v", err)
+	}
+	w := &inotifyWatcher{
+		fd:      fd,
+		filter:  filter,
+		changed: changed,
+		dirs:    make(map[int]string),
+	}
+	if err = w.addTree(dir, false); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// Watches the directory and its subdirectories.  If report is set, sends
+// the files in them, which might have been created before we watched
+// the directory.
+func (w *inotifyWatcher) addTree(dir string, report bool) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo,
+		err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path != dir {
+				return nil // removed while walking
+			}
+			return err
+		}
+		if path != dir && !w.filter(path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			wd, err := unix.InotifyAddWatch(w.fd, path, inotifyMask)
+			if err != nil {
+				return fmt.Errorf("inotify: %s: %v", path, err)
+			}
+			w.dirs[wd] = path
+		} else if report && info.Mode().IsRegular() {
+			w.changed <- path
+		}
+		return nil
+	})
+}
+
+// Reads events until the watcher is closed.
+func (w *inotifyWatcher) run() {
+	defer unix.Close(w.fd)
+	var buf [64 * (unix.SizeofInotifyEvent + unix.NAME_MAX + 1)]byte
+	fds := []unix.PollFd{{Fd: int32(w.fd), Events: unix.POLLIN}}
+	for atomic.LoadInt32(&w.closed) == 0 {
+		// Wake up now and then to check whether we're closed.
+		if n, err := unix.Poll(fds, 500); n <= 0 || err != nil {
+			continue
+		}
+		n, err := unix.Read(w.fd, buf[:])
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "inotify: %v\n", err)
+			return
+		}
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameStart := off + unix.SizeofInotifyEvent
+			name := strings.TrimRight(
+				string(buf[nameStart:nameStart+int(ev.Len)]), "\x00")
+			off = nameStart + int(ev.Len)
+			w.handle(ev, name)
+		}
+	}
+}
+
+func (w *inotifyWatcher) handle(ev *unix.InotifyEvent, name string) {
+	if ev.Mask&unix.IN_Q_OVERFLOW != 0 {
+		fmt.Fprintln(os.Stderr,
+			"inotify: events were lost; changes might be missed")
+		return
+	}
+	dir, ok := w.dirs[int(ev.Wd)]
+	if !ok {
+		return
+	}
+	if ev.Mask&unix.IN_IGNORED != 0 {
+		delete(w.dirs, int(ev.Wd)) // the directory was removed
+		return
+	}
+	if name == "" {
+		return
+	}
+	path := filepath.Join(dir, name)
+	isDir := ev.Mask&unix.IN_ISDIR != 0
+	if !w.filter(path, isDir) {
+		return
+	}
+	if isDir {
+		if ev.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+			if err := w.addTree(path, true); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+		return
+	}
+	w.changed <- path
+}
+
+func (w *inotifyWatcher) Close() error {
+	atomic.StoreInt32(&w.closed, 1)
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// Filesystem notifications are only implemented for Linux (inotify);
+// elsewhere `atum watch` polls.
+func newNotifyWatcher(dir string, filter watchFilter,
+	changed chan<- string) (watcher, error) {
+	return nil, errNotifyUnsupported
+}
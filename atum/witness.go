@@ -0,0 +1,106 @@
+package main
+
+import (
+	"github.com/bwesterb/go-atum/witness"
+
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/ed25519"
+
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Loads the base64 encoded Ed25519 private key stored at path, generating
+// one if the file does not exist.
+func loadEd25519Key(path string) (ed25519.PrivateKey, error) {
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return generateEd25519Key(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sk, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(buf)))
+	if err != nil {
+		return nil, err
+	}
+	if len(sk) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("Not an Ed25519 private key")
+	}
+	return ed25519.PrivateKey(sk), nil
+}
+
+// Generates an Ed25519 private key and stores it base64 encoded at path.
+// Fails if the file already exists.
+func generateEd25519Key(path string) (ed25519.PrivateKey, error) {
+	_, sk, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err = file.Write([]byte(
+		base64.StdEncoding.EncodeToString(sk) + "\n")); err != nil {
+		return nil, err
+	}
+	return sk, file.Sync()
+}
+
+func cmdWitness(c *cli.Context) error {
+	if c.NArg() != 0 {
+		return cli.NewExitError("I don't expect arguments; only flags", exitUsage)
+	}
+	if !c.IsSet("key") {
+		return cli.NewExitError("Please specify --key", exitUsage)
+	}
+	if len(c.StringSlice("server")) == 0 {
+		return cli.NewExitError("Please specify at least one --server", exitUsage)
+	}
+
+	sk, err := loadEd25519Key(c.String("key"))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to load key %s: %v", c.String("key"), err), exitIO)
+	}
+
+	w, err := witness.New(witness.Config{
+		Servers:   c.StringSlice("server"),
+		Key:       sk,
+		StatePath: c.String("state"),
+		Interval:  c.Duration("interval"),
+	})
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf(
+			"Failed to create witness: %v", err), exitIO)
+	}
+
+	fmt.Printf("Witness public key: %s\n",
+		base64.StdEncoding.EncodeToString(w.PublicKey()))
+
+	if c.Bool("once") {
+		failed := false
+		for _, serverUrl := range c.StringSlice("server") {
+			if err := w.Witness(serverUrl); err != nil {
+				fmt.Printf("%s: %v\n", serverUrl, err)
+				failed = true
+			} else {
+				fmt.Printf("%s: cosigned\n", serverUrl)
+			}
+		}
+		if failed {
+			return cli.NewExitError("Failed to cosign some tree heads", exitServer)
+		}
+		return nil
+	}
+
+	w.Run(context.Background())
+	return nil
+}
@@ -0,0 +1,71 @@
+package atum
+
+import (
+	"github.com/bwesterb/go-atum/tlog"
+
+	"context"
+)
+
+// Request timestamps for the given nonces at once.
+//
+// For more flexibility, use SendBatchRequest().
+func StampBatch(serverUrl string, nonces [][]byte) ([]*Timestamp, Error) {
+	return SendBatchRequest(context.Background(), serverUrl, Request{},
+		nonces, RequestOptions{})
+}
+
+// Requests a single timestamp on the root of a Merkle tree over the given
+// nonces and returns a timestamp for each of them, which contains the proof
+// that its nonce is in the tree.  This requires only one request to the
+// server (and one proof of work).
+//
+// The other fields of req, such as SigAlgs, are used for the request.
+// If there is only one nonce, a plain timestamp is requested.
+func SendBatchRequest(ctx context.Context, serverUrl string, req Request,
+	nonces [][]byte, opts RequestOptions) ([]*Timestamp, Error) {
+	if len(nonces) == 0 {
+		return nil, nil
+	}
+	if len(nonces) == 1 {
+		req.Nonce = nonces[0]
+		ts, err := SendRequestContext(ctx, serverUrl, req, opts)
+		if err != nil {
+			return nil, err
+		}
+		return []*Timestamp{ts}, nil
+	}
+
+	leafs := make([][]byte, len(nonces))
+	for i, nonce := range nonces {
+		leafs[i] = tlog.LeafHash(nonce)
+	}
+	tree := tlog.NewTree(leafs)
+	req.Nonce = tree.RootHash()
+	ts, err := SendRequestContext(ctx, serverUrl, req, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*Timestamp, len(nonces))
+	for i := range nonces {
+		stamp := *ts
+		stamp.Batch = &BatchProof{
+			Index:          uint64(i),
+			Size:           uint64(len(nonces)),
+			InclusionProof: tree.InclusionProof(uint64(i)),
+		}
+		ret[i] = &stamp
+	}
+	return ret, nil
+}
+
+// Returns the root of the batch with the given nonce, which is the nonce
+// signed by the server.
+func (p *BatchProof) root(nonce []byte) ([]byte, Error) {
+	root, ok := tlog.RootFromInclusionProof(p.Index, p.Size,
+		tlog.LeafHash(nonce), p.InclusionProof)
+	if !ok {
+		return nil, errorf("Malformed batch inclusion proof")
+	}
+	return root, nil
+}
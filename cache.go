@@ -1,11 +1,15 @@
 package atum
 
 import (
+	"encoding/hex"
 	"fmt"
 	"log"
 	"os"
 	"os/user"
 	"path"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -43,20 +47,217 @@ type Cache interface {
 	GetServerInfo(serverUrl string) *ServerInfo
 }
 
+// A Cache that also stores the key histories, revoked public keys and
+// tree heads of the transparency logs of the servers, such as the default
+// cache.  Check whether a Cache supports this with a type assertion.  With
+// a Cache that doesn't, the key history is fetched for every verification,
+// and a public key that turns out to be revoked is trusted until the
+// cached check of the public key expires.
+type ExtendedCache interface {
+	Cache
+
+	// Caches the (verified) public key history of the server.
+	StoreKeyHistory(serverUrl string, history KeyHistory)
+
+	// Retrieves the cached public key history of the server, if available.
+	GetKeyHistory(serverUrl string) *KeyHistory
+
+	// Caches that the server does not publish a key history, until the
+	// given time.
+	StoreNoKeyHistory(serverUrl string, until time.Time)
+
+	// Returns until when the server is known not to publish a key history,
+	// if it is.
+	GetNoKeyHistory(serverUrl string) *time.Time
+
+	// Caches that the given public key of the server has been revoked.
+	StoreRevocation(serverUrl string, alg SignatureAlgorithm, pk []byte,
+		rev PublicKeyRevocation)
+
+	// Returns the revocation of the public key, if it is known to be revoked.
+	GetRevocation(serverUrl string, alg SignatureAlgorithm,
+		pk []byte) *PublicKeyRevocation
+
+	// Caches the latest (verified) tree head of the transparency log
+	// of the server.
+	StoreTreeHead(serverUrl string, head TreeHead)
+
+	// Retrieves the latest cached tree head of the server, if available.
+	GetTreeHead(serverUrl string) *TreeHead
+
+	// Forgets that the given public key is valid for the server.
+	PurgePublicKey(serverUrl string, alg SignatureAlgorithm, pk []byte)
+
+	// Forgets that any public key known to be revoked is valid.  Returns
+	// the number of public keys purged.
+	PurgeRevokedPublicKeys() int
+}
+
+// A Cache that can list and forget what it has cached, such as the default
+// cache.  Check whether a Cache supports this with a type assertion.
+type EnumerableCache interface {
+	Cache
+
+	// Returns everything cached, grouped by server.
+	Entries() []CacheEntry
+
+	// Forgets everything cached on the server, or on all servers if
+	// serverUrl is empty.  If expiredOnly is set, only forgets the public
+	// keys that are no longer to be trusted.  Returns the number of
+	// records purged.
+	Purge(serverUrl string, expiredOnly bool) int
+}
+
+// Everything cached on a single server.  See EnumerableCache.Entries().
+type CacheEntry struct {
+	ServerUrl   string
+	ServerInfo  *ServerInfo        `json:",omitempty"`
+	KeyHistory  *KeyHistory        `json:",omitempty"`
+	TreeHead    *TreeHead          `json:",omitempty"`
+	PublicKeys  []CachedPublicKey  `json:",omitempty"`
+	Revocations []CachedRevocation `json:",omitempty"`
+}
+
+// A public key cached as valid for a server.
+type CachedPublicKey struct {
+	Alg       SignatureAlgorithm
+	PublicKey []byte
+
+	// Until when the public key is to be trusted
+	Expires time.Time
+}
+
+// A public key of a server cached as revoked.
+type CachedRevocation struct {
+	Alg        SignatureAlgorithm
+	PublicKey  []byte
+	Revocation PublicKeyRevocation
+}
+
+// Returns the cache used by the Atum client.  See SetCache().
+func GetCache() Cache {
+	return cache
+}
+
+// Returns the cache used by the Atum client as an ExtendedCache.  If it
+// doesn't support that, what it lacks isn't cached.
+func extendedCache() ExtendedCache {
+	if c, ok := cache.(ExtendedCache); ok {
+		return c
+	}
+	return basicCache{cache}
+}
+
+// Turns a Cache into an ExtendedCache that doesn't store anything more.
+type basicCache struct {
+	Cache
+}
+
+func (basicCache) StoreKeyHistory(string, KeyHistory)                {}
+func (basicCache) GetKeyHistory(string) *KeyHistory                  { return nil }
+func (basicCache) StoreNoKeyHistory(string, time.Time)               {}
+func (basicCache) GetNoKeyHistory(string) *time.Time                 { return nil }
+func (basicCache) StoreTreeHead(string, TreeHead)                    {}
+func (basicCache) GetTreeHead(string) *TreeHead                      { return nil }
+func (basicCache) PurgePublicKey(string, SignatureAlgorithm, []byte) {}
+func (basicCache) PurgeRevokedPublicKeys() int                       { return 0 }
+
+func (basicCache) StoreRevocation(string, SignatureAlgorithm, []byte,
+	PublicKeyRevocation) {
+}
+
+func (basicCache) GetRevocation(string, SignatureAlgorithm,
+	[]byte) *PublicKeyRevocation {
+	return nil
+}
+
+// Stores the entries, for instance those returned by Entries() of another
+// cache, in the given cache.  Doesn't replace a tree head or key history by
+// an older one, nor shorten how long a public key is trusted.  Key
+// histories, tree heads and revocations are only stored if the cache is
+// an ExtendedCache.
+func ImportCacheEntries(c Cache, entries []CacheEntry) {
+	xc, ok := c.(ExtendedCache)
+	if !ok {
+		xc = basicCache{c}
+	}
+	for _, entry := range entries {
+		url := entry.ServerUrl
+		if entry.ServerInfo != nil {
+			c.StoreServerInfo(url, *entry.ServerInfo)
+		}
+		if entry.KeyHistory != nil {
+			cur := xc.GetKeyHistory(url)
+			if cur == nil || cur.Issued < entry.KeyHistory.Issued {
+				xc.StoreKeyHistory(url, *entry.KeyHistory)
+			}
+		}
+		if entry.TreeHead != nil {
+			cur := xc.GetTreeHead(url)
+			if cur == nil || cur.Size < entry.TreeHead.Size {
+				xc.StoreTreeHead(url, *entry.TreeHead)
+			}
+		}
+		for _, pk := range entry.PublicKeys {
+			cur := c.GetPublicKey(url, pk.Alg, pk.PublicKey)
+			if cur == nil || cur.Before(pk.Expires) {
+				c.StorePublicKey(url, pk.Alg, pk.PublicKey, pk.Expires)
+			}
+		}
+		for _, rev := range entry.Revocations {
+			xc.StoreRevocation(url, rev.Alg, rev.PublicKey, rev.Revocation)
+		}
+	}
+}
+
 func init() {
 	cache = &boltCache{}
 }
 
+// Returns a Cache stored in the bolt database at the given path, which is
+// created if it does not exist.  The default cache is stored in
+// ~/.cache/atum/cache.bolt.
+func NewBoltCache(path string) EnumerableCache {
+	return &boltCache{path: path}
+}
+
 type boltCache struct {
 	mux  sync.Mutex
 	db   *bolthold.Store
 	path string
 }
 
+// The marker that a server doesn't publish a key history, as stored in the
+// bolt cache.
+type noKeyHistory struct {
+	Until time.Time
+}
+
+// A revoked public key as stored in the bolt cache.
+type revokedPublicKey struct {
+	ServerUrl  string
+	Alg        SignatureAlgorithm
+	PublicKey  []byte
+	Revocation PublicKeyRevocation
+}
+
 func pkKey(serverUrl string, alg SignatureAlgorithm, pk []byte) string {
 	return fmt.Sprintf("%x-%s-%s", pk, alg, serverUrl)
 }
 
+// Inverse of pkKey().
+func parsePkKey(key string) (serverUrl string, alg SignatureAlgorithm,
+	pk []byte, err error) {
+	bits := strings.SplitN(key, "-", 3)
+	if len(bits) != 3 {
+		return "", "", nil, fmt.Errorf("malformed key %s", key)
+	}
+	if pk, err = hex.DecodeString(bits[0]); err != nil {
+		return "", "", nil, fmt.Errorf("malformed key %s: %v", key, err)
+	}
+	return bits[2], SignatureAlgorithm(bits[1]), pk, nil
+}
+
 func (cache *boltCache) exit() {
 	if cache.db != nil {
 		if err := cache.db.Close(); err != nil {
@@ -77,17 +278,24 @@ func (cache *boltCache) enter(write bool) bool {
 			return false
 		}
 
-		cacheDirPath := path.Join(usr.HomeDir, ".cache", "atum")
-		if _, err = os.Stat(cacheDirPath); os.IsNotExist(err) {
-			err = os.MkdirAll(cacheDirPath, 0700)
-			if err != nil {
-				log.Printf("atum cache: os.MkdirAll(%s): %v", cacheDirPath, err)
-				cache.mux.Unlock()
-				return false
-			}
+		cache.path = path.Join(usr.HomeDir, ".cache", "atum", "cache.bolt")
+	}
+
+	cacheDirPath := path.Dir(cache.path)
+	if _, err := os.Stat(cacheDirPath); os.IsNotExist(err) {
+		err = os.MkdirAll(cacheDirPath, 0700)
+		if err != nil {
+			log.Printf("atum cache: os.MkdirAll(%s): %v", cacheDirPath, err)
+			cache.mux.Unlock()
+			return false
 		}
+	}
 
-		cache.path = path.Join(cacheDirPath, "cache.bolt")
+	// Nothing is cached yet.  Don't create the database, which can't be
+	// done read-only.
+	if _, err := os.Stat(cache.path); !write && os.IsNotExist(err) {
+		cache.mux.Unlock()
+		return false
 	}
 
 	var err error
@@ -156,3 +364,331 @@ func (cache *boltCache) GetServerInfo(serverUrl string) *ServerInfo {
 	}
 	return &ret
 }
+
+func (cache *boltCache) StoreKeyHistory(serverUrl string, history KeyHistory) {
+	if !cache.enter(true) {
+		return
+	}
+	defer cache.exit()
+	if err := cache.db.Upsert(serverUrl, &history); err != nil {
+		log.Printf("atum cache: StoreKeyHistory(): %v", err)
+	}
+}
+
+func (cache *boltCache) GetKeyHistory(serverUrl string) *KeyHistory {
+	if !cache.enter(false) {
+		return nil
+	}
+	defer cache.exit()
+	var ret KeyHistory
+	if err := cache.db.Get(serverUrl, &ret); err != nil {
+		if err != bolthold.ErrNotFound {
+			log.Printf("atum cache: GetKeyHistory(): %v", err)
+		}
+		return nil
+	}
+	return &ret
+}
+
+func (cache *boltCache) StoreNoKeyHistory(serverUrl string, until time.Time) {
+	if !cache.enter(true) {
+		return
+	}
+	defer cache.exit()
+	if err := cache.db.Upsert(serverUrl, &noKeyHistory{until}); err != nil {
+		log.Printf("atum cache: StoreNoKeyHistory(): %v", err)
+	}
+}
+
+func (cache *boltCache) GetNoKeyHistory(serverUrl string) *time.Time {
+	if !cache.enter(false) {
+		return nil
+	}
+	defer cache.exit()
+	var ret noKeyHistory
+	if err := cache.db.Get(serverUrl, &ret); err != nil {
+		if err != bolthold.ErrNotFound {
+			log.Printf("atum cache: GetNoKeyHistory(): %v", err)
+		}
+		return nil
+	}
+	return &ret.Until
+}
+
+func (cache *boltCache) StoreTreeHead(serverUrl string, head TreeHead) {
+	if !cache.enter(true) {
+		return
+	}
+	defer cache.exit()
+	if err := cache.db.Upsert(serverUrl, &head); err != nil {
+		log.Printf("atum cache: StoreTreeHead(): %v", err)
+	}
+}
+
+func (cache *boltCache) GetTreeHead(serverUrl string) *TreeHead {
+	if !cache.enter(false) {
+		return nil
+	}
+	defer cache.exit()
+	var ret TreeHead
+	if err := cache.db.Get(serverUrl, &ret); err != nil {
+		if err != bolthold.ErrNotFound {
+			log.Printf("atum cache: GetTreeHead(): %v", err)
+		}
+		return nil
+	}
+	return &ret
+}
+
+func (cache *boltCache) StoreRevocation(serverUrl string,
+	alg SignatureAlgorithm, pk []byte, rev PublicKeyRevocation) {
+	if !cache.enter(true) {
+		return
+	}
+	defer cache.exit()
+	if err := cache.db.Upsert(pkKey(serverUrl, alg, pk), &revokedPublicKey{
+		ServerUrl:  serverUrl,
+		Alg:        alg,
+		PublicKey:  pk,
+		Revocation: rev,
+	}); err != nil {
+		log.Printf("atum cache: StoreRevocation(): %v", err)
+	}
+}
+
+func (cache *boltCache) GetRevocation(serverUrl string,
+	alg SignatureAlgorithm, pk []byte) *PublicKeyRevocation {
+	if !cache.enter(false) {
+		return nil
+	}
+	defer cache.exit()
+	var ret revokedPublicKey
+	if err := cache.db.Get(pkKey(serverUrl, alg, pk), &ret); err != nil {
+		if err != bolthold.ErrNotFound {
+			log.Printf("atum cache: GetRevocation(): %v", err)
+		}
+		return nil
+	}
+	return &ret.Revocation
+}
+
+func (cache *boltCache) PurgePublicKey(serverUrl string,
+	alg SignatureAlgorithm, pk []byte) {
+	if !cache.enter(true) {
+		return
+	}
+	defer cache.exit()
+	if err := cache.db.Delete(pkKey(serverUrl, alg, pk),
+		&time.Time{}); err != nil && err != bolthold.ErrNotFound {
+		log.Printf("atum cache: PurgePublicKey(): %v", err)
+	}
+}
+
+func (cache *boltCache) PurgeRevokedPublicKeys() int {
+	if !cache.enter(true) {
+		return 0
+	}
+	defer cache.exit()
+	var revoked []revokedPublicKey
+	if err := cache.db.Find(&revoked, nil); err != nil {
+		log.Printf("atum cache: PurgeRevokedPublicKeys(): %v", err)
+		return 0
+	}
+	ret := 0
+	for _, rpk := range revoked {
+		err := cache.db.Delete(pkKey(rpk.ServerUrl, rpk.Alg, rpk.PublicKey),
+			&time.Time{})
+		if err == nil {
+			ret++
+		} else if err != bolthold.ErrNotFound {
+			log.Printf("atum cache: PurgeRevokedPublicKeys(): %v", err)
+		}
+	}
+	return ret
+}
+
+// Returns the keys of the records of the given type.  bolthold doesn't
+// expose them, so we walk the bucket, which is named after the type.
+func (cache *boltCache) keys(dataType interface{}) ([]string, error) {
+	var ret []string
+	name := reflect.TypeOf(dataType).Elem().Name()
+	err := cache.db.Bolt().View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(name))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var key string
+			if err := bolthold.DefaultDecode(k, &key); err != nil {
+				return err
+			}
+			ret = append(ret, key)
+			return nil
+		})
+	})
+	return ret, err
+}
+
+func (cache *boltCache) Entries() []CacheEntry {
+	if !cache.enter(false) {
+		return nil
+	}
+	defer cache.exit()
+
+	entries := make(map[string]*CacheEntry)
+	entry := func(serverUrl string) *CacheEntry {
+		if ret, ok := entries[serverUrl]; ok {
+			return ret
+		}
+		entries[serverUrl] = &CacheEntry{ServerUrl: serverUrl}
+		return entries[serverUrl]
+	}
+
+	for _, dataType := range []interface{}{
+		&ServerInfo{}, &KeyHistory{}, &TreeHead{}} {
+		keys, err := cache.keys(dataType)
+		if err != nil {
+			log.Printf("atum cache: Entries(): %v", err)
+			return nil
+		}
+		for _, serverUrl := range keys {
+			var err error
+			switch dataType.(type) {
+			case *ServerInfo:
+				var info ServerInfo
+				err = cache.db.Get(serverUrl, &info)
+				entry(serverUrl).ServerInfo = &info
+			case *KeyHistory:
+				var history KeyHistory
+				err = cache.db.Get(serverUrl, &history)
+				entry(serverUrl).KeyHistory = &history
+			case *TreeHead:
+				var head TreeHead
+				err = cache.db.Get(serverUrl, &head)
+				entry(serverUrl).TreeHead = &head
+			}
+			if err != nil {
+				log.Printf("atum cache: Entries(): %v", err)
+				return nil
+			}
+		}
+	}
+
+	keys, err := cache.keys(&time.Time{})
+	if err != nil {
+		log.Printf("atum cache: Entries(): %v", err)
+		return nil
+	}
+	for _, key := range keys {
+		serverUrl, alg, pk, err := parsePkKey(key)
+		if err != nil {
+			log.Printf("atum cache: Entries(): %v", err)
+			continue
+		}
+		var expires time.Time
+		if err = cache.db.Get(key, &expires); err != nil {
+			log.Printf("atum cache: Entries(): %v", err)
+			return nil
+		}
+		e := entry(serverUrl)
+		e.PublicKeys = append(e.PublicKeys, CachedPublicKey{
+			Alg:       alg,
+			PublicKey: pk,
+			Expires:   expires,
+		})
+	}
+
+	var revoked []revokedPublicKey
+	if err := cache.db.Find(&revoked, nil); err != nil {
+		log.Printf("atum cache: Entries(): %v", err)
+		return nil
+	}
+	for _, rpk := range revoked {
+		e := entry(rpk.ServerUrl)
+		e.Revocations = append(e.Revocations, CachedRevocation{
+			Alg:        rpk.Alg,
+			PublicKey:  rpk.PublicKey,
+			Revocation: rpk.Revocation,
+		})
+	}
+
+	ret := make([]CacheEntry, 0, len(entries))
+	for _, e := range entries {
+		ret = append(ret, *e)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ServerUrl < ret[j].ServerUrl
+	})
+	return ret
+}
+
+func (cache *boltCache) Purge(serverUrl string, expiredOnly bool) int {
+	if !cache.enter(true) {
+		return 0
+	}
+	defer cache.exit()
+
+	ret := 0
+	del := func(key string, dataType interface{}) {
+		err := cache.db.Delete(key, dataType)
+		if err == nil {
+			ret++
+		} else if err != bolthold.ErrNotFound {
+			log.Printf("atum cache: Purge(): %v", err)
+		}
+	}
+
+	keys, err := cache.keys(&time.Time{})
+	if err != nil {
+		log.Printf("atum cache: Purge(): %v", err)
+		return 0
+	}
+	now := time.Now()
+	for _, key := range keys {
+		url, _, _, err := parsePkKey(key)
+		if serverUrl != "" && (err != nil || url != serverUrl) {
+			continue
+		}
+		if expiredOnly {
+			var expires time.Time
+			if err := cache.db.Get(key, &expires); err != nil {
+				log.Printf("atum cache: Purge(): %v", err)
+				continue
+			}
+			if expires.After(now) {
+				continue
+			}
+		}
+		del(key, &time.Time{})
+	}
+	if expiredOnly {
+		return ret
+	}
+
+	for _, dataType := range []interface{}{
+		&ServerInfo{}, &KeyHistory{}, &noKeyHistory{}, &TreeHead{}} {
+		keys, err := cache.keys(dataType)
+		if err != nil {
+			log.Printf("atum cache: Purge(): %v", err)
+			return ret
+		}
+		for _, url := range keys {
+			if serverUrl == "" || url == serverUrl {
+				del(url, dataType)
+			}
+		}
+	}
+
+	var revoked []revokedPublicKey
+	if err := cache.db.Find(&revoked, nil); err != nil {
+		log.Printf("atum cache: Purge(): %v", err)
+		return ret
+	}
+	for _, rpk := range revoked {
+		if serverUrl == "" || rpk.ServerUrl == serverUrl {
+			del(pkKey(rpk.ServerUrl, rpk.Alg, rpk.PublicKey),
+				&revokedPublicKey{})
+		}
+	}
+	return ret
+}
@@ -0,0 +1,142 @@
+package atum
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Server urls with dashes, which separate the parts of the keys of the
+// public keys in the bolt cache.
+const (
+	testUrl1 = "https://atum-1.example.com/"
+	testUrl2 = "https://example.com/atum-2/"
+)
+
+func newTestCache(t *testing.T) *boltCache {
+	return NewBoltCache(filepath.Join(t.TempDir(), "cache.bolt")).(*boltCache)
+}
+
+func TestPkKey(t *testing.T) {
+	pk := []byte{0, 1, 0x2d, 0xff}
+	for _, serverUrl := range []string{testUrl1, testUrl2, ""} {
+		for _, alg := range []SignatureAlgorithm{Ed25519, LMS} {
+			url2, alg2, pk2, err := parsePkKey(pkKey(serverUrl, alg, pk))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if url2 != serverUrl || alg2 != alg || !bytes.Equal(pk2, pk) {
+				t.Fatalf("%s %s %x became %s %s %x", serverUrl, alg, pk,
+					url2, alg2, pk2)
+			}
+		}
+	}
+	if _, _, _, err := parsePkKey("0102-ed25519"); err == nil {
+		t.Fatal("malformed key accepted")
+	}
+}
+
+func TestCacheEntries(t *testing.T) {
+	now := time.Now().Round(time.Second)
+	pk1, pk2, pk3 := []byte("pk1"), []byte("pk2"), []byte("pk3")
+	revocation := PublicKeyRevocation{RevokedAt: now, Reason: KeyRetired}
+
+	c := newTestCache(t)
+	c.StoreServerInfo(testUrl1, ServerInfo{MaxNonceSize: 64})
+	c.StoreKeyHistory(testUrl1, KeyHistory{Issued: 20})
+	c.StoreTreeHead(testUrl1, TreeHead{Size: 10})
+	c.StorePublicKey(testUrl1, Ed25519, pk1, now.Add(time.Hour))
+	c.StoreTreeHead(testUrl2, TreeHead{Size: 5})
+	c.StorePublicKey(testUrl2, LMS, pk2, now.Add(-time.Hour)) // expired
+	c.StoreRevocation(testUrl2, Ed25519, pk3, revocation)
+
+	entries := c.Entries()
+	if len(entries) != 2 || entries[0].ServerUrl != testUrl1 ||
+		entries[1].ServerUrl != testUrl2 {
+		t.Fatalf("wrong entries: %+v", entries)
+	}
+	e1, e2 := entries[0], entries[1]
+	if e1.ServerInfo == nil || e1.ServerInfo.MaxNonceSize != 64 ||
+		e1.KeyHistory == nil || e1.KeyHistory.Issued != 20 ||
+		e1.TreeHead == nil || e1.TreeHead.Size != 10 ||
+		len(e1.PublicKeys) != 1 || len(e1.Revocations) != 0 {
+		t.Fatalf("wrong entry for %s: %+v", testUrl1, e1)
+	}
+	if pk := e1.PublicKeys[0]; pk.Alg != Ed25519 ||
+		!bytes.Equal(pk.PublicKey, pk1) ||
+		!pk.Expires.Equal(now.Add(time.Hour)) {
+		t.Fatalf("wrong public key for %s: %+v", testUrl1, pk)
+	}
+	if e2.ServerInfo != nil || e2.KeyHistory != nil || e2.TreeHead == nil ||
+		len(e2.PublicKeys) != 1 || len(e2.Revocations) != 1 {
+		t.Fatalf("wrong entry for %s: %+v", testUrl2, e2)
+	}
+	if rev := e2.Revocations[0]; rev.Alg != Ed25519 ||
+		!bytes.Equal(rev.PublicKey, pk3) ||
+		rev.Revocation.Reason != KeyRetired {
+		t.Fatalf("wrong revocation for %s: %+v", testUrl2, rev)
+	}
+
+	// Import into a cache that has newer data on the first server and
+	// older data on the second.
+	c2 := newTestCache(t)
+	c2.StoreKeyHistory(testUrl1, KeyHistory{Issued: 30})
+	c2.StoreTreeHead(testUrl1, TreeHead{Size: 20})
+	c2.StorePublicKey(testUrl1, Ed25519, pk1, now.Add(2*time.Hour))
+	c2.StoreTreeHead(testUrl2, TreeHead{Size: 1})
+	ImportCacheEntries(c2, entries)
+
+	if history := c2.GetKeyHistory(testUrl1); history == nil ||
+		history.Issued != 30 {
+		t.Fatalf("key history replaced by older one: %+v", history)
+	}
+	if head := c2.GetTreeHead(testUrl1); head == nil || head.Size != 20 {
+		t.Fatalf("tree head replaced by older one: %+v", head)
+	}
+	if expires := c2.GetPublicKey(testUrl1, Ed25519, pk1); expires == nil ||
+		!expires.Equal(now.Add(2*time.Hour)) {
+		t.Fatalf("public key trusted shorter: %v", expires)
+	}
+	if info := c2.GetServerInfo(testUrl1); info == nil ||
+		info.MaxNonceSize != 64 {
+		t.Fatalf("server info not imported: %+v", info)
+	}
+	if head := c2.GetTreeHead(testUrl2); head == nil || head.Size != 5 {
+		t.Fatalf("older tree head not replaced: %+v", head)
+	}
+	if c2.GetPublicKey(testUrl2, LMS, pk2) == nil {
+		t.Fatal("public key not imported")
+	}
+	if rev := c2.GetRevocation(testUrl2, Ed25519, pk3); rev == nil ||
+		!rev.RevokedAt.Equal(now) {
+		t.Fatalf("revocation not imported: %+v", rev)
+	}
+
+	// Only the expired public key is purged first.
+	if n := c2.Purge("", true); n != 1 {
+		t.Fatalf("purged %d expired records instead of 1", n)
+	}
+	if c2.GetPublicKey(testUrl2, LMS, pk2) != nil {
+		t.Fatal("expired public key not purged")
+	}
+	if c2.GetPublicKey(testUrl1, Ed25519, pk1) == nil ||
+		c2.GetRevocation(testUrl2, Ed25519, pk3) == nil {
+		t.Fatal("valid public key or revocation purged")
+	}
+
+	// Server info, key history, tree head and public key.
+	if n := c2.Purge(testUrl1, false); n != 4 {
+		t.Fatalf("purged %d records of %s instead of 4", n, testUrl1)
+	}
+	entries = c2.Entries()
+	if len(entries) != 1 || entries[0].ServerUrl != testUrl2 {
+		t.Fatalf("wrong entries after purge: %+v", entries)
+	}
+	if n := c2.Purge("", false); n != 2 {
+		t.Fatalf("purged %d records instead of 2", n)
+	}
+	if entries = c2.Entries(); len(entries) != 0 {
+		t.Fatalf("entries left after purge: %+v", entries)
+	}
+}
@@ -1,11 +1,14 @@
 package atum
 
 import (
+	"github.com/bwesterb/go-atum/lms"
+	"github.com/bwesterb/go-pow"
 	"github.com/bwesterb/go-xmssmt" // imported as xmssmt
 	"golang.org/x/crypto/ed25519"
 	"golang.org/x/crypto/sha3"
 
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
@@ -41,13 +44,27 @@ func Stamp(serverUrl string, nonce []byte) (*Timestamp, Error) {
 	return SendRequest(serverUrl, Request{Nonce: nonce})
 }
 
+// Options for SendRequestContext().  The zero value is a sensible default.
+type RequestOptions struct {
+	// Options for solving the proof of work, if the server requires one.
+	ProofOfWork PowOptions
+}
+
 // Request a timestamp.
 //
 // For a simpler interface, use Stamp() or JsonStamp().
 func SendRequest(serverUrl string, req Request) (*Timestamp, Error) {
+	return SendRequestContext(context.Background(), serverUrl, req,
+		RequestOptions{})
+}
+
+// Like SendRequest(), but can be cancelled using the context (which
+// also cancels the proof of work) and accepts options.
+func SendRequestContext(ctx context.Context, serverUrl string, req Request,
+	opts RequestOptions) (*Timestamp, Error) {
 	firstTry := true
 	for {
-		retry, ts, err := sendRequest(serverUrl, req)
+		retry, ts, err := sendRequest(ctx, serverUrl, req, opts)
 		if firstTry && retry {
 			firstTry = false
 			continue
@@ -57,7 +74,8 @@ func SendRequest(serverUrl string, req Request) (*Timestamp, Error) {
 }
 
 // Actually request the timestamp.
-func sendRequest(serverUrl string, req Request) (bool, *Timestamp, Error) {
+func sendRequest(ctx context.Context, serverUrl string, req Request,
+	opts RequestOptions) (bool, *Timestamp, Error) {
 	if !strings.HasSuffix(serverUrl, "/") {
 		serverUrl += "/"
 	}
@@ -65,20 +83,21 @@ func sendRequest(serverUrl string, req Request) (bool, *Timestamp, Error) {
 	info := cache.GetServerInfo(serverUrl)
 
 	if info != nil {
-		alg := info.DefaultSigAlg
-		if req.PreferredSigAlg != nil {
-			alg = *req.PreferredSigAlg
-		}
-
-		// Add proof of work, if required
-		powReq, ok := info.RequiredProofOfWork[alg]
+		// Add proof of work, if required.  For a hybrid timestamp, we
+		// fulfil the hardest proof of work required for any of the
+		// requested signature algorithms.
+		powReq, ok := req.requiredProofOfWork(info)
 		if ok {
 			if req.Time == nil {
 				now := time.Now().Unix()
 				req.Time = &now
 			}
-			pow := powReq.Fulfil(EncodeTimeNonce(*req.Time, req.Nonce))
-			req.ProofOfWork = &pow
+			proof, err := SolveProofOfWork(ctx, powReq,
+				EncodeTimeNonce(*req.Time, req.Nonce), opts.ProofOfWork)
+			if err != nil {
+				return false, nil, err
+			}
+			req.ProofOfWork = proof
 		}
 	}
 
@@ -87,7 +106,13 @@ func sendRequest(serverUrl string, req Request) (bool, *Timestamp, Error) {
 		return false, nil, wrapErrorf(err, "Failed to convert request to JSON")
 	}
 
-	httpResp, err := http.Post(serverUrl, "application/json", bytes.NewReader(reqBuf))
+	httpReq, err := http.NewRequest(http.MethodPost, serverUrl,
+		bytes.NewReader(reqBuf))
+	if err != nil {
+		return false, nil, wrapErrorf(err, "http.NewRequest()")
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpResp, err := http.DefaultClient.Do(httpReq.WithContext(ctx))
 	if err != nil {
 		return false, nil, wrapErrorf(err, "Failed POST request to %s", serverUrl)
 	}
@@ -112,15 +137,50 @@ func sendRequest(serverUrl string, req Request) (bool, *Timestamp, Error) {
 			// Something went wrong with the proof of work.  Probably we're
 			// missing the right nonce.
 			cache.StoreServerInfo(serverUrl, *resp.Info)
-			return true, nil, errorf("Server reported error: %s", *resp.Error)
+			return true, nil, &ServerError{*resp.Error}
 		default:
-			return false, nil, errorf("Server reported error: %s", *resp.Error)
+			return false, nil, &ServerError{*resp.Error}
 		}
 	}
 
+	if resp.LogIndex != nil && resp.Stamp != nil {
+		logProof, err := fetchLogProof(serverUrl, resp.Stamp, req.Nonce,
+			*resp.LogIndex, 5)
+		if err != nil {
+			return false, nil, err
+		}
+		resp.Stamp.Log = logProof
+	}
+
 	return false, resp.Stamp, nil
 }
 
+// Returns the signature algorithms the request asks for, given the
+// server information.
+func (req *Request) sigAlgs(info *ServerInfo) []SignatureAlgorithm {
+	if len(req.SigAlgs) != 0 {
+		return req.SigAlgs
+	}
+	if req.PreferredSigAlg != nil {
+		return []SignatureAlgorithm{*req.PreferredSigAlg}
+	}
+	return []SignatureAlgorithm{info.DefaultSigAlg}
+}
+
+// Returns the hardest proof of work required for the signature algorithms
+// requested, if any.
+func (req *Request) requiredProofOfWork(info *ServerInfo) (
+	ret pow.Request, ok bool) {
+	for _, alg := range req.sigAlgs(info) {
+		powReq, ok2 := info.RequiredProofOfWork[alg]
+		if ok2 && (!ok || powReq.Difficulty > ret.Difficulty) {
+			ret = powReq
+			ok = true
+		}
+	}
+	return
+}
+
 // Computes the nonce associated to a message, when hashing is enabled.
 func (h *Hashing) ComputeNonce(msg io.Reader) ([]byte, Error) {
 	switch h.Hash {
@@ -141,6 +201,15 @@ func (h *Hashing) ComputeNonce(msg io.Reader) ([]byte, Error) {
 
 // Like Verify(), but reads the message from an io.Reader.
 func (ts *Timestamp) VerifyFrom(r io.Reader) (valid bool, err Error) {
+	return ts.VerifyFromWithPolicy(r, VerificationPolicy{})
+}
+
+// Like VerifyFrom(), but with a custom verification policy.
+//
+// An incomplete proof of inclusion in the transparency log (see
+// LogProof.Complete()) is completed, if the server can by now.
+func (ts *Timestamp) VerifyFromWithPolicy(r io.Reader,
+	policy VerificationPolicy) (valid bool, err Error) {
 	var nonce []byte
 
 	// Get the nonce, by hashing possibly
@@ -157,53 +226,246 @@ func (ts *Timestamp) VerifyFrom(r io.Reader) (valid bool, err Error) {
 		}
 	}
 
-	pkOk, err := ts.VerifyPublicKey()
+	// The server signed the root of the batch, if any.
+	if ts.Batch != nil {
+		nonce, err = ts.Batch.root(nonce)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if ts.Log != nil && !ts.Log.Complete() {
+		// The server had not signed a tree head including the timestamp
+		// when it was set.  Perhaps it has by now.
+		proof, err2 := fetchLogProof(ts.ServerUrl, ts, nonce, ts.Log.Index, 1)
+		if err2 != nil && policy.RequireLogProof {
+			return false, err2
+		}
+		if err2 == nil {
+			ts.Log = proof
+		}
+	}
+
+	if ts.Log != nil && ts.Log.Complete() {
+		valid, err = ts.verifyLogProof(nonce)
+		if err != nil || !valid {
+			return false, err
+		}
+	} else if policy.RequireLogProof {
+		return false, errorf(
+			"Timestamp lacks a proof of inclusion in the transparency log")
+	}
+
+	if policy.MinCosignatures > 0 {
+		valid, err = ts.verifyWitnessed(policy)
+		if err != nil || !valid {
+			return false, err
+		}
+	}
+
+	switch policy.Signatures {
+	case AllSignatures:
+		for _, sig := range ts.Signatures() {
+			valid, err = ts.verifySignature(sig, nonce)
+			if err != nil || !valid {
+				return false, err
+			}
+		}
+		if alg, ok := ts.missingSigAlg(policy, nil); !ok {
+			return false, errorf("Timestamp lacks a %s signature", alg)
+		}
+		return true, nil
+	case AnySignature:
+		// Report why each signature failed, if none is valid.
+		var errs []string
+		validAlgs := make(map[SignatureAlgorithm]bool)
+		for _, sig := range ts.Signatures() {
+			valid, err = ts.verifySignature(sig, nonce)
+			if err == nil && valid {
+				validAlgs[sig.Alg] = true
+				if len(policy.SigAlgs) == 0 {
+					return true, nil
+				}
+			}
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", sig.Alg, err))
+			}
+		}
+		if len(validAlgs) != 0 {
+			if alg, ok := ts.missingSigAlg(policy, validAlgs); !ok {
+				return false, errorf("Timestamp lacks a valid %s signature",
+					alg)
+			}
+			return true, nil
+		}
+		if len(errs) != 0 {
+			return false, errorf("None of the signatures is valid: %s",
+				strings.Join(errs, "; "))
+		}
+		return false, nil
+	default:
+		return false, errorf("Unknown signature policy %d", policy.Signatures)
+	}
+}
+
+// Checks whether the timestamp carries a signature of each of the
+// algorithms required by the policy; if validAlgs is set, only the
+// algorithms in it count.  Returns the first missing algorithm, if any.
+func (ts *Timestamp) missingSigAlg(policy VerificationPolicy,
+	validAlgs map[SignatureAlgorithm]bool) (SignatureAlgorithm, bool) {
+	if validAlgs == nil {
+		validAlgs = make(map[SignatureAlgorithm]bool)
+		for _, sig := range ts.Signatures() {
+			validAlgs[sig.Alg] = true
+		}
+	}
+	for _, alg := range policy.SigAlgs {
+		if !validAlgs[alg] {
+			return alg, false
+		}
+	}
+	return "", true
+}
+
+// Checks both the public key and the signature on the nonce.
+func (ts *Timestamp) verifySignature(sig Signature, nonce []byte) (
+	valid bool, err Error) {
+	pkOk, err := ts.verifyPublicKeyOf(sig)
 	if err != nil || !pkOk {
 		return false, err
 	}
 
-	return ts.Sig.DangerousVerifySignatureButNotPublicKey(ts.Time, nonce)
+	return sig.DangerousVerifySignatureButNotPublicKey(ts.Time, nonce)
 }
 
-// Asks the Atum server if the public key on the signature should be trusted
+// Asks the Atum server if the public key on the signature should be trusted.
+//
+// For hybrid timestamps, this only checks the public key of the first
+// signature.  Verify() checks all of them.
 func (ts *Timestamp) VerifyPublicKey() (trusted bool, err Error) {
-	serverUrl := ts.ServerUrl
+	return ts.verifyPublicKeyOf(ts.Sig)
+}
+
+// Checks whether the public key on the given signature should be trusted,
+// using the key history of the Atum server, if published, and by asking
+// the Atum server otherwise.
+func (ts *Timestamp) verifyPublicKeyOf(sig Signature) (trusted bool, err Error) {
+	return verifyServerKey(ts.ServerUrl, sig, ts.Time)
+}
+
+// Checks whether the public key on the given signature, set at the given
+// time, belongs to the Atum server.
+func verifyServerKey(serverUrl string, sig Signature, at int64) (
+	trusted bool, err Error) {
 	if !strings.HasSuffix(serverUrl, "/") {
 		serverUrl += "/"
 	}
-	expires := cache.GetPublicKey(serverUrl, ts.Sig.Alg, ts.Sig.PublicKey)
-	if expires != nil && expires.Sub(time.Now()).Seconds() > 0 {
+
+	// A retired key might later turn out to be compromised, so we only
+	// rely on the cached revocation if it rejects the timestamp.
+	rev := extendedCache().GetRevocation(serverUrl, sig.Alg, sig.PublicKey)
+	if rev != nil && !rev.Allows(at) {
+		return checkRevocation(rev, at)
+	}
+
+	// If the key history can't be fetched, for instance because we're
+	// offline, we fall back to checking the public key by itself, which
+	// might well be cached.
+	history, _ := FetchKeyHistory(serverUrl)
+	if history != nil {
+		entry := history.Find(sig.Alg, sig.PublicKey)
+		if entry != nil {
+			if rev := entry.Revocation(); rev != nil {
+				return checkRevocation(rev, at)
+			}
+			if !entry.ValidAt(at) {
+				return false, errorf(
+					"Signature was not set within validity period of public key")
+			}
+			return true, nil
+		}
+	}
+
+	return checkPublicKey(serverUrl, sig.Alg, sig.PublicKey, at)
+}
+
+// Returns whether a timestamp set at the given time with a revoked
+// public key should be trusted.
+func checkRevocation(rev *PublicKeyRevocation, at int64) (bool, Error) {
+	if rev.Allows(at) {
 		return true, nil
 	}
-	q := url.Values{}
-	q.Set("alg", string(ts.Sig.Alg))
-	q.Set("pk", hex.EncodeToString(ts.Sig.PublicKey))
-	resp, err2 := http.Get(fmt.Sprintf("%scheckPublicKey?%s",
-		serverUrl, q.Encode()))
-	if err2 != nil {
-		return false, wrapErrorf(err2, "http.Get()")
+	return false, errorf("Public key was revoked at %v: %s",
+		rev.RevokedAt, rev.Reason)
+}
+
+// Caches that the public key was revoked and stops trusting it.
+func storeRevocation(serverUrl string, alg SignatureAlgorithm, pk []byte,
+	rev *PublicKeyRevocation) {
+	extendedCache().StoreRevocation(serverUrl, alg, pk, *rev)
+	extendedCache().PurgePublicKey(serverUrl, alg, pk)
+}
+
+// Asks the Atum server if the public key should be trusted for
+// a signature set at the given time.
+func checkPublicKey(serverUrl string, alg SignatureAlgorithm, pk []byte,
+	at int64) (trusted bool, err Error) {
+	if !strings.HasSuffix(serverUrl, "/") {
+		serverUrl += "/"
 	}
-	defer resp.Body.Close()
-	buf, err2 := ioutil.ReadAll(resp.Body)
-	if err2 != nil {
-		return false, wrapErrorf(err2, "ioutil.ReadAll()")
+	expires := cache.GetPublicKey(serverUrl, alg, pk)
+	if expires != nil && expires.Sub(time.Now()).Seconds() > 0 {
+		return true, nil
 	}
-	var pkResp PublicKeyCheckResponse
-	err2 = json.Unmarshal(buf, &pkResp)
-	if err2 != nil {
-		return false, wrapErrorf(err2, "json.Unmarshal()")
+	pkResp, err := CheckPublicKey(serverUrl, alg, pk)
+	if err != nil {
+		return false, err
+	}
+	if rev := pkResp.Revocation(); rev != nil {
+		return checkRevocation(rev, at)
 	}
-	if pkResp.Expires.Sub(time.Unix(ts.Time, 0)).Seconds() < 0 {
+	if pkResp.Expires.Sub(time.Unix(at, 0)).Seconds() < 0 {
 		return false, errorf("Public key expired")
 	}
 	if !pkResp.Trusted {
 		return false, nil
 	}
-	cache.StorePublicKey(serverUrl, ts.Sig.Alg,
-		ts.Sig.PublicKey, pkResp.Expires)
+	cache.StorePublicKey(serverUrl, alg, pk, pkResp.Expires)
 	return true, nil
 }
 
+// Asks the Atum server whether the public key should be trusted, without
+// looking at the cache.  If the server says the key is revoked, the
+// revocation is cached.
+func CheckPublicKey(serverUrl string, alg SignatureAlgorithm, pk []byte) (
+	*PublicKeyCheckResponse, Error) {
+	if !strings.HasSuffix(serverUrl, "/") {
+		serverUrl += "/"
+	}
+	q := url.Values{}
+	q.Set("alg", string(alg))
+	q.Set("pk", hex.EncodeToString(pk))
+	resp, err := http.Get(fmt.Sprintf("%scheckPublicKey?%s",
+		serverUrl, q.Encode()))
+	if err != nil {
+		return nil, wrapErrorf(err, "http.Get()")
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, wrapErrorf(err, "ioutil.ReadAll()")
+	}
+	var pkResp PublicKeyCheckResponse
+	err = json.Unmarshal(buf, &pkResp)
+	if err != nil {
+		return nil, wrapErrorf(err, "json.Unmarshal()")
+	}
+	if rev := pkResp.Revocation(); rev != nil {
+		storeRevocation(serverUrl, alg, pk, rev)
+	}
+	return &pkResp, nil
+}
+
 // Verifies the timestamp.
 //
 // NOTE anyone can create a "valid" Atum timestamp by setting up their own
@@ -219,8 +481,11 @@ func (ts *Timestamp) Verify(msgOrNonce []byte) (valid bool, err Error) {
 // should be trusted.
 func (sig *Signature) DangerousVerifySignatureButNotPublicKey(
 	time int64, nonce []byte) (valid bool, err Error) {
-	msg := EncodeTimeNonce(time, nonce)
+	return sig.verifyMessage(EncodeTimeNonce(time, nonce))
+}
 
+// Verifies the signature on the given message, but not the public key.
+func (sig *Signature) verifyMessage(msg []byte) (valid bool, err Error) {
 	switch sig.Alg {
 	case Ed25519:
 		return ed25519.Verify(ed25519.PublicKey(sig.PublicKey),
@@ -231,6 +496,12 @@ func (sig *Signature) DangerousVerifySignatureButNotPublicKey(
 			return valid, wrapErrorf(err2, "xmssmt.Verify")
 		}
 		return valid, nil
+	case LMS:
+		valid, err2 := lms.Verify(sig.PublicKey, sig.Data, msg)
+		if err2 != nil {
+			return valid, wrapErrorf(err2, "lms.Verify")
+		}
+		return valid, nil
 	default:
 		return false, errorf("Signature algorithm %s not supported", sig.Alg)
 	}
@@ -249,6 +520,13 @@ func (sig Signature) String() string {
 		}
 		return fmt.Sprintf("%s signature by %s", &xsig,
 			base64.StdEncoding.EncodeToString(sig.PublicKey))
+	case LMS:
+		info, err := lms.ParseSignature(sig.Data)
+		if err != nil {
+			return fmt.Sprintf("Corrupted LMS signature: %v", err)
+		}
+		return fmt.Sprintf("%s signature by %s", info,
+			base64.StdEncoding.EncodeToString(sig.PublicKey))
 	default:
 		return "Unknown signature type"
 	}
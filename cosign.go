@@ -0,0 +1,102 @@
+package atum
+
+import (
+	"github.com/bwesterb/go-atum/tlog"
+	"golang.org/x/crypto/ed25519"
+
+	"bytes"
+	"strings"
+)
+
+// Returns the number of distinct witnesses among the given Ed25519 public
+// keys with a valid cosignature on the tree head of the given server.
+func (th *TreeHead) CountCosignatures(serverUrl string,
+	witnesses [][]byte) int {
+	ret := 0
+	for _, witness := range witnesses {
+		if len(witness) != ed25519.PublicKeySize {
+			continue
+		}
+		for _, cosig := range th.Cosignatures {
+			if !bytes.Equal(cosig.PublicKey, witness) {
+				continue
+			}
+			if ed25519.Verify(ed25519.PublicKey(witness),
+				th.CosignedMessage(serverUrl, cosig.Time), cosig.Sig) {
+				ret++
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// Fetches the latest tree head of the transparency log of the Atum server
+// that was cosigned by witnesses, and checks the signature of the server.
+// The cosignatures themselves are not checked.  See CountCosignatures().
+//
+// Returns nil (and no error) if the server has no cosigned tree head.
+func FetchCosignedTreeHead(serverUrl string) (*TreeHead, Error) {
+	if !strings.HasSuffix(serverUrl, "/") {
+		serverUrl += "/"
+	}
+	head := new(TreeHead)
+	found, err := getJson(serverUrl+"log/cosignedTreeHead", head)
+	if err != nil || !found {
+		return nil, err
+	}
+	if err = head.Verify(serverUrl); err != nil {
+		return nil, err
+	}
+	return head, nil
+}
+
+// Checks that the timestamp is included in a tree head cosigned by enough
+// of the witnesses of the policy.
+func (ts *Timestamp) verifyWitnessed(policy VerificationPolicy) (
+	valid bool, err Error) {
+	if ts.Log == nil || !ts.Log.Complete() {
+		return false, errorf(
+			"Timestamp lacks a proof of inclusion in the transparency log")
+	}
+	head := &ts.Log.TreeHead
+	if head.CountCosignatures(ts.ServerUrl,
+		policy.Witnesses) >= policy.MinCosignatures {
+		return true, nil
+	}
+
+	cosigned, err := FetchCosignedTreeHead(ts.ServerUrl)
+	if err != nil {
+		return false, err
+	}
+	if cosigned == nil {
+		return false, errorf("Server has no cosigned tree head")
+	}
+	n := cosigned.CountCosignatures(ts.ServerUrl, policy.Witnesses)
+	if n < policy.MinCosignatures {
+		return false, errorf(
+			"Tree head has only %d of the required %d cosignatures",
+			n, policy.MinCosignatures)
+	}
+	if cosigned.Size <= ts.Log.Index {
+		return false, errorf(
+			"Timestamp is not yet included in a cosigned tree head")
+	}
+
+	// The entry of the timestamp is in the cosigned tree if the smaller
+	// of the two trees is a prefix of the larger one.
+	older, newer := head, cosigned
+	if cosigned.Size < head.Size {
+		older, newer = cosigned, head
+	}
+	proof, err := FetchConsistencyProof(ts.ServerUrl, older.Size, newer.Size)
+	if err != nil {
+		return false, err
+	}
+	if !tlog.VerifyConsistency(older.Size, newer.Size, older.RootHash,
+		newer.RootHash, proof) {
+		return false, errorf(
+			"Cosigned tree head is inconsistent with tree head of timestamp")
+	}
+	return true, nil
+}
@@ -23,6 +23,17 @@ func (err *errorImpl) Error() string {
 	return err.msg
 }
 
+// Returned by SendRequest() when the Atum server reports an error.
+type ServerError struct {
+	Code ErrorCode
+}
+
+func (err *ServerError) Inner() error { return nil }
+
+func (err *ServerError) Error() string {
+	return fmt.Sprintf("Server reported error: %s", err.Code)
+}
+
 // Formats a new Error
 func errorf(format string, a ...interface{}) *errorImpl {
 	return &errorImpl{msg: fmt.Sprintf(format, a...)}
@@ -1,6 +1,7 @@
 module github.com/bwesterb/go-atum
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/bwesterb/go-pow v1.0.0
 	github.com/bwesterb/go-xmssmt v1.5.2
 	github.com/dustin/go-humanize v1.0.0
@@ -8,7 +9,7 @@ require (
 	github.com/urfave/cli v1.20.0
 	go.etcd.io/bbolt v1.3.6
 	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d
-	golang.org/x/sys v0.0.0-20220704084225-05e143d24a9e // indirect
+	golang.org/x/sys v0.0.0-20220704084225-05e143d24a9e
 )
 
 go 1.13
@@ -0,0 +1,75 @@
+package atum_test
+
+import (
+	"github.com/bwesterb/go-atum"
+	"github.com/bwesterb/go-atum/server"
+	"github.com/bwesterb/go-atum/stamper"
+
+	"bytes"
+	"testing"
+)
+
+// Removes the extra signatures from the hybrid timestamp, together with the
+// proof of inclusion in the transparency log, which covers them.
+func strip(ts *atum.Timestamp) *atum.Timestamp {
+	ret := *ts
+	ret.ExtraSigs = nil
+	ret.Log = nil
+	return &ret
+}
+
+func TestHybridStripped(t *testing.T) {
+	url := startServer(t, server.Config{
+		Signers: []stamper.Signer{newEd25519Signer(t), newLMSSigner(t)},
+	})
+
+	nonce := []byte("some nonce")
+	ts, err := atum.SendRequest(url, atum.Request{
+		Nonce:   nonce,
+		SigAlgs: []atum.SignatureAlgorithm{atum.Ed25519, atum.LMS},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts.ExtraSigs) != 1 {
+		t.Fatalf("expected one extra signature, got %d", len(ts.ExtraSigs))
+	}
+
+	required := []atum.SignatureAlgorithm{atum.Ed25519, atum.LMS}
+	for _, sigs := range []atum.SignaturePolicy{
+		atum.AllSignatures, atum.AnySignature} {
+		policy := atum.VerificationPolicy{Signatures: sigs, SigAlgs: required}
+		valid, err := ts.VerifyFromWithPolicy(bytes.NewReader(nonce), policy)
+		if err != nil || !valid {
+			t.Fatalf("policy %d: hybrid timestamp rejected: %v", sigs, err)
+		}
+
+		stripped := strip(ts)
+		valid, err = stripped.VerifyFromWithPolicy(bytes.NewReader(nonce),
+			policy)
+		if err == nil || valid {
+			t.Fatalf("policy %d: stripped timestamp accepted", sigs)
+		}
+
+		// A corrupt signature doesn't count as present either.
+		corrupt := strip(ts)
+		corrupt.ExtraSigs = []atum.Signature{ts.ExtraSigs[0]}
+		corrupt.ExtraSigs[0].Data = append([]byte{},
+			ts.ExtraSigs[0].Data...)
+		corrupt.ExtraSigs[0].Data[len(corrupt.ExtraSigs[0].Data)-1] ^= 1
+		valid, _ = corrupt.VerifyFromWithPolicy(bytes.NewReader(nonce),
+			policy)
+		if valid {
+			t.Fatalf("policy %d: timestamp with corrupt signature accepted",
+				sigs)
+		}
+	}
+
+	// Without required algorithms, the stripped timestamp is just an
+	// Ed25519 timestamp.
+	stripped := strip(ts)
+	valid, err := stripped.Verify(nonce)
+	if err != nil || !valid {
+		t.Fatalf("stripped timestamp rejected by default policy: %v", err)
+	}
+}
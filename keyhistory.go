@@ -0,0 +1,91 @@
+package atum
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// How long we remember that a server does not publish its key history.
+const noKeyHistoryCacheDuration = 24 * time.Hour
+
+// Fetches the public key history of the Atum server and checks its
+// signature.  The history is cached until it expires.
+//
+// Returns nil (and no error) if the server does not publish its key history.
+// That is cached too.
+func FetchKeyHistory(serverUrl string) (*KeyHistory, Error) {
+	if !strings.HasSuffix(serverUrl, "/") {
+		serverUrl += "/"
+	}
+
+	xc := extendedCache()
+	history := xc.GetKeyHistory(serverUrl)
+	if history != nil && history.Expires > time.Now().Unix() {
+		return history, nil
+	}
+	if until := xc.GetNoKeyHistory(serverUrl); until != nil &&
+		until.After(time.Now()) {
+		return nil, nil
+	}
+
+	resp, err := http.Get(serverUrl + "keyHistory")
+	if err != nil {
+		return nil, wrapErrorf(err, "http.Get()")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		xc.StoreNoKeyHistory(serverUrl,
+			time.Now().Add(noKeyHistoryCacheDuration))
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errorf("Failed to fetch key history: %s", resp.Status)
+	}
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, wrapErrorf(err, "ioutil.ReadAll()")
+	}
+	history = new(KeyHistory)
+	if err = json.Unmarshal(buf, history); err != nil {
+		return nil, wrapErrorf(err, "json.Unmarshal()")
+	}
+	if err := history.verify(serverUrl); err != nil {
+		return nil, err
+	}
+
+	xc.StoreKeyHistory(serverUrl, *history)
+	for _, entry := range history.Keys {
+		if rev := entry.Revocation(); rev != nil {
+			storeRevocation(serverUrl, entry.Alg, entry.PublicKey, rev)
+		}
+	}
+	return history, nil
+}
+
+// Checks that the key history is signed by a key of the server which is
+// listed in the history itself.
+func (h *KeyHistory) verify(serverUrl string) Error {
+	entry := h.Find(h.Sig.Alg, h.Sig.PublicKey)
+	if entry == nil || !entry.ValidAt(h.Issued) {
+		return errorf("Key history is not signed by one of its current keys")
+	}
+	valid, err := h.Sig.verifyMessage(h.SignedMessage())
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return errorf("Key history has an invalid signature")
+	}
+	trusted, err := checkPublicKey(serverUrl, h.Sig.Alg, h.Sig.PublicKey,
+		h.Issued)
+	if err != nil {
+		return err
+	}
+	if !trusted {
+		return errorf("Key history is signed by an untrusted public key")
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package atum_test
+
+import (
+	"github.com/bwesterb/go-atum"
+	"github.com/bwesterb/go-atum/server"
+
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Serves an Atum server, but answers requests for the key history with
+// the given status, if set, and counts them.
+type keyHistoryHandler struct {
+	srv      *server.Server
+	status   int
+	requests int32 // atomic
+}
+
+func (h *keyHistoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/keyHistory") {
+		atomic.AddInt32(&h.requests, 1)
+		if h.status != 0 {
+			http.Error(w, http.StatusText(h.status), h.status)
+			return
+		}
+	}
+	h.srv.ServeHTTP(w, r)
+}
+
+func TestKeyHistoryOffline(t *testing.T) {
+	for _, status := range []int{0, http.StatusNotFound,
+		http.StatusInternalServerError} {
+		h := &keyHistoryHandler{
+			srv:    newServer(t, server.Config{}),
+			status: status,
+		}
+		url, ts := serve(t, h)
+		nonce := []byte("some nonce")
+		stamp, err := atum.SendRequest(url, atum.Request{Nonce: nonce})
+		if err != nil {
+			t.Fatal(err)
+		}
+		atomic.StoreInt32(&h.requests, 0)
+
+		for i := 0; i < 2; i++ {
+			valid, err := stamp.Verify(nonce)
+			if err != nil || !valid {
+				t.Fatalf("status %d: timestamp rejected: %v", status, err)
+			}
+		}
+		// Stamping might have fetched the key history already.
+		requests := atomic.LoadInt32(&h.requests)
+		if status != http.StatusInternalServerError && requests > 1 {
+			t.Fatalf("status %d: fetched the key history %d times",
+				status, requests)
+		}
+
+		// Once checked, the key is trusted without the server.
+		ts.Close()
+		valid, err := stamp.Verify(nonce)
+		if err != nil || !valid {
+			t.Fatalf("status %d: timestamp rejected offline: %v", status, err)
+		}
+	}
+}
+
+// A Cache that only implements the methods required by the Cache
+// interface.
+type basicCache struct {
+	pks   map[string]time.Time
+	infos map[string]atum.ServerInfo
+}
+
+func (c *basicCache) StorePublicKey(serverUrl string,
+	alg atum.SignatureAlgorithm, pk []byte, expires time.Time) {
+	c.pks[serverUrl+string(alg)+string(pk)] = expires
+}
+
+func (c *basicCache) GetPublicKey(serverUrl string,
+	alg atum.SignatureAlgorithm, pk []byte) *time.Time {
+	if expires, ok := c.pks[serverUrl+string(alg)+string(pk)]; ok {
+		return &expires
+	}
+	return nil
+}
+
+func (c *basicCache) StoreServerInfo(serverUrl string, info atum.ServerInfo) {
+	c.infos[serverUrl] = info
+}
+
+func (c *basicCache) GetServerInfo(serverUrl string) *atum.ServerInfo {
+	if info, ok := c.infos[serverUrl]; ok {
+		return &info
+	}
+	return nil
+}
+
+func TestBasicCache(t *testing.T) {
+	url := startServer(t, server.Config{})
+	atum.SetCache(&basicCache{
+		pks:   make(map[string]time.Time),
+		infos: make(map[string]atum.ServerInfo),
+	})
+	nonce := []byte("some nonce")
+	stamp, err := atum.SendRequest(url, atum.Request{Nonce: nonce})
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err := stamp.Verify(nonce)
+	if err != nil || !valid {
+		t.Fatalf("timestamp rejected: %v", err)
+	}
+}
@@ -0,0 +1,390 @@
+package lms
+
+// LMS Merkle trees and the HSS construction on top of them.
+// See sections 5 and 6 of RFC 8554.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// Size of a LMS public key in bytes.
+const lmsPkSize = 4 + 4 + idLen + n
+
+// A single LMS key pair: a Merkle tree on top of LM-OTS key pairs.
+type tree struct {
+	params LevelParams
+	id     []byte   // the key pair identifier I
+	seed   []byte   // seed from which the LM-OTS private keys are derived
+	nodes  [][]byte // nodes[r] is node r of the tree; 1 is the root.
+}
+
+// Computes the Merkle tree for the given key pair.
+func newTree(params LevelParams, id, seed []byte) *tree {
+	h := params.Lms.Height()
+	t := &tree{
+		params: params,
+		id:     id,
+		seed:   seed,
+		nodes:  make([][]byte, 1<<(h+1)),
+	}
+
+	// Compute the leafs, which is the expensive part, in parallel.
+	leafs := uint32(1) << h
+	threads := uint32(runtime.NumCPU())
+	var wg sync.WaitGroup
+	for thread := uint32(0); thread < threads; thread++ {
+		wg.Add(1)
+		go func(thread uint32) {
+			defer wg.Done()
+			hh := sha256.New()
+			for q := thread; q < leafs; q += threads {
+				otsPk := params.Ots.publicKey(id, seed, q)
+				hh.Reset()
+				hh.Write(id)
+				hh.Write(u32str(leafs + q))
+				hh.Write(u16str(dLeaf))
+				hh.Write(otsPk)
+				t.nodes[leafs+q] = hh.Sum(nil)
+			}
+		}(thread)
+	}
+	wg.Wait()
+
+	hh := sha256.New()
+	for r := leafs - 1; r >= 1; r-- {
+		hh.Reset()
+		hh.Write(id)
+		hh.Write(u32str(r))
+		hh.Write(u16str(dIntr))
+		hh.Write(t.nodes[2*r])
+		hh.Write(t.nodes[2*r+1])
+		t.nodes[r] = hh.Sum(nil)
+	}
+	return t
+}
+
+func (t *tree) publicKey() []byte {
+	ret := make([]byte, 0, lmsPkSize)
+	ret = append(ret, u32str(uint32(t.params.Lms))...)
+	ret = append(ret, u32str(uint32(t.params.Ots))...)
+	ret = append(ret, t.id...)
+	return append(ret, t.nodes[1]...)
+}
+
+// Signs msg with the q-th LM-OTS key pair of the tree.
+func (t *tree) sign(q uint32, msg []byte) []byte {
+	otsSig := t.params.Ots.sign(t.id, t.seed, q, msg)
+	ret := make([]byte, 0, t.params.sigSize())
+	ret = append(ret, u32str(q)...)
+	ret = append(ret, otsSig...)
+	ret = append(ret, u32str(uint32(t.params.Lms))...)
+	for r := (uint32(1) << t.params.Lms.Height()) + q; r > 1; r >>= 1 {
+		ret = append(ret, t.nodes[r^1]...)
+	}
+	return ret
+}
+
+// Returns the length of the LMS signature at the start of buf and
+// its parameters.  Returns -1 if buf doesn't start with a well-formed
+// LMS signature.
+func parseLmsSig(buf []byte) (int, LevelParams) {
+	var lp LevelParams
+	if len(buf) < 8 {
+		return -1, lp
+	}
+	lp.Ots = OtsType(binary.BigEndian.Uint32(buf[4:]))
+	if lp.Ots.W() == 0 {
+		return -1, lp
+	}
+	off := 4 + lp.Ots.sigSize()
+	if len(buf) < off+4 {
+		return -1, lp
+	}
+	lp.Lms = LmsType(binary.BigEndian.Uint32(buf[off:]))
+	if lp.Lms.Height() == 0 || len(buf) < lp.sigSize() {
+		return -1, lp
+	}
+	return lp.sigSize(), lp
+}
+
+// Verifies a LMS signature.
+func verifyLms(pk, sig, msg []byte) bool {
+	if len(pk) != lmsPkSize {
+		return false
+	}
+	sigLen, lp := parseLmsSig(sig)
+	if sigLen != len(sig) ||
+		LmsType(binary.BigEndian.Uint32(pk)) != lp.Lms ||
+		OtsType(binary.BigEndian.Uint32(pk[4:])) != lp.Ots {
+		return false
+	}
+	id := pk[8 : 8+idLen]
+	h := lp.Lms.Height()
+	q := binary.BigEndian.Uint32(sig)
+	if q >= 1<<h {
+		return false
+	}
+	otsPk := lp.Ots.candidatePublicKey(id, q, sig[4:4+lp.Ots.sigSize()], msg)
+	if otsPk == nil {
+		return false
+	}
+	path := sig[4+lp.Ots.sigSize()+4:]
+
+	hh := sha256.New()
+	r := (uint32(1) << h) + q
+	hh.Write(id)
+	hh.Write(u32str(r))
+	hh.Write(u16str(dLeaf))
+	hh.Write(otsPk)
+	tmp := hh.Sum(nil)
+	for i := 0; r > 1; i++ {
+		hh.Reset()
+		hh.Write(id)
+		hh.Write(u32str(r / 2))
+		hh.Write(u16str(dIntr))
+		if r%2 == 1 {
+			hh.Write(path[i*n : (i+1)*n])
+			hh.Write(tmp)
+		} else {
+			hh.Write(tmp)
+			hh.Write(path[i*n : (i+1)*n])
+		}
+		tmp = hh.Sum(tmp[:0])
+		r /= 2
+	}
+	return bytes.Equal(tmp, pk[8+idLen:])
+}
+
+// Verifies a HSS signature on msg for the given HSS public key.
+func Verify(pk, sig, msg []byte) (bool, error) {
+	if len(pk) != 4+lmsPkSize {
+		return false, fmt.Errorf("Public key has wrong length")
+	}
+	if len(sig) < 4 {
+		return false, fmt.Errorf("Signature is too short")
+	}
+	levels := binary.BigEndian.Uint32(pk)
+	if binary.BigEndian.Uint32(sig)+1 != levels {
+		return false, nil
+	}
+	key := pk[4:]
+	sig = sig[4:]
+	for i := uint32(0); i+1 < levels; i++ {
+		sigLen, _ := parseLmsSig(sig)
+		if sigLen < 0 || len(sig) < sigLen+lmsPkSize {
+			return false, fmt.Errorf("Signature is malformed")
+		}
+		nextKey := sig[sigLen : sigLen+lmsPkSize]
+		if !verifyLms(key, sig[:sigLen], nextKey) {
+			return false, nil
+		}
+		key = nextKey
+		sig = sig[sigLen+lmsPkSize:]
+	}
+	return verifyLms(key, sig, msg), nil
+}
+
+// Information on a HSS signature.  See ParseSignature().
+type SignatureInfo struct {
+	// The parameters of the levels used in the signature.
+	Params Params
+
+	// The leaf index used in each level.
+	Leafs []uint32
+}
+
+// Returns the index of the signature among all signatures of the key.
+func (info *SignatureInfo) Index() uint64 {
+	var ret uint64
+	for i, lp := range info.Params {
+		ret = (ret << lp.Lms.Height()) | uint64(info.Leafs[i])
+	}
+	return ret
+}
+
+func (info SignatureInfo) String() string {
+	return fmt.Sprintf("%s index=%d/%d", info.Params,
+		info.Index(), info.Params.MaxSignatures())
+}
+
+// Parses the parameters and leaf indices from a HSS signature
+// without verifying it.
+func ParseSignature(sig []byte) (*SignatureInfo, error) {
+	if len(sig) < 4 {
+		return nil, fmt.Errorf("Signature is too short")
+	}
+	var ret SignatureInfo
+	nspk := binary.BigEndian.Uint32(sig)
+	if nspk > 7 {
+		return nil, fmt.Errorf("Signature has too many levels")
+	}
+	sig = sig[4:]
+	for i := uint32(0); i <= nspk; i++ {
+		sigLen, lp := parseLmsSig(sig)
+		if sigLen < 0 {
+			return nil, fmt.Errorf("Signature is malformed")
+		}
+		ret.Params = append(ret.Params, lp)
+		ret.Leafs = append(ret.Leafs, binary.BigEndian.Uint32(sig))
+		sig = sig[sigLen:]
+		if i < nspk {
+			if len(sig) < lmsPkSize {
+				return nil, fmt.Errorf("Signature is malformed")
+			}
+			sig = sig[lmsPkSize:]
+		}
+	}
+	if len(sig) != 0 {
+		return nil, fmt.Errorf("Signature has trailing data")
+	}
+	return &ret, nil
+}
+
+// A HSS private key.
+//
+// The private key itself is stateless: the caller must keep track of
+// which signature indices have been used.
+type PrivateKey struct {
+	params Params
+	seed   []byte // master seed from which all trees are derived
+
+	mux     sync.Mutex
+	trees   []*tree  // the tree in use for each level
+	treeIdx []uint64 // the index of the tree in use for each level
+	pkSigs  [][]byte // pkSigs[i] is the signature of trees[i+1] by trees[i]
+}
+
+// Generates a new HSS private key with the given parameters.
+func GenerateKey(params Params) (*PrivateKey, error) {
+	seed := make([]byte, n)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	return NewKeyFromSeed(params, seed)
+}
+
+// Derives a HSS private key with the given parameters from a 32 byte seed.
+func NewKeyFromSeed(params Params, seed []byte) (*PrivateKey, error) {
+	if err := params.Check(); err != nil {
+		return nil, err
+	}
+	if len(seed) != n {
+		return nil, fmt.Errorf("Seed should be %d bytes", n)
+	}
+	sk := &PrivateKey{
+		params:  params,
+		seed:    seed,
+		trees:   make([]*tree, len(params)),
+		treeIdx: make([]uint64, len(params)),
+		pkSigs:  make([][]byte, len(params)-1),
+	}
+	sk.trees[0] = sk.deriveTree(0, 0)
+	return sk, nil
+}
+
+// Returns the parameters of the private key.
+func (sk *PrivateKey) Params() Params {
+	return sk.params
+}
+
+// Derives the given tree on the given level from the master seed.
+func (sk *PrivateKey) deriveTree(level uint32, idx uint64) *tree {
+	var buf [12]byte
+	binary.BigEndian.PutUint32(buf[:], level)
+	binary.BigEndian.PutUint64(buf[4:], idx)
+	h := sha256.New()
+	h.Write(sk.seed)
+	h.Write(buf[:])
+	h.Write([]byte{0})
+	seed := h.Sum(nil)
+	h.Reset()
+	h.Write(sk.seed)
+	h.Write(buf[:])
+	h.Write([]byte{1})
+	id := h.Sum(nil)[:idLen]
+	return newTree(sk.params[level], id, seed)
+}
+
+// Returns the serialized HSS public key.
+func (sk *PrivateKey) PublicKey() []byte {
+	return append(u32str(uint32(len(sk.params))), sk.trees[0].publicKey()...)
+}
+
+// Signs msg using the signature with the given index.
+//
+// NOTE Never use the same index twice: that breaks the security of the
+//      scheme completely.
+func (sk *PrivateKey) Sign(index uint64, msg []byte) ([]byte, error) {
+	sk.mux.Lock()
+	defer sk.mux.Unlock()
+
+	if index >= sk.params.MaxSignatures() {
+		return nil, fmt.Errorf("Signature index %d out of range", index)
+	}
+
+	// Compute the leaf on each level
+	levels := len(sk.params)
+	leafs := make([]uint32, levels)
+	shift := uint32(0)
+	for i := levels - 1; i >= 0; i-- {
+		h := sk.params[i].Lms.Height()
+		leafs[i] = uint32((index >> shift) & ((1 << h) - 1))
+		shift += h
+	}
+
+	// Make sure the right trees are loaded and signed.
+	treeIdx := uint64(0)
+	for i := 1; i < levels; i++ {
+		treeIdx = (treeIdx << sk.params[i-1].Lms.Height()) |
+			uint64(leafs[i-1])
+		if sk.trees[i] != nil && sk.treeIdx[i] == treeIdx {
+			continue
+		}
+		sk.trees[i] = sk.deriveTree(uint32(i), treeIdx)
+		sk.treeIdx[i] = treeIdx
+		sk.pkSigs[i-1] = sk.trees[i-1].sign(leafs[i-1],
+			sk.trees[i].publicKey())
+	}
+
+	ret := u32str(uint32(levels - 1))
+	for i := 0; i+1 < levels; i++ {
+		ret = append(ret, sk.pkSigs[i]...)
+		ret = append(ret, sk.trees[i+1].publicKey()...)
+	}
+	return append(ret, sk.trees[levels-1].sign(leafs[levels-1], msg)...), nil
+}
+
+// Serializes the private key (without state).
+func (sk *PrivateKey) MarshalBinary() ([]byte, error) {
+	ret := u32str(uint32(len(sk.params)))
+	for _, lp := range sk.params {
+		ret = append(ret, u32str(uint32(lp.Lms))...)
+		ret = append(ret, u32str(uint32(lp.Ots))...)
+	}
+	return append(ret, sk.seed...), nil
+}
+
+// Parses a private key serialized with MarshalBinary().
+func ParsePrivateKey(buf []byte) (*PrivateKey, error) {
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("Private key is too short")
+	}
+	levels := binary.BigEndian.Uint32(buf)
+	if levels > 8 || len(buf) != 4+8*int(levels)+n {
+		return nil, fmt.Errorf("Private key has wrong length")
+	}
+	params := make(Params, levels)
+	for i := range params {
+		params[i].Lms = LmsType(binary.BigEndian.Uint32(buf[4+8*i:]))
+		params[i].Ots = OtsType(binary.BigEndian.Uint32(buf[8+8*i:]))
+	}
+	seed := make([]byte, n)
+	copy(seed, buf[4+8*levels:])
+	return NewKeyFromSeed(params, seed)
+}
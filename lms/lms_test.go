@@ -0,0 +1,204 @@
+package lms
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func unhex(s string) []byte {
+	ret, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// The two levels of test case 2 of appendix F of RFC 8554.
+var rfc8554Case2 = []struct {
+	params LevelParams
+	id     string
+	seed   string
+	root   string
+}{
+	{
+		LevelParams{LMS_SHA256_M32_H10, LMOTS_SHA256_N32_W4},
+		"d08fabd4a2091ff0a8cb4ed834e74534",
+		"558b8966c48ae9cb898b423c83443aae014a72f1b1ab5cc85cf1d892903b5439",
+		"32a58885cd9ba0431235466bff9651c6c92124404d45fa53cf161c28f1ad5a8e",
+	},
+	{
+		LevelParams{LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8},
+		"215f83b7ccb9acbcd08db97b0d04dc2b",
+		"a1c4696e2608035a886100d05cd99945eb3370731884a8235e2fb3d4d71f2547",
+		"a1cd035833e0e90059603f26e07ad2aad152338e7a5e5984bcd5f7bb4eba40b7",
+	},
+}
+
+func TestRFC8554Vectors(t *testing.T) {
+	var trees []*tree
+	for i, tc := range rfc8554Case2 {
+		tr := newTree(tc.params, unhex(tc.id), unhex(tc.seed))
+		if !bytes.Equal(tr.nodes[1], unhex(tc.root)) {
+			t.Fatalf("level %d: root %x, expected %s", i, tr.nodes[1], tc.root)
+		}
+		trees = append(trees, tr)
+	}
+
+	// Sign with the same leafs as the test vector.
+	pk := append(u32str(2), trees[0].publicKey()...)
+	msg := []byte("The enumeration in the Constitution, of certain rights, " +
+		"shall not be construed to deny or disparage others retained " +
+		"by the people.\n")
+	pkSig := trees[0].sign(3, trees[1].publicKey())
+	msgSig := trees[1].sign(4, msg)
+	sig := u32str(1)
+	sig = append(sig, pkSig...)
+	sig = append(sig, trees[1].publicKey()...)
+	sig = append(sig, msgSig...)
+	ok, err := Verify(pk, sig, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("signature is invalid")
+	}
+	info, err := ParseSignature(sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Index() != 3<<5|4 {
+		t.Fatalf("signature index %d, expected %d", info.Index(), 3<<5|4)
+	}
+}
+
+func testRoundTrip(t *testing.T, name string) {
+	params, err := ParseParams(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, err := GenerateKey(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk2, err := ParsePrivateKey(mustMarshal(t, sk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sk.PublicKey(), sk2.PublicKey()) {
+		t.Fatal("public key changed after serialization")
+	}
+	pk := sk.PublicKey()
+	max := params.MaxSignatures()
+	for _, index := range []uint64{0, 1, max / 2, max - 1} {
+		msg := []byte(name)
+		sig, err := sk.Sign(index, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, err := Verify(pk, sig, msg)
+		if err != nil {
+			t.Fatalf("index %d: %v", index, err)
+		}
+		if !ok {
+			t.Fatalf("index %d: signature is invalid", index)
+		}
+		info, err := ParseSignature(sig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Index() != index || info.Params.Name() != name {
+			t.Fatalf("index %d: parsed %s", index, info)
+		}
+	}
+	if _, err = sk.Sign(max, []byte(name)); err == nil {
+		t.Fatal("signed with an index out of range")
+	}
+}
+
+func mustMarshal(t *testing.T, sk *PrivateKey) []byte {
+	buf, err := sk.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestRoundTrip(t *testing.T) {
+	names := []string{
+		"H5_W1", "H5_W2", "H5_W4", "H5_W8",
+		"H5_W8/H5_W8", "H10_W4/H5_W8", "H5_W1/H5_W2/H5_W4",
+	}
+	if !testing.Short() {
+		names = append(names, "H10_W8", "H15_W1")
+	}
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) { testRoundTrip(t, name) })
+	}
+}
+
+func TestTamper(t *testing.T) {
+	params, _ := ParseParams("H5_W4/H5_W4")
+	sk, err := GenerateKey(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("message")
+	pk := sk.PublicKey()
+	sig, err := sk.Sign(37, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(pk, sig, msg []byte) bool {
+		ok, _ := Verify(pk, sig, msg)
+		return ok
+	}
+	if !verify(pk, sig, msg) {
+		t.Fatal("signature is invalid")
+	}
+	if verify(pk, sig, []byte("massage")) {
+		t.Fatal("accepted signature on another message")
+	}
+	for i := range sig {
+		sig2 := append([]byte{}, sig...)
+		sig2[i] ^= 1
+		if verify(pk, sig2, msg) {
+			t.Fatalf("accepted signature with byte %d changed", i)
+		}
+	}
+	for i := range pk {
+		pk2 := append([]byte{}, pk...)
+		pk2[i] ^= 1
+		if verify(pk2, sig, msg) {
+			t.Fatalf("accepted signature with byte %d of pk changed", i)
+		}
+	}
+	for _, l := range []int{0, 4, len(sig) / 2, len(sig) - 1} {
+		if verify(pk, sig[:l], msg) {
+			t.Fatalf("accepted signature truncated to %d bytes", l)
+		}
+	}
+	if verify(pk, append(sig, 0), msg) {
+		t.Fatal("accepted signature with trailing data")
+	}
+}
+
+func TestParams(t *testing.T) {
+	for _, name := range []string{"H5_W8", "H20_W8/H10_W4"} {
+		params, err := ParseParams(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if params.Name() != name {
+			t.Fatalf("%s: got %s", name, params.Name())
+		}
+	}
+	for _, name := range []string{
+		"", "H5", "H7_W8", "H5_W3", "H25_W8", "H20_W8/H20_W8/H20_W8/H5_W8",
+	} {
+		if _, err := ParseParams(name); err == nil {
+			t.Fatalf("%s: accepted", name)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package lms
+
+// LM-OTS one-time signatures.  See section 4 of RFC 8554.
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+)
+
+// Returns the i-th w-bit digit of s.
+func coef(s []byte, i, w uint32) uint32 {
+	return uint32((1<<w)-1) & uint32(s[i*w/8]>>(8-(w*(i%(8/w))+w)))
+}
+
+// Returns the hash of the message followed by its checksum, as
+// needed to compute the digits signed by the hash chains.
+func (t OtsType) digits(q []byte) []byte {
+	w := t.W()
+	var sum uint32
+	for i := uint32(0); i < n*8/w; i++ {
+		sum += (1 << w) - 1 - coef(q, i, w)
+	}
+	ret := make([]byte, n+2)
+	copy(ret, q)
+	binary.BigEndian.PutUint16(ret[n:], uint16(sum<<t.ls()))
+	return ret
+}
+
+// Advances the hash chain from step start to step end (exclusive).
+func chain(h hash.Hash, id []byte, q uint32, i uint16,
+	tmp []byte, start, end uint32) []byte {
+	for j := start; j < end; j++ {
+		h.Reset()
+		h.Write(id)
+		h.Write(u32str(q))
+		h.Write(u16str(i))
+		h.Write([]byte{byte(j)})
+		h.Write(tmp)
+		tmp = h.Sum(tmp[:0])
+	}
+	return tmp
+}
+
+// Computes the private value of the i-th hash chain of the q-th LM-OTS
+// key pair, derived from the seed as described in appendix A of RFC 8554.
+func otsPrivate(h hash.Hash, id, seed []byte, q uint32, i uint16) []byte {
+	h.Reset()
+	h.Write(id)
+	h.Write(u32str(q))
+	h.Write(u16str(i))
+	h.Write([]byte{0xff})
+	h.Write(seed)
+	return h.Sum(nil)
+}
+
+// Derives the randomizer C of the LM-OTS signature by the q-th LM-OTS key
+// pair from the seed, just like the private values, but with an index
+// beyond those of the hash chains.  As C doesn't depend on randomness, the
+// key pair signs the same message twice with the same signature, which
+// happens when the public key of a lower tree is signed again after the
+// key is reloaded.  A different C would reuse the one-time key.
+func otsRandomizer(h hash.Hash, id, seed []byte, q uint32) []byte {
+	return otsPrivate(h, id, seed, q, 0xfffd)
+}
+
+// Computes the message digest Q that's signed by the LM-OTS signature.
+func messageDigest(h hash.Hash, id []byte, q uint32, c, msg []byte) []byte {
+	h.Reset()
+	h.Write(id)
+	h.Write(u32str(q))
+	h.Write(u16str(dMesg))
+	h.Write(c)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// Computes the hash of the q-th LM-OTS public key.
+func (t OtsType) publicKey(id, seed []byte, q uint32) []byte {
+	h := sha256.New()
+	w := t.W()
+	ys := make([][]byte, t.p())
+	for i := range ys {
+		x := otsPrivate(h, id, seed, q, uint16(i))
+		ys[i] = chain(h, id, q, uint16(i), x, 0, (1<<w)-1)
+	}
+	return t.compressPublicKey(h, id, q, ys)
+}
+
+func (t OtsType) compressPublicKey(h hash.Hash, id []byte, q uint32,
+	ys [][]byte) []byte {
+	h.Reset()
+	h.Write(id)
+	h.Write(u32str(q))
+	h.Write(u16str(dPblc))
+	for _, y := range ys {
+		h.Write(y)
+	}
+	return h.Sum(nil)
+}
+
+// Creates the LM-OTS signature on msg with the q-th LM-OTS key pair.
+func (t OtsType) sign(id, seed []byte, q uint32, msg []byte) []byte {
+	h := sha256.New()
+	w := t.W()
+	ret := make([]byte, 4, t.sigSize())
+	binary.BigEndian.PutUint32(ret, uint32(t))
+	c := otsRandomizer(h, id, seed, q)
+	ret = append(ret, c...)
+	digits := t.digits(messageDigest(h, id, q, c, msg))
+	for i := uint32(0); i < t.p(); i++ {
+		x := otsPrivate(h, id, seed, q, uint16(i))
+		ret = append(ret, chain(h, id, q, uint16(i), x, 0,
+			coef(digits, i, w))...)
+	}
+	return ret
+}
+
+// Computes the candidate LM-OTS public key hash from a signature.
+// Returns nil if the signature is malformed.
+func (t OtsType) candidatePublicKey(id []byte, q uint32, sig, msg []byte) []byte {
+	if len(sig) != t.sigSize() ||
+		OtsType(binary.BigEndian.Uint32(sig)) != t {
+		return nil
+	}
+	h := sha256.New()
+	w := t.W()
+	digits := t.digits(messageDigest(h, id, q, sig[4:4+n], msg))
+	ys := make([][]byte, t.p())
+	for i := range ys {
+		y := make([]byte, n)
+		copy(y, sig[4+n+i*n:4+n+(i+1)*n])
+		ys[i] = chain(h, id, q, uint16(i), y,
+			coef(digits, uint32(i), w), (1<<w)-1)
+	}
+	return t.compressPublicKey(h, id, q, ys)
+}
@@ -0,0 +1,218 @@
+// Leighton-Micali hash-based signatures (LMS/HSS) as described in RFC 8554
+// and NIST SP 800-208.
+//
+// Only the SHA-256 instances with 32 byte hashes are supported.
+package lms
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Hash length in bytes of the supported instances.
+const n = 32
+
+// Length in bytes of the key pair identifier I.
+const idLen = 16
+
+// Domain separators from RFC 8554
+const (
+	dPblc = 0x8080
+	dMesg = 0x8181
+	dLeaf = 0x8282
+	dIntr = 0x8383
+)
+
+// LMS typecode, which determines the height of the Merkle tree.
+type LmsType uint32
+
+const (
+	LMS_SHA256_M32_H5  LmsType = 5
+	LMS_SHA256_M32_H10 LmsType = 6
+	LMS_SHA256_M32_H15 LmsType = 7
+	LMS_SHA256_M32_H20 LmsType = 8
+	LMS_SHA256_M32_H25 LmsType = 9
+)
+
+// LM-OTS typecode, which determines the Winternitz parameter.
+type OtsType uint32
+
+const (
+	LMOTS_SHA256_N32_W1 OtsType = 1
+	LMOTS_SHA256_N32_W2 OtsType = 2
+	LMOTS_SHA256_N32_W4 OtsType = 3
+	LMOTS_SHA256_N32_W8 OtsType = 4
+)
+
+// Returns the height of the Merkle tree, or 0 if the type is unsupported.
+func (t LmsType) Height() uint32 {
+	if t < LMS_SHA256_M32_H5 || t > LMS_SHA256_M32_H25 {
+		return 0
+	}
+	return 5 * (uint32(t) - 4)
+}
+
+func (t LmsType) String() string {
+	if t.Height() == 0 {
+		return fmt.Sprintf("LMS_UNKNOWN_%d", uint32(t))
+	}
+	return fmt.Sprintf("LMS_SHA256_M32_H%d", t.Height())
+}
+
+// Returns the Winternitz parameter (in bits), or 0 if the type is
+// unsupported.
+func (t OtsType) W() uint32 {
+	if t < LMOTS_SHA256_N32_W1 || t > LMOTS_SHA256_N32_W8 {
+		return 0
+	}
+	return 1 << (uint32(t) - 1)
+}
+
+// Returns the number of hash chains in a LM-OTS signature.
+func (t OtsType) p() uint32 {
+	return [...]uint32{0, 265, 133, 67, 34}[t]
+}
+
+// Returns the left shift used in the LM-OTS checksum.
+func (t OtsType) ls() uint32 {
+	return [...]uint32{0, 7, 6, 4, 0}[t]
+}
+
+// Returns the size of a LM-OTS signature in bytes.
+func (t OtsType) sigSize() int {
+	return int(4 + n + t.p()*n)
+}
+
+func (t OtsType) String() string {
+	if t.W() == 0 {
+		return fmt.Sprintf("LMOTS_UNKNOWN_%d", uint32(t))
+	}
+	return fmt.Sprintf("LMOTS_SHA256_N32_W%d", t.W())
+}
+
+// Parameters of a single level of a HSS tree.
+type LevelParams struct {
+	Lms LmsType
+	Ots OtsType
+}
+
+func (lp LevelParams) String() string {
+	return fmt.Sprintf("%s/%s", lp.Lms, lp.Ots)
+}
+
+// Size of a LMS signature in bytes for these parameters.
+func (lp LevelParams) sigSize() int {
+	return 4 + lp.Ots.sigSize() + 4 + int(lp.Lms.Height())*n
+}
+
+// Parameters of a HSS key: the parameters for each level, top first.
+// A single level corresponds to plain LMS.
+type Params []LevelParams
+
+// Returns the total number of signatures that can be created
+// with these parameters.
+func (params Params) MaxSignatures() uint64 {
+	return uint64(1) << params.totalHeight()
+}
+
+func (params Params) totalHeight() uint32 {
+	var ret uint32
+	for _, lp := range params {
+		ret += lp.Lms.Height()
+	}
+	return ret
+}
+
+// Maximum height of a tree of a private key.  A tree is kept in memory
+// completely, which takes 2GB for a tree of height 25.  Signatures with
+// trees of height 25 can still be verified.
+const MaxKeyHeight = 20
+
+// Checks whether the parameters are supported for a private key.
+func (params Params) Check() error {
+	if len(params) < 1 || len(params) > 8 {
+		return fmt.Errorf("HSS requires between 1 and 8 levels")
+	}
+	for _, lp := range params {
+		if lp.Lms.Height() == 0 {
+			return fmt.Errorf("Unsupported LMS type %d", uint32(lp.Lms))
+		}
+		if lp.Ots.W() == 0 {
+			return fmt.Errorf("Unsupported LM-OTS type %d", uint32(lp.Ots))
+		}
+		if lp.Lms.Height() > MaxKeyHeight {
+			return fmt.Errorf("Trees higher than %d are not supported",
+				MaxKeyHeight)
+		}
+	}
+	if params.totalHeight() > 63 {
+		return fmt.Errorf("Total height of the trees can't exceed 63")
+	}
+	return nil
+}
+
+// Returns the parameters in the format accepted by ParseParams, for instance
+// H10_W8/H5_W8.
+func (params Params) Name() string {
+	bits := make([]string, len(params))
+	for i, lp := range params {
+		bits[i] = fmt.Sprintf("H%d_W%d", lp.Lms.Height(), lp.Ots.W())
+	}
+	return strings.Join(bits, "/")
+}
+
+func (params Params) String() string {
+	bits := make([]string, len(params))
+	for i, lp := range params {
+		bits[i] = lp.String()
+	}
+	return fmt.Sprintf("HSS-L%d[%s]", len(params), strings.Join(bits, ","))
+}
+
+// Parses parameters from a string like H10_W8/H5_W8, which describes a
+// HSS key with two levels: the top tree of height 10 and the bottom trees
+// of height 5, all using Winternitz parameter 8.
+func ParseParams(name string) (Params, error) {
+	var ret Params
+	for _, bit := range strings.Split(name, "/") {
+		hw := strings.Split(bit, "_")
+		if len(hw) != 2 || !strings.HasPrefix(hw[0], "H") ||
+			!strings.HasPrefix(hw[1], "W") {
+			return nil, fmt.Errorf("%s: expected something like H10_W8", bit)
+		}
+		h, err := strconv.Atoi(hw[0][1:])
+		if err != nil || h%5 != 0 {
+			return nil, fmt.Errorf("%s: unsupported height", bit)
+		}
+		w, err := strconv.Atoi(hw[1][1:])
+		if err != nil {
+			return nil, fmt.Errorf("%s: unsupported Winternitz parameter", bit)
+		}
+		var lp LevelParams
+		lp.Lms = LmsType(h/5 + 4)
+		for t := LMOTS_SHA256_N32_W1; t <= LMOTS_SHA256_N32_W8; t++ {
+			if t.W() == uint32(w) {
+				lp.Ots = t
+			}
+		}
+		ret = append(ret, lp)
+	}
+	if err := ret.Check(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func u16str(x uint16) []byte {
+	var ret [2]byte
+	binary.BigEndian.PutUint16(ret[:], x)
+	return ret[:]
+}
+
+func u32str(x uint32) []byte {
+	var ret [4]byte
+	binary.BigEndian.PutUint32(ret[:], x)
+	return ret[:]
+}
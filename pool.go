@@ -0,0 +1,349 @@
+package atum
+
+import (
+	"context"
+	"crypto/sha256"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Options for a StampPool.  The zero value is a sensible default.
+type StampPoolOptions struct {
+	// Number of requests with proof of work handled concurrently.
+	// Defaults to 1.
+	Workers int
+
+	// Maximum number of requests waiting for a worker.  Further requests
+	// are refused.  Defaults to 64.
+	QueueSize int
+
+	// Options for the proofs of work.
+	ProofOfWork PowOptions
+
+	// If the context of a request has a deadline that is expected to pass
+	// before the proof of work is done (including the time spent waiting
+	// in the queue), request a timestamp with the first of these signature
+	// algorithms that the server supports and that requires no proof of
+	// work (or one that is expected to finish in time) instead.  Defaults
+	// to ed25519.
+	FallbackSigAlgs []SignatureAlgorithm
+
+	// Don't fall back to another signature algorithm.
+	NoFallback bool
+}
+
+// Statistics of a StampPool.  See StampPool.Stats().
+type StampPoolStats struct {
+	// Number of requests waiting for a worker
+	QueueDepth int
+
+	// Capacity of the queue
+	QueueSize int
+
+	// Number of workers busy with a request
+	Active int
+
+	// Number of requests handled successfully
+	Completed uint64
+
+	// Number of requests that failed
+	Failed uint64
+
+	// Number of requests refused because the queue was full
+	Rejected uint64
+
+	// Number of requests for which we fell back to another
+	// signature algorithm because of their deadline
+	Fallbacks uint64
+
+	// Average time spent by a worker on a request with proof of work
+	AverageWorkTime time.Duration
+
+	// Estimated number of proof of work hashes computed per second
+	HashRate float64
+}
+
+// Requests timestamps from an Atum server, computing the proofs of work
+// in a pool of background workers with a bounded queue, so that the latency
+// of stamping is predictable.  Use NewStampPool() to create one.
+type StampPool struct {
+	serverUrl string
+	opts      StampPoolOptions
+	queue     chan *poolJob
+	wg        sync.WaitGroup
+
+	queueMux sync.Mutex // protects the queue against sends after Close()
+	closed   bool
+
+	active, completed, failed, rejected, fallbacks uint64 // atomic
+
+	mux      sync.Mutex
+	workTime time.Duration // moving average of time spent per request
+	hashRate float64       // moving average of hashes per second
+}
+
+type poolJob struct {
+	ctx    context.Context
+	req    Request
+	result chan poolResult
+}
+
+type poolResult struct {
+	ts  *Timestamp
+	err Error
+}
+
+// Creates a new pool of workers requesting timestamps from the given server.
+//
+// NOTE Do not forget to Close() the pool.
+func NewStampPool(serverUrl string, opts StampPoolOptions) *StampPool {
+	if !strings.HasSuffix(serverUrl, "/") {
+		serverUrl += "/"
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 64
+	}
+	if opts.FallbackSigAlgs == nil {
+		opts.FallbackSigAlgs = []SignatureAlgorithm{Ed25519}
+	}
+	p := &StampPool{
+		serverUrl: serverUrl,
+		opts:      opts,
+		queue:     make(chan *poolJob, opts.QueueSize),
+	}
+	for i := 0; i < opts.Workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+// Stops the workers after the queued requests are handled.  Further
+// requests are refused.
+func (p *StampPool) Close() {
+	p.queueMux.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.queueMux.Unlock()
+	p.wg.Wait()
+}
+
+// Returns whether the pool is closed.
+func (p *StampPool) isClosed() bool {
+	p.queueMux.Lock()
+	defer p.queueMux.Unlock()
+	return p.closed
+}
+
+// Returns statistics of the pool, such as the depth of the queue.
+func (p *StampPool) Stats() StampPoolStats {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return StampPoolStats{
+		QueueDepth:      len(p.queue),
+		QueueSize:       cap(p.queue),
+		Active:          int(atomic.LoadUint64(&p.active)),
+		Completed:       atomic.LoadUint64(&p.completed),
+		Failed:          atomic.LoadUint64(&p.failed),
+		Rejected:        atomic.LoadUint64(&p.rejected),
+		Fallbacks:       atomic.LoadUint64(&p.fallbacks),
+		AverageWorkTime: p.workTime,
+		HashRate:        p.hashRate,
+	}
+}
+
+// Requests a timestamp.  If the server requires a proof of work, the request
+// is queued for a worker; otherwise it is sent right away.
+//
+// Returns an error if the queue is full or the pool is closed.
+func (p *StampPool) Stamp(ctx context.Context, req Request) (
+	*Timestamp, Error) {
+	if p.isClosed() {
+		return nil, errorf("Stamp pool is closed")
+	}
+	info, err := fetchServerInfo(p.serverUrl)
+	if err != nil {
+		return nil, err
+	}
+	powReq, ok := req.requiredProofOfWork(info)
+	if !ok {
+		return p.send(ctx, req)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && !p.opts.NoFallback &&
+		time.Now().Add(p.estimate(powReq.Difficulty)).After(deadline) {
+		if alg, ok := p.fallback(info, deadline); ok {
+			atomic.AddUint64(&p.fallbacks, 1)
+			req.SigAlgs = nil
+			req.PreferredSigAlg = &alg
+			if _, ok := req.requiredProofOfWork(info); !ok {
+				return p.send(ctx, req)
+			}
+		}
+	}
+
+	job := &poolJob{ctx: ctx, req: req, result: make(chan poolResult, 1)}
+	if err := p.enqueue(job); err != nil {
+		return nil, err
+	}
+	select {
+	case res := <-job.result:
+		return res.ts, res.err
+	case <-ctx.Done():
+		return nil, wrapErrorf(ctx.Err(), "Request cancelled")
+	}
+}
+
+// Puts the job in the queue, unless it's full or the pool is closed.
+func (p *StampPool) enqueue(job *poolJob) Error {
+	p.queueMux.Lock()
+	defer p.queueMux.Unlock()
+	if p.closed {
+		return errorf("Stamp pool is closed")
+	}
+	select {
+	case p.queue <- job:
+		return nil
+	default:
+		atomic.AddUint64(&p.rejected, 1)
+		return errorf("Proof of work queue is full")
+	}
+}
+
+// Sends the request and keeps count.
+func (p *StampPool) send(ctx context.Context, req Request) (*Timestamp, Error) {
+	ts, err := SendRequestContext(ctx, p.serverUrl, req, RequestOptions{
+		ProofOfWork: p.opts.ProofOfWork,
+	})
+	if err != nil {
+		atomic.AddUint64(&p.failed, 1)
+	} else {
+		atomic.AddUint64(&p.completed, 1)
+	}
+	return ts, err
+}
+
+func (p *StampPool) work() {
+	defer p.wg.Done()
+	for job := range p.queue {
+		if job.ctx.Err() != nil {
+			atomic.AddUint64(&p.failed, 1)
+			job.result <- poolResult{err: wrapErrorf(job.ctx.Err(),
+				"Request cancelled")}
+			continue
+		}
+		atomic.AddUint64(&p.active, 1)
+		start := time.Now()
+		ts, err := p.send(job.ctx, job.req)
+		if err == nil {
+			p.record(job.req, time.Since(start))
+		}
+		atomic.AddUint64(&p.active, ^uint64(0))
+		job.result <- poolResult{ts: ts, err: err}
+	}
+}
+
+// Updates the moving averages after a request with proof of work.
+func (p *StampPool) record(req Request, took time.Duration) {
+	info := cache.GetServerInfo(p.serverUrl)
+	if info == nil {
+		return
+	}
+	powReq, ok := req.requiredProofOfWork(info)
+	if !ok {
+		return
+	}
+	rate := float64(expectedPowHashes(powReq.Difficulty)) / took.Seconds()
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.workTime == 0 {
+		p.workTime = took
+	} else {
+		p.workTime = (3*p.workTime + took) / 4
+	}
+	if p.hashRate == 0 {
+		p.hashRate = rate
+	} else {
+		p.hashRate = (3*p.hashRate + rate) / 4
+	}
+}
+
+// Estimates how long a new request with proof of work of the given
+// difficulty would take, including the time waiting in the queue.
+func (p *StampPool) estimate(diff uint32) time.Duration {
+	p.mux.Lock()
+	rate := p.hashRate
+	workTime := p.workTime
+	p.mux.Unlock()
+	if rate == 0 {
+		rate = measureHashRate(p.opts.ProofOfWork.Threads)
+		p.mux.Lock()
+		p.hashRate = rate
+		p.mux.Unlock()
+	}
+	work := time.Duration(float64(expectedPowHashes(diff)) / rate *
+		float64(time.Second))
+	waiting := len(p.queue) + int(atomic.LoadUint64(&p.active))
+	queued := workTime * time.Duration(waiting) / time.Duration(p.opts.Workers)
+	return work + queued
+}
+
+// Returns the first fallback signature algorithm supported by the server
+// that is expected to finish before the deadline.
+func (p *StampPool) fallback(info *ServerInfo, deadline time.Time) (
+	SignatureAlgorithm, bool) {
+	for _, alg := range p.opts.FallbackSigAlgs {
+		if !info.Supports(alg) {
+			continue
+		}
+		powReq, ok := info.RequiredProofOfWork[alg]
+		if !ok || time.Now().Add(p.estimate(
+			powReq.Difficulty)).Before(deadline) {
+			return alg, true
+		}
+	}
+	return "", false
+}
+
+// Measures roughly how many proof of work hashes we compute per second
+// with the given number of threads.
+func measureHashRate(threads int) float64 {
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	const n = 1 << 14
+	var buf [80]byte
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		buf[0] = byte(i)
+		sha256.Sum256(buf[:])
+	}
+	rate := n / time.Since(start).Seconds()
+	// The lookup table is about as expensive as the hashing itself.
+	return rate * float64(threads) / 2
+}
+
+// Returns the server information from the cache, or fetches it.
+func fetchServerInfo(serverUrl string) (*ServerInfo, Error) {
+	if info := cache.GetServerInfo(serverUrl); info != nil {
+		return info, nil
+	}
+	var info ServerInfo
+	found, err := getJson(serverUrl, &info)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errorf("Failed to fetch server information")
+	}
+	cache.StoreServerInfo(serverUrl, info)
+	return &info, nil
+}
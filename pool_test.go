@@ -0,0 +1,252 @@
+package atum_test
+
+import (
+	"github.com/bwesterb/go-atum"
+	"github.com/bwesterb/go-atum/server"
+	"github.com/bwesterb/go-atum/stamper"
+
+	"github.com/bwesterb/go-pow"
+
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Holds timestamp requests until released; other requests, such as for
+// the server information, pass right through.
+type blockingHandler struct {
+	h       http.Handler
+	release chan struct{}
+	once    sync.Once
+}
+
+func (b *blockingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		<-b.release
+	}
+	b.h.ServeHTTP(w, r)
+}
+
+func (b *blockingHandler) unblock() {
+	b.once.Do(func() { close(b.release) })
+}
+
+// Starts an Atum server which requires a cheap proof of work for Ed25519
+// timestamps and holds timestamp requests until unblocked.
+func startBlockingServer(t *testing.T) (string, *blockingHandler) {
+	b := &blockingHandler{
+		h: newServer(t, server.Config{
+			PowDifficulty: map[atum.SignatureAlgorithm]uint32{
+				atum.Ed25519: 8,
+			},
+		}),
+		release: make(chan struct{}),
+	}
+	url, _ := serve(t, b)
+	t.Cleanup(b.unblock) // runs before the test server is closed
+	return url, b
+}
+
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Requests a timestamp from the pool in the background.  The error is
+// sent on the returned channel.
+func stampInBackground(ctx context.Context, pool *atum.StampPool,
+	nonce string) chan error {
+	ret := make(chan error, 1)
+	go func() {
+		_, err := pool.Stamp(ctx, atum.Request{Nonce: []byte(nonce)})
+		ret <- err
+	}()
+	return ret
+}
+
+func TestSolveProofOfWork(t *testing.T) {
+	req := pow.Request{
+		Alg:        pow.Sha2BDay,
+		Difficulty: 12,
+		Nonce:      []byte("some nonce"),
+	}
+	data := []byte("some data")
+	proof, err := atum.SolveProofOfWork(context.Background(), req, data,
+		atum.PowOptions{Threads: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proof.Check(req, data) {
+		t.Fatal("proof of work rejected")
+	}
+	if proof.Check(req, []byte("other data")) {
+		t.Fatal("proof of work accepted for other data")
+	}
+}
+
+func TestStampPool(t *testing.T) {
+	url, b := startBlockingServer(t)
+	b.unblock()
+	pool := atum.NewStampPool(url, atum.StampPoolOptions{Workers: 2})
+	defer pool.Close()
+
+	nonce := []byte("some nonce")
+	ts, err := pool.Stamp(context.Background(), atum.Request{Nonce: nonce})
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err := ts.Verify(nonce)
+	if err != nil || !valid {
+		t.Fatalf("timestamp rejected: %v", err)
+	}
+	stats := pool.Stats()
+	if stats.Completed != 1 || stats.HashRate <= 0 {
+		t.Fatalf("wrong statistics: %+v", stats)
+	}
+}
+
+func TestStampPoolQueueFull(t *testing.T) {
+	url, b := startBlockingServer(t)
+	pool := atum.NewStampPool(url, atum.StampPoolOptions{QueueSize: 1})
+	defer pool.Close()
+	defer b.unblock()
+
+	// The first request keeps the worker busy; the second fills the queue.
+	ctx := context.Background()
+	first := stampInBackground(ctx, pool, "first")
+	waitUntil(t, "the worker is busy", func() bool {
+		return pool.Stats().Active == 1
+	})
+	second := stampInBackground(ctx, pool, "second")
+	waitUntil(t, "the queue is full", func() bool {
+		return pool.Stats().QueueDepth == 1
+	})
+
+	_, err := pool.Stamp(ctx, atum.Request{Nonce: []byte("third")})
+	if err == nil || !strings.Contains(err.Error(), "full") {
+		t.Fatalf("request accepted with full queue: %v", err)
+	}
+	if pool.Stats().Rejected != 1 {
+		t.Fatalf("%d requests rejected", pool.Stats().Rejected)
+	}
+
+	b.unblock()
+	for _, c := range []chan error{first, second} {
+		if err := <-c; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pool.Stats().Completed != 2 {
+		t.Fatalf("%d requests completed", pool.Stats().Completed)
+	}
+}
+
+func TestStampPoolCancel(t *testing.T) {
+	url, b := startBlockingServer(t)
+	pool := atum.NewStampPool(url, atum.StampPoolOptions{})
+	defer pool.Close()
+	defer b.unblock()
+
+	first := stampInBackground(context.Background(), pool, "first")
+	waitUntil(t, "the worker is busy", func() bool {
+		return pool.Stats().Active == 1
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	second := stampInBackground(ctx, pool, "second")
+	waitUntil(t, "the request is queued", func() bool {
+		return pool.Stats().QueueDepth == 1
+	})
+
+	cancel()
+	if err := <-second; err == nil ||
+		!strings.Contains(err.Error(), "cancelled") {
+		t.Fatalf("cancelled request returned %v", err)
+	}
+
+	// The worker skips the cancelled request.
+	b.unblock()
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, "the cancelled request is dropped", func() bool {
+		return pool.Stats().Failed == 1
+	})
+	if pool.Stats().Completed != 1 {
+		t.Fatalf("%d requests completed", pool.Stats().Completed)
+	}
+}
+
+func TestStampPoolFallback(t *testing.T) {
+	// The proof of work for LMS would take far longer than the deadline.
+	url := startServer(t, server.Config{
+		Signers: []stamper.Signer{newEd25519Signer(t), newLMSSigner(t)},
+		PowDifficulty: map[atum.SignatureAlgorithm]uint32{
+			atum.LMS: 50,
+		},
+	})
+	lmsAlg := atum.LMS
+	req := atum.Request{Nonce: []byte("some nonce"), PreferredSigAlg: &lmsAlg}
+
+	pool := atum.NewStampPool(url, atum.StampPoolOptions{})
+	defer pool.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ts, err := pool.Stamp(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Sig.Alg != atum.Ed25519 {
+		t.Fatalf("got %s timestamp instead of ed25519", ts.Sig.Alg)
+	}
+	if pool.Stats().Fallbacks != 1 {
+		t.Fatalf("%d fallbacks", pool.Stats().Fallbacks)
+	}
+
+	// Without fallback, the proof of work is cancelled at the deadline.
+	noFallback := atum.NewStampPool(url, atum.StampPoolOptions{
+		NoFallback: true,
+	})
+	defer noFallback.Close()
+	ctx, cancel = context.WithTimeout(context.Background(),
+		100*time.Millisecond)
+	defer cancel()
+	if _, err = noFallback.Stamp(ctx, req); err == nil {
+		t.Fatal("proof of work finished before the deadline")
+	}
+}
+
+func TestStampPoolClosed(t *testing.T) {
+	url, b := startBlockingServer(t)
+	b.unblock()
+	pool := atum.NewStampPool(url, atum.StampPoolOptions{})
+
+	// Requests racing with Close() either succeed or fail, but don't panic.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pool.Stamp(context.Background(), atum.Request{
+				Nonce: []byte(fmt.Sprintf("nonce %d", i)),
+			})
+		}(i)
+	}
+	pool.Close()
+	wg.Wait()
+
+	_, err := pool.Stamp(context.Background(),
+		atum.Request{Nonce: []byte("some nonce")})
+	if err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Fatalf("closed pool returned %v", err)
+	}
+	pool.Close()
+}
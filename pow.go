@@ -0,0 +1,219 @@
+package atum
+
+import (
+	"github.com/bwesterb/go-pow"
+
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Options for solving a proof of work.  The zero value is a sensible default.
+type PowOptions struct {
+	// Number of goroutines to use.  Defaults to the number of CPUs.
+	Threads int
+
+	// Refuse proofs of work of higher difficulty.  Defaults to 0: no limit.
+	MaxDifficulty uint32
+
+	// If set, called regularly with the progress of the proof of work.
+	Progress func(PowProgress)
+
+	// Time between two calls to Progress.  Defaults to a tenth of a second.
+	ProgressInterval time.Duration
+}
+
+// Progress of a proof of work.  See PowOptions.Progress.
+type PowProgress struct {
+	// The difficulty of the proof of work
+	Difficulty uint32
+
+	// Number of hashes computed so far
+	Hashes uint64
+
+	// Expected number of hashes required in total
+	ExpectedHashes uint64
+
+	// Time spent so far
+	Elapsed time.Duration
+
+	// Estimate of the time left
+	Remaining time.Duration
+}
+
+// Number of hashes a worker computes between checks for cancellation.
+const powBatchSize = 4096
+
+// Number of shards of the lookup table of the proof of work.
+const powShards = 256
+
+// Returns the expected number of hashes required to find a triple collision
+// on the given number of bits, which is Γ(4/3) (3!)^(1/3) 2^(2 diff / 3).
+func expectedPowHashes(diff uint32) uint64 {
+	return uint64(1.62 * math.Pow(2, 2*float64(diff)/3))
+}
+
+// Solves the proof of work on the given data.  Unlike pow.Request.Fulfil(),
+// the work is spread over several goroutines and can be cancelled using
+// the context.
+func SolveProofOfWork(ctx context.Context, req pow.Request, data []byte,
+	opts PowOptions) (*pow.Proof, Error) {
+	if req.Alg != pow.Sha2BDay {
+		return nil, errorf("Proof of work algorithm %s not supported", req.Alg)
+	}
+	if req.Difficulty > 63 {
+		return nil, errorf("Proof of work difficulty %d is too high",
+			req.Difficulty)
+	}
+	if opts.MaxDifficulty != 0 && req.Difficulty > opts.MaxDifficulty {
+		return nil, errorf(
+			"Proof of work of difficulty %d exceeds the limit of %d",
+			req.Difficulty, opts.MaxDifficulty)
+	}
+	threads := opts.Threads
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	expected := expectedPowHashes(req.Difficulty)
+	if expected < 16*powBatchSize {
+		threads = 1 // not worth the overhead
+	}
+	interval := opts.ProgressInterval
+	if interval == 0 {
+		interval = 100 * time.Millisecond
+	}
+
+	s := sha2BDaySolver{
+		nonce: req.Nonce,
+		data:  data,
+		mask:  (uint64(1) << req.Difficulty) - 1,
+		found: make(chan []byte, threads),
+		stop:  make(chan struct{}),
+	}
+	for i := range s.shards {
+		s.shards[i].lut = make(map[uint64][2]uint64)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s.work(uint64(i+1), uint64(threads))
+		}(i)
+	}
+	defer func() {
+		close(s.stop)
+		wg.Wait()
+	}()
+
+	start := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case buf := <-s.found:
+			var proof pow.Proof
+			if err := proof.UnmarshalText([]byte(
+				base64.RawStdEncoding.EncodeToString(buf))); err != nil {
+				return nil, wrapErrorf(err, "pow.Proof.UnmarshalText()")
+			}
+			if !proof.Check(req, data) {
+				return nil, errorf("Computed proof of work is invalid")
+			}
+			return &proof, nil
+		case <-ctx.Done():
+			return nil, wrapErrorf(ctx.Err(), "Proof of work cancelled")
+		case <-ticker.C:
+			if opts.Progress == nil {
+				continue
+			}
+			progress := PowProgress{
+				Difficulty:     req.Difficulty,
+				Hashes:         atomic.LoadUint64(&s.hashes),
+				ExpectedHashes: expected,
+				Elapsed:        time.Since(start),
+			}
+			if progress.Hashes != 0 && progress.Hashes < expected {
+				progress.Remaining = time.Duration(float64(progress.Elapsed) *
+					float64(expected-progress.Hashes) / float64(progress.Hashes))
+			}
+			opts.Progress(progress)
+		}
+	}
+}
+
+// State of a multithreaded search for a triple sha256 collision
+// as required by pow.Sha2BDay.
+type sha2BDaySolver struct {
+	nonce, data []byte
+	mask        uint64
+
+	hashes uint64 // number of hashes computed; accessed atomically
+
+	// Lookup table from (masked) hash to the up to two prefixes seen with it.
+	shards [powShards]struct {
+		sync.Mutex
+		lut map[uint64][2]uint64
+	}
+
+	found chan []byte
+	stop  chan struct{}
+}
+
+// Tries the prefixes start, start+step, start+2*step, ...
+func (s *sha2BDaySolver) work(start, step uint64) {
+	var prefix [8]byte
+	var resBuf [32]byte
+	h := sha256.New()
+	for i := start; ; {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		for j := 0; j < powBatchSize; j++ {
+			binary.BigEndian.PutUint64(prefix[:], i)
+			h.Reset()
+			h.Write(prefix[:])
+			h.Write(s.data)
+			h.Write(s.nonce)
+			h.Sum(resBuf[:0])
+			res := binary.BigEndian.Uint64(resBuf[:]) & s.mask
+			if proof := s.insert(res, i); proof != nil {
+				s.found <- proof
+				return
+			}
+			i += step
+		}
+		atomic.AddUint64(&s.hashes, powBatchSize)
+	}
+}
+
+// Records that the prefix i has the given masked hash.  Returns the proof
+// of work if this completes a triple collision.
+func (s *sha2BDaySolver) insert(res, i uint64) []byte {
+	shard := &s.shards[res%powShards]
+	shard.Lock()
+	defer shard.Unlock()
+	pair, ok := shard.lut[res]
+	if !ok {
+		shard.lut[res] = [2]uint64{i, 0}
+		return nil
+	}
+	if pair[1] == 0 {
+		shard.lut[res] = [2]uint64{pair[0], i}
+		return nil
+	}
+	ret := make([]byte, 24)
+	binary.BigEndian.PutUint64(ret, pair[0])
+	binary.BigEndian.PutUint64(ret[8:], pair[1])
+	binary.BigEndian.PutUint64(ret[16:], i)
+	return ret
+}
@@ -0,0 +1,271 @@
+package server
+
+// Public key history and public key checks.
+
+import (
+	"github.com/bwesterb/go-atum"
+	"github.com/bwesterb/go-atum/stamper"
+
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var errNoMetaSigner = errors.New(
+	"No unrevoked key to sign key history or tree head")
+
+func (s *Server) keyHistoryPath() string {
+	return filepath.Join(s.cfg.DataDir, "keyhistory.json")
+}
+
+// Loads the key history from the data directory, if there is one.
+func (s *Server) loadKeyHistory() error {
+	if s.cfg.DataDir == "" {
+		return nil
+	}
+	buf, err := ioutil.ReadFile(s.keyHistoryPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, &s.history)
+}
+
+// Writes the key history to the data directory, if there is one.
+// Requires s.mux.
+func (s *Server) saveKeyHistory() error {
+	if s.cfg.DataDir == "" {
+		return nil
+	}
+	buf, err := json.MarshalIndent(s.history, "", " ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(s.keyHistoryPath(), buf)
+}
+
+// Writes the file by writing to a temporary file first, which is
+// renamed after it is synced to disk.
+func writeFileAtomically(path string, buf []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
+		0600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(buf); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Requires s.mux.
+func (s *Server) findKey(alg atum.SignatureAlgorithm,
+	pk []byte) *atum.KeyHistoryEntry {
+	for i := range s.history {
+		if s.history[i].Alg == alg && bytes.Equal(s.history[i].PublicKey, pk) {
+			return &s.history[i]
+		}
+	}
+	return nil
+}
+
+// Adds the public key to the key history, if it's not in there yet.
+//
+// A new key replaces the previous key for the same signature algorithm:
+// from now on (up to the acceptable lag) timestamps are set with the new key.
+//
+// Without a data directory the key history doesn't survive a restart, so
+// we can't tell since when the first key of an algorithm is in use.  It
+// is then considered valid from the start.
+func (s *Server) registerKey(alg atum.SignatureAlgorithm, pk []byte) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.findKey(alg, pk) != nil {
+		return nil
+	}
+
+	now := time.Now().Unix()
+	validFrom := now - s.cfg.AcceptableLag
+	first := true
+	for i := range s.history {
+		if s.history[i].Alg != alg {
+			continue
+		}
+		first = false
+		if s.history[i].ValidUntil == 0 {
+			s.history[i].ValidUntil = now + s.cfg.AcceptableLag + 1
+		}
+	}
+	if first && s.cfg.DataDir == "" {
+		validFrom = 0
+	}
+	s.history = append(s.history, atum.KeyHistoryEntry{
+		Alg:       alg,
+		PublicKey: pk,
+		ValidFrom: validFrom,
+	})
+	s.signedHistory = nil
+	return s.saveKeyHistory()
+}
+
+// Returns the key history of the server signed with one of its current keys.
+func (s *Server) KeyHistory() (*atum.KeyHistory, error) {
+	s.mux.Lock()
+	now := time.Now()
+	if s.signedHistory != nil && time.Unix(s.signedHistory.Expires, 0).Sub(
+		now) > s.cfg.CacheDuration/2 {
+		defer s.mux.Unlock()
+		return s.signedHistory, nil
+	}
+	history := atum.KeyHistory{
+		Keys:    append([]atum.KeyHistoryEntry(nil), s.history...),
+		Issued:  now.Unix(),
+		Expires: now.Add(s.cfg.CacheDuration).Unix(),
+	}
+	s.mux.Unlock()
+
+	signer, err := s.metaSigner()
+	if err != nil {
+		return nil, err
+	}
+	if kcSigner, ok := signer.(stamper.KeyChangingSigner); ok {
+		// The key might change while signing, in which case the history
+		// we prepared lacks the new key.  Thus we sign the message again
+		// if that happens.
+		for {
+			sig, pk, err := kcSigner.SignWithKey(history.SignedMessage())
+			if err != nil {
+				return nil, err
+			}
+			if history.Find(signer.Alg(), pk) != nil {
+				history.Sig = atum.Signature{
+					Alg:       signer.Alg(),
+					Data:      sig,
+					PublicKey: pk,
+				}
+				break
+			}
+			if err = s.registerKey(signer.Alg(), pk); err != nil {
+				return nil, err
+			}
+			s.mux.Lock()
+			history.Keys = append([]atum.KeyHistoryEntry(nil), s.history...)
+			s.mux.Unlock()
+		}
+	} else {
+		sig, err := signer.Sign(history.SignedMessage())
+		if err != nil {
+			return nil, err
+		}
+		history.Sig = atum.Signature{
+			Alg:       signer.Alg(),
+			Data:      sig,
+			PublicKey: signer.PublicKey(),
+		}
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.signedHistory = &history
+	return &history, nil
+}
+
+// Returns the signer used to sign the key history and tree heads.
+func (s *Server) metaSigner() (stamper.Signer, error) {
+	// Prefer a stateless signer.
+	signer := s.signers[atum.Ed25519]
+	if signer == nil || s.isRevoked(signer.Alg(), signer.PublicKey()) {
+		signer = s.signers[s.cfg.DefaultSigAlg]
+	}
+	if s.isRevoked(signer.Alg(), signer.PublicKey()) {
+		return nil, errNoMetaSigner
+	}
+	return signer, nil
+}
+
+func (s *Server) handleKeyHistory(w http.ResponseWriter, r *http.Request) {
+	history, err := s.KeyHistory()
+	if err == errNoMetaSigner {
+		// Clients will fall back to checkPublicKey.
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("atum server: failed to sign key history: %v", err)
+		http.Error(w, "Failed to sign key history",
+			http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, history)
+}
+
+// Checks whether the given public key belongs to the server.
+func (s *Server) CheckPublicKey(alg atum.SignatureAlgorithm,
+	pk []byte) atum.PublicKeyCheckResponse {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	entry := s.findKey(alg, pk)
+	resp := atum.PublicKeyCheckResponse{
+		Trusted: entry != nil && entry.RevokedAt == 0,
+		Expires: time.Now().Add(s.cfg.CacheDuration),
+	}
+	if entry != nil && entry.RevokedAt != 0 {
+		revokedAt := time.Unix(entry.RevokedAt, 0)
+		resp.Revoked = true
+		resp.RevokedAt = &revokedAt
+		resp.RevocationReason = entry.RevocationReason
+	}
+	return resp
+}
+
+// Revokes the given public key of the server as of the given time.
+//
+// If the key is retired, timestamps set before the revocation remain valid.
+// If the key is compromised, clients will reject all timestamps set with it.
+// The server won't set timestamps with a revoked key.
+func (s *Server) RevokeKey(alg atum.SignatureAlgorithm, pk []byte,
+	at time.Time, reason atum.RevocationReason) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	entry := s.findKey(alg, pk)
+	if entry == nil {
+		return fmt.Errorf("No such %s key", alg)
+	}
+	if entry.RevokedAt != 0 && entry.RevocationReason == atum.KeyCompromised {
+		return fmt.Errorf("Key has already been revoked as compromised")
+	}
+	entry.RevokedAt = at.Unix()
+	entry.RevocationReason = reason
+	s.signedHistory = nil
+	return s.saveKeyHistory()
+}
+
+// Returns whether the public key has been revoked.
+func (s *Server) isRevoked(alg atum.SignatureAlgorithm, pk []byte) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	entry := s.findKey(alg, pk)
+	return entry != nil && entry.RevokedAt != 0
+}
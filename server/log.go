@@ -0,0 +1,296 @@
+package server
+
+// Transparency log of issued timestamps.
+
+import (
+	"github.com/bwesterb/go-atum"
+	"github.com/bwesterb/go-atum/stamper"
+	"golang.org/x/crypto/ed25519"
+
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Maximum number of log entries returned in one response.
+	maxLogEntries = 1000
+
+	// Number of recently signed tree heads witnesses can cosign.
+	maxRecentHeads = 64
+)
+
+// Returns the signed tree head of the transparency log.  A new tree head
+// is signed if the log grew and the last one is older than the
+// TreeHeadInterval.
+func (s *Server) TreeHead() (*atum.TreeHead, error) {
+	s.logMux.Lock()
+	defer s.logMux.Unlock()
+	size := s.log.Size()
+	if s.treeHead != nil && (s.treeHead.Size == size ||
+		time.Since(time.Unix(s.treeHead.Time, 0)) < s.cfg.TreeHeadInterval) {
+		return s.treeHead, nil
+	}
+	root, err := s.log.RootHash(size)
+	if err != nil {
+		return nil, err
+	}
+	head := atum.TreeHead{
+		Size:     size,
+		RootHash: root,
+		Time:     time.Now().Unix(),
+	}
+	signer, err := s.metaSigner()
+	if err != nil {
+		return nil, err
+	}
+	pk := signer.PublicKey()
+	var sig []byte
+	if kcSigner, ok := signer.(stamper.KeyChangingSigner); ok {
+		sig, pk, err = kcSigner.SignWithKey(head.SignedMessage())
+	} else {
+		sig, err = signer.Sign(head.SignedMessage())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = s.registerKey(signer.Alg(), pk); err != nil {
+		return nil, err
+	}
+	head.Sig = atum.Signature{
+		Alg:       signer.Alg(),
+		Data:      sig,
+		PublicKey: pk,
+	}
+	s.treeHead = &head
+	s.recentHeads = append(s.recentHeads, head)
+	if len(s.recentHeads) > maxRecentHeads {
+		s.recentHeads = s.recentHeads[1:]
+	}
+	return &head, nil
+}
+
+func (s *Server) handleTreeHead(w http.ResponseWriter, r *http.Request) {
+	head, err := s.TreeHead()
+	if err != nil {
+		log.Printf("atum server: failed to sign tree head: %v", err)
+		http.Error(w, "Failed to sign tree head",
+			http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, head)
+}
+
+// Parses the given unsigned integer query parameters.
+func parseUintParams(r *http.Request, names ...string) ([]uint64, bool) {
+	q := r.URL.Query()
+	ret := make([]uint64, len(names))
+	for i, name := range names {
+		var err error
+		ret[i], err = strconv.ParseUint(q.Get(name), 10, 64)
+		if err != nil {
+			return nil, false
+		}
+	}
+	return ret, true
+}
+
+func (s *Server) handleInclusion(w http.ResponseWriter, r *http.Request) {
+	params, ok := parseUintParams(r, "index", "size")
+	if !ok {
+		http.Error(w, "Failed to parse index or size", http.StatusBadRequest)
+		return
+	}
+	proof, err := s.log.InclusionProof(params[0], params[1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, atum.LogProofResponse{Proof: proof})
+}
+
+func (s *Server) handleConsistency(w http.ResponseWriter, r *http.Request) {
+	params, ok := parseUintParams(r, "first", "second")
+	if !ok {
+		http.Error(w, "Failed to parse first or second", http.StatusBadRequest)
+		return
+	}
+	proof, err := s.log.ConsistencyProof(params[0], params[1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, atum.LogProofResponse{Proof: proof})
+}
+
+func (s *Server) handleEntries(w http.ResponseWriter, r *http.Request) {
+	params, ok := parseUintParams(r, "start", "end")
+	if !ok {
+		http.Error(w, "Failed to parse start or end", http.StatusBadRequest)
+		return
+	}
+	start, end := params[0], params[1]
+	if size := s.log.Size(); end > size {
+		end = size
+	}
+	if end > start && end-start > maxLogEntries {
+		end = start + maxLogEntries
+	}
+	entries, err := s.log.Entries(start, end)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, atum.LogEntriesResponse{Entries: entries})
+}
+
+func (s *Server) cosignedHeadPath() string {
+	return filepath.Join(s.cfg.DataDir, "cosignedtreehead.json")
+}
+
+// Loads the cosigned tree head from the data directory, if there is one.
+func (s *Server) loadCosignedHead() error {
+	if s.cfg.DataDir == "" {
+		return nil
+	}
+	buf, err := ioutil.ReadFile(s.cosignedHeadPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.cosignedHead = new(atum.TreeHead)
+	return json.Unmarshal(buf, s.cosignedHead)
+}
+
+// Returns the latest tree head cosigned by witnesses, if any.
+func (s *Server) CosignedTreeHead() *atum.TreeHead {
+	s.logMux.Lock()
+	defer s.logMux.Unlock()
+	if s.cosignedHead == nil {
+		return nil
+	}
+	ret := *s.cosignedHead
+	return &ret
+}
+
+func (s *Server) handleCosignedTreeHead(w http.ResponseWriter,
+	r *http.Request) {
+	head := s.CosignedTreeHead()
+	if head == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJson(w, head)
+}
+
+// Returns whether the two tree heads are the same, ignoring cosignatures.
+func sameTreeHead(a, b *atum.TreeHead) bool {
+	return a.Size == b.Size && a.Time == b.Time &&
+		bytes.Equal(a.RootHash, b.RootHash) &&
+		a.Sig.Alg == b.Sig.Alg && bytes.Equal(a.Sig.Data, b.Sig.Data) &&
+		bytes.Equal(a.Sig.PublicKey, b.Sig.PublicKey)
+}
+
+// Returns the cosignatures with the given cosignature added, replacing
+// an earlier cosignature of the same witness.
+func addCosignature(cosigs []atum.Cosignature,
+	cosig atum.Cosignature) []atum.Cosignature {
+	ret := []atum.Cosignature{cosig}
+	for _, other := range cosigs {
+		if !bytes.Equal(other.PublicKey, cosig.PublicKey) {
+			ret = append(ret, other)
+		}
+	}
+	return ret
+}
+
+// Adds the cosignature of a witness to one of the recently signed tree heads.
+//
+// A newer cosigned tree head is only published once it has at least as many
+// cosignatures as the currently published one, so that witnesses that
+// cosign different tree heads converge on the published one.
+func (s *Server) Cosign(serverUrl string, head atum.TreeHead,
+	cosig atum.Cosignature) error {
+	witness := false
+	for _, pk := range s.cfg.Witnesses {
+		if bytes.Equal(pk, cosig.PublicKey) {
+			witness = true
+		}
+	}
+	if !witness || len(cosig.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("Unknown witness")
+	}
+	if !ed25519.Verify(ed25519.PublicKey(cosig.PublicKey),
+		head.CosignedMessage(serverUrl, cosig.Time), cosig.Sig) {
+		return fmt.Errorf("Invalid cosignature")
+	}
+
+	s.logMux.Lock()
+	defer s.logMux.Unlock()
+
+	var recent *atum.TreeHead
+	for i := range s.recentHeads {
+		if sameTreeHead(&s.recentHeads[i], &head) {
+			recent = &s.recentHeads[i]
+		}
+	}
+	published := s.cosignedHead != nil && sameTreeHead(s.cosignedHead, &head)
+	if recent == nil && !published {
+		return fmt.Errorf("Unknown tree head")
+	}
+
+	if published {
+		s.cosignedHead.Cosignatures = addCosignature(
+			s.cosignedHead.Cosignatures, cosig)
+	}
+	if recent != nil {
+		recent.Cosignatures = addCosignature(recent.Cosignatures, cosig)
+		if s.cosignedHead == nil || (recent.Size > s.cosignedHead.Size &&
+			len(recent.Cosignatures) >= len(s.cosignedHead.Cosignatures)) {
+			newHead := *recent
+			s.cosignedHead = &newHead
+			published = true
+		}
+	}
+	if !published || s.cfg.DataDir == "" {
+		return nil
+	}
+	buf, err := json.Marshal(s.cosignedHead)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(s.cosignedHeadPath(), buf)
+}
+
+func (s *Server) handleCosign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Expected POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var req atum.CosignRequest
+	buf, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Failed to read request", http.StatusBadRequest)
+		return
+	}
+	if err = json.Unmarshal(buf, &req); err != nil {
+		http.Error(w, "Failed to parse request", http.StatusBadRequest)
+		return
+	}
+	serverUrl := strings.TrimSuffix(s.serverUrl(r), "log/cosign")
+	if err = s.Cosign(serverUrl, req.TreeHead, req.Cosignature); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, s.CosignedTreeHead())
+}
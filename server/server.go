@@ -0,0 +1,413 @@
+// Run an Atum server.
+//
+// This package implements the server side of the Atum protocol (as described
+// in the README) as a http.Handler on top of the Signers of
+// github.com/bwesterb/go-atum/stamper.  If you just want to request an Atum
+// timestamp, use github.com/bwesterb/go-atum.
+package server
+
+import (
+	"github.com/bwesterb/go-atum"
+	"github.com/bwesterb/go-atum/stamper"
+	"github.com/bwesterb/go-atum/tlog"
+	"github.com/bwesterb/go-pow"
+
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Configuration of an Atum server.  See New().
+type Config struct {
+	// The url at which the server is reachable, which is put in the
+	// timestamps.  If empty, it's derived from the incoming requests.
+	Url string
+
+	// The signers for the supported signature algorithms; at most one
+	// per algorithm.
+	Signers []stamper.Signer
+
+	// The default signature algorithm.  Defaults to the algorithm of the
+	// first signer.
+	DefaultSigAlg atum.SignatureAlgorithm
+
+	// The maximum size of nonce accepted.  Defaults to 128.
+	MaxNonceSize int64
+
+	// Maximum lag to accept in number of seconds.  Defaults to 60.
+	AcceptableLag int64
+
+	// Difficulty of the proof of work required for the given signature
+	// algorithms.  The proof of work nonce changes daily.
+	PowDifficulty map[atum.SignatureAlgorithm]uint32
+
+	// Directory in which the server keeps its state, such as the public
+	// key history.  If empty, the state is only kept in memory, and the
+	// key history can't tell since when the initial keys are in use.
+	DataDir string
+
+	// How long clients may cache public key checks and the key history.
+	// Defaults to a day.
+	CacheDuration time.Duration
+
+	// Minimum time between two signed tree heads of the transparency log.
+	// Defaults to 0: a new tree head is signed whenever the log grew.
+	// With several witnesses, set it to (a fraction of) their interval, so
+	// that they cosign the same tree heads.
+	TreeHeadInterval time.Duration
+
+	// Ed25519 public keys of the witnesses whose cosignatures on tree heads
+	// are accepted.
+	Witnesses [][]byte
+}
+
+// An Atum server.  Use New() to create one.
+type Server struct {
+	cfg     Config
+	signers map[atum.SignatureAlgorithm]stamper.Signer
+
+	powSecret []byte // secret from which the daily pow nonces are derived
+
+	mux           sync.Mutex
+	history       []atum.KeyHistoryEntry
+	signedHistory *atum.KeyHistory
+
+	log          *tlog.Log
+	logMux       sync.Mutex // protects the fields below
+	treeHead     *atum.TreeHead
+	recentHeads  []atum.TreeHead // recently signed tree heads
+	cosignedHead *atum.TreeHead
+}
+
+// Creates a new Atum server with the given configuration.
+//
+// NOTE Do not forget to Close() the server.
+func New(cfg Config) (*Server, error) {
+	if len(cfg.Signers) == 0 {
+		return nil, fmt.Errorf("No signers configured")
+	}
+	if cfg.DefaultSigAlg == "" {
+		cfg.DefaultSigAlg = cfg.Signers[0].Alg()
+	}
+	if cfg.MaxNonceSize == 0 {
+		cfg.MaxNonceSize = 128
+	}
+	if cfg.AcceptableLag == 0 {
+		cfg.AcceptableLag = 60
+	}
+	if cfg.CacheDuration == 0 {
+		cfg.CacheDuration = 24 * time.Hour
+	}
+
+	s := &Server{
+		cfg:       cfg,
+		signers:   make(map[atum.SignatureAlgorithm]stamper.Signer),
+		powSecret: make([]byte, 32),
+	}
+	if _, err := rand.Read(s.powSecret); err != nil {
+		return nil, err
+	}
+	for _, signer := range cfg.Signers {
+		if _, ok := s.signers[signer.Alg()]; ok {
+			return nil, fmt.Errorf("Multiple signers for %s", signer.Alg())
+		}
+		s.signers[signer.Alg()] = signer
+	}
+	if _, ok := s.signers[cfg.DefaultSigAlg]; !ok {
+		return nil, fmt.Errorf("No signer for default algorithm %s",
+			cfg.DefaultSigAlg)
+	}
+
+	if err := s.loadKeyHistory(); err != nil {
+		return nil, err
+	}
+	for _, signer := range cfg.Signers {
+		if err := s.registerKey(signer.Alg(), signer.PublicKey()); err != nil {
+			return nil, err
+		}
+	}
+
+	logPath := ""
+	if cfg.DataDir != "" {
+		if err := os.MkdirAll(cfg.DataDir, 0700); err != nil {
+			return nil, err
+		}
+		logPath = filepath.Join(cfg.DataDir, "log")
+	}
+	var err error
+	if s.log, err = tlog.OpenLog(logPath); err != nil {
+		return nil, err
+	}
+	if err = s.loadCosignedHead(); err != nil {
+		s.log.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Closes the transparency log of the server.
+func (s *Server) Close() error {
+	return s.log.Close()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/checkPublicKey"):
+		s.handleCheckPublicKey(w, r)
+	case strings.HasSuffix(r.URL.Path, "/keyHistory"):
+		s.handleKeyHistory(w, r)
+	case strings.HasSuffix(r.URL.Path, "/log/treeHead"):
+		s.handleTreeHead(w, r)
+	case strings.HasSuffix(r.URL.Path, "/log/inclusion"):
+		s.handleInclusion(w, r)
+	case strings.HasSuffix(r.URL.Path, "/log/consistency"):
+		s.handleConsistency(w, r)
+	case strings.HasSuffix(r.URL.Path, "/log/entries"):
+		s.handleEntries(w, r)
+	case strings.HasSuffix(r.URL.Path, "/log/cosignedTreeHead"):
+		s.handleCosignedTreeHead(w, r)
+	case strings.HasSuffix(r.URL.Path, "/log/cosign"):
+		s.handleCosign(w, r)
+	case r.Method == http.MethodPost:
+		s.handleStamp(w, r)
+	default:
+		writeJson(w, s.Info())
+	}
+}
+
+// Returns the proof of work nonce for the given day.
+func (s *Server) powNonce(day int64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(day))
+	h := sha256.New()
+	h.Write(s.powSecret)
+	h.Write(buf[:])
+	return h.Sum(nil)[:16]
+}
+
+func (s *Server) powRequest(alg atum.SignatureAlgorithm, day int64) (
+	pow.Request, bool) {
+	diff, ok := s.cfg.PowDifficulty[alg]
+	if !ok || diff == 0 {
+		return pow.Request{}, false
+	}
+	return pow.Request{
+		Alg:        pow.Sha2BDay,
+		Difficulty: diff,
+		Nonce:      s.powNonce(day),
+	}, true
+}
+
+// Returns the server information published by the server.
+func (s *Server) Info() atum.ServerInfo {
+	day := time.Now().Unix() / 86400
+	info := atum.ServerInfo{
+		MaxNonceSize:        s.cfg.MaxNonceSize,
+		AcceptableLag:       s.cfg.AcceptableLag,
+		DefaultSigAlg:       s.cfg.DefaultSigAlg,
+		RequiredProofOfWork: make(map[atum.SignatureAlgorithm]pow.Request),
+	}
+	for alg := range s.signers {
+		info.SigAlgs = append(info.SigAlgs, alg)
+		if powReq, ok := s.powRequest(alg, day); ok {
+			info.RequiredProofOfWork[alg] = powReq
+		}
+	}
+	sort.Slice(info.SigAlgs, func(i, j int) bool {
+		return info.SigAlgs[i] < info.SigAlgs[j]
+	})
+	return info
+}
+
+// Returns the signature algorithms to use for the request.
+func (s *Server) sigAlgs(req *atum.Request) []atum.SignatureAlgorithm {
+	var ret []atum.SignatureAlgorithm
+	for _, alg := range req.SigAlgs {
+		if _, ok := s.signers[alg]; ok {
+			ret = append(ret, alg)
+		}
+	}
+	if len(ret) != 0 {
+		return ret
+	}
+	if req.PreferredSigAlg != nil {
+		if _, ok := s.signers[*req.PreferredSigAlg]; ok {
+			return []atum.SignatureAlgorithm{*req.PreferredSigAlg}
+		}
+	}
+	return []atum.SignatureAlgorithm{s.cfg.DefaultSigAlg}
+}
+
+// Checks the proof of work on the request, if required.
+func (s *Server) checkPow(req *atum.Request, algs []atum.SignatureAlgorithm,
+	msg []byte) *atum.ErrorCode {
+	var diff uint32
+	for _, alg := range algs {
+		if s.cfg.PowDifficulty[alg] > diff {
+			diff = s.cfg.PowDifficulty[alg]
+		}
+	}
+	if diff == 0 {
+		return nil
+	}
+	if req.ProofOfWork == nil {
+		code := atum.ErrorMissingPow
+		return &code
+	}
+
+	// Accept yesterday's nonce as well, in case the client's server
+	// information is a bit stale.
+	day := time.Now().Unix() / 86400
+	for _, d := range []int64{day, day - 1} {
+		powReq := pow.Request{
+			Alg:        pow.Sha2BDay,
+			Difficulty: diff,
+			Nonce:      s.powNonce(d),
+		}
+		if req.ProofOfWork.Check(powReq, msg) {
+			return nil
+		}
+	}
+	code := atum.ErrorPowInvalid
+	return &code
+}
+
+func (s *Server) handleStamp(w http.ResponseWriter, r *http.Request) {
+	var req atum.Request
+	var resp atum.Response
+
+	buf, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Failed to read request", http.StatusBadRequest)
+		return
+	}
+	if err = json.Unmarshal(buf, &req); err != nil {
+		http.Error(w, "Failed to parse request", http.StatusBadRequest)
+		return
+	}
+
+	ts, logIndex, code := s.stamp(&req, s.serverUrl(r))
+	if code != nil {
+		resp.SetError(*code)
+		info := s.Info()
+		resp.Info = &info
+	} else {
+		resp.LogIndex = &logIndex
+	}
+	resp.Stamp = ts
+	writeJson(w, resp)
+}
+
+// Handles a timestamp request.  Returns either the timestamp together with
+// its index in the transparency log, or an error code.
+func (s *Server) stamp(req *atum.Request, serverUrl string) (
+	*atum.Timestamp, uint64, *atum.ErrorCode) {
+	if len(req.Nonce) == 0 {
+		code := atum.ErrorMissingNonce
+		return nil, 0, &code
+	}
+	if int64(len(req.Nonce)) > s.cfg.MaxNonceSize {
+		code := atum.ErrorNonceTooLong
+		return nil, 0, &code
+	}
+
+	now := time.Now().Unix()
+	theTime := now
+	if req.Time != nil {
+		theTime = *req.Time
+		if theTime > now+s.cfg.AcceptableLag ||
+			theTime < now-s.cfg.AcceptableLag {
+			code := atum.ErrorCodeLag
+			return nil, 0, &code
+		}
+	}
+
+	algs := s.sigAlgs(req)
+	if code := s.checkPow(req, algs,
+		atum.EncodeTimeNonce(theTime, req.Nonce)); code != nil {
+		return nil, 0, code
+	}
+
+	signers := make([]stamper.Signer, len(algs))
+	for i, alg := range algs {
+		signers[i] = s.signers[alg]
+		if s.isRevoked(alg, signers[i].PublicKey()) {
+			log.Printf("atum server: refusing to sign with revoked %s key", alg)
+			code := atum.ErrorInternal
+			return nil, 0, &code
+		}
+	}
+	ts, err := stamper.StampHybrid(signers, theTime, req.Nonce)
+	if err != nil {
+		log.Printf("atum server: failed to create timestamp: %v", err)
+		code := atum.ErrorInternal
+		return nil, 0, &code
+	}
+	ts.ServerUrl = serverUrl
+
+	// A signer might have rolled over to a new key.
+	for _, sig := range ts.Signatures() {
+		if err := s.registerKey(sig.Alg, sig.PublicKey); err != nil {
+			log.Printf("atum server: failed to register key: %v", err)
+			code := atum.ErrorInternal
+			return nil, 0, &code
+		}
+	}
+
+	// Only hand out the timestamp once it is in the transparency log.
+	logIndex, err := s.log.Append(ts.LogEntry(req.Nonce))
+	if err != nil {
+		log.Printf("atum server: failed to append to transparency log: %v",
+			err)
+		code := atum.ErrorInternal
+		return nil, 0, &code
+	}
+
+	return ts, logIndex, nil
+}
+
+func (s *Server) handleCheckPublicKey(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	pk, err := hex.DecodeString(q.Get("pk"))
+	if err != nil {
+		http.Error(w, "Failed to parse pk", http.StatusBadRequest)
+		return
+	}
+	writeJson(w, s.CheckPublicKey(atum.SignatureAlgorithm(q.Get("alg")), pk))
+}
+
+// Returns the server url, which is either configured or derived from
+// the request.
+func (s *Server) serverUrl(r *http.Request) string {
+	if s.cfg.Url != "" {
+		return s.cfg.Url
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.Path)
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Failed to create response",
+			http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf)
+}
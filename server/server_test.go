@@ -0,0 +1,110 @@
+package server_test
+
+import (
+	"github.com/bwesterb/go-atum"
+	"github.com/bwesterb/go-atum/server"
+	"github.com/bwesterb/go-atum/stamper"
+
+	"golang.org/x/crypto/ed25519"
+
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// A handler that passes requests on to a handler that can be swapped,
+// so that a restarted server can be served at the same url.
+type swapHandler struct {
+	mux sync.Mutex
+	h   http.Handler
+}
+
+func (s *swapHandler) set(h http.Handler) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.h = h
+}
+
+func (s *swapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.Lock()
+	h := s.h
+	s.mux.Unlock()
+	h.ServeHTTP(w, r)
+}
+
+func TestReload(t *testing.T) {
+	atum.SetCache(atum.NewBoltCache(filepath.Join(t.TempDir(), "cache.bolt")))
+	_, sk, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := server.Config{
+		Signers: []stamper.Signer{stamper.NewEd25519Signer(sk)},
+		DataDir: t.TempDir(),
+	}
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh := &swapHandler{h: srv}
+	hs := httptest.NewServer(sh)
+	defer hs.Close()
+
+	var stamps []*atum.Timestamp
+	stamp := func() {
+		nonce := []byte(fmt.Sprintf("nonce %d", len(stamps)))
+		ts, err := atum.SendRequest(hs.URL, atum.Request{Nonce: nonce})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stamps = append(stamps, ts)
+	}
+	for i := 0; i < 3; i++ {
+		stamp()
+	}
+	head, err := atum.FetchTreeHead(hs.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Restart the server.
+	if err = srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if srv, err = server.New(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	sh.set(srv)
+
+	head2, err := atum.FetchTreeHead(hs.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head2.Size != head.Size || !bytes.Equal(head2.RootHash, head.RootHash) {
+		t.Fatalf("log of size %d became log of size %d", head.Size,
+			head2.Size)
+	}
+
+	// The client checks the new tree head is consistent with the old one.
+	stamp()
+	if head2, err = atum.FetchTreeHead(hs.URL); err != nil {
+		t.Fatal(err)
+	}
+	if head2.Size != head.Size+1 {
+		t.Fatalf("log has size %d instead of %d", head2.Size, head.Size+1)
+	}
+
+	policy := atum.VerificationPolicy{RequireLogProof: true}
+	for i, ts := range stamps {
+		nonce := []byte(fmt.Sprintf("nonce %d", i))
+		valid, err := ts.VerifyFromWithPolicy(bytes.NewReader(nonce), policy)
+		if err != nil || !valid {
+			t.Fatalf("timestamp %d rejected after restart: %v", i, err)
+		}
+	}
+}
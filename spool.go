@@ -0,0 +1,375 @@
+package atum
+
+import (
+	bolt "go.etcd.io/bbolt"
+
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	spoolPendingBucket = []byte("pending")
+	spoolDoneBucket    = []byte("done")
+	spoolFailedBucket  = []byte("failed")
+)
+
+// Options for a Spool.  See OpenSpool().
+type SpoolOptions struct {
+	// The Atum server to request the timestamps from
+	ServerUrl string
+
+	// Options for the requests, such as the proof of work.
+	RequestOptions RequestOptions
+
+	// If set, called (from the worker goroutine) for every timestamp
+	// that is received.
+	//
+	// NOTE If we crash right after receiving a timestamp, the callback might
+	//      not be called for it.  It can still be retrieved with Get().
+	OnStamped func(id uint64, ts *Timestamp)
+
+	// If set, called (from the worker goroutine) for every request that
+	// failed permanently: the server reported an error that retrying
+	// won't fix, such as a nonce that is too long (see ErrorCode.Permanent()),
+	// or the request stored in the spool is corrupt.
+	OnFailed func(id uint64, err Error)
+
+	// Time to wait before retrying a failed request.  It doubles with every
+	// failed attempt up to MaxRetryInterval.  Defaults to 5 seconds.
+	RetryInterval time.Duration
+
+	// Defaults to 10 minutes.
+	MaxRetryInterval time.Duration
+}
+
+// A request in the spool.
+type spoolEntry struct {
+	Request   Request
+	Enqueued  time.Time
+	Attempts  int
+	LastError string     `json:",omitempty"`
+	NextTry   time.Time  `json:",omitempty"`
+	Stamp     *Timestamp `json:",omitempty"`
+}
+
+// A durable queue of timestamp requests, stored in a bolt database.
+//
+// Enqueue() returns as soon as the request is written to disk.  A background
+// worker sends the requests to the Atum server, retrying failed requests, so
+// that pending requests survive crashes and server outages.  Timestamps
+// are delivered through SpoolOptions.OnStamped and can be retrieved
+// with Get().  Requests the server rejects for good are not retried, but
+// kept aside: Get() returns their error.  Use OpenSpool() to create one.
+type Spool struct {
+	db   *bolt.DB
+	opts SpoolOptions
+
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Opens (or creates) the spool stored at the given path and starts sending
+// the pending requests in it.
+//
+// NOTE Do not forget to Close() the spool.
+func OpenSpool(path string, opts SpoolOptions) (*Spool, Error) {
+	if opts.ServerUrl == "" {
+		return nil, errorf("No server url configured")
+	}
+	if opts.RetryInterval == 0 {
+		opts.RetryInterval = 5 * time.Second
+	}
+	if opts.MaxRetryInterval == 0 {
+		opts.MaxRetryInterval = 10 * time.Minute
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, wrapErrorf(err, "bolt.Open(%s)", path)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{spoolPendingBucket, spoolDoneBucket,
+			spoolFailedBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, wrapErrorf(err, "Failed to initialize spool")
+	}
+	s := &Spool{
+		db:   db,
+		opts: opts,
+		wake: make(chan struct{}, 1),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go s.work()
+	return s, nil
+}
+
+func spoolKey(id uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], id)
+	return buf[:]
+}
+
+// Stops the worker and closes the spool.  Pending requests are sent when
+// the spool is opened again.
+func (s *Spool) Close() Error {
+	s.cancel()
+	s.wg.Wait()
+	if err := s.db.Close(); err != nil {
+		return wrapErrorf(err, "bolt.DB.Close()")
+	}
+	return nil
+}
+
+// Adds a request for a timestamp on the nonce to the spool.  Returns the ID
+// with which the timestamp can be retrieved.
+func (s *Spool) Enqueue(nonce []byte) (uint64, Error) {
+	return s.EnqueueRequest(Request{Nonce: nonce})
+}
+
+// Like Enqueue(), but with a full request.
+//
+// If the time of the request is left empty, the timestamp is set at
+// the moment the request is sent.
+func (s *Spool) EnqueueRequest(req Request) (uint64, Error) {
+	var id uint64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(spoolPendingBucket)
+		var err error
+		id, err = bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		buf, err := json.Marshal(spoolEntry{
+			Request:  req,
+			Enqueued: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		return bucket.Put(spoolKey(id), buf)
+	})
+	if err != nil {
+		return 0, wrapErrorf(err, "Failed to store request in spool")
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return id, nil
+}
+
+// Returns the timestamp with the given ID, or nil if it has not been
+// received yet.  Returns an error if the request failed permanently.
+func (s *Spool) Get(id uint64) (*Timestamp, Error) {
+	var ret *Timestamp
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if buf := tx.Bucket(spoolDoneBucket).Get(spoolKey(id)); buf != nil {
+			var entry spoolEntry
+			if err := json.Unmarshal(buf, &entry); err != nil {
+				return err
+			}
+			ret = entry.Stamp
+			return nil
+		}
+		if buf := tx.Bucket(spoolFailedBucket).Get(spoolKey(id)); buf != nil {
+			var entry spoolEntry
+			if err := json.Unmarshal(buf, &entry); err != nil {
+				return err
+			}
+			return errorf("Request %d failed: %s", id, entry.LastError)
+		}
+		if tx.Bucket(spoolPendingBucket).Get(spoolKey(id)) == nil {
+			return errorf("No request with ID %d in spool", id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, wrapErrorf(err, "Spool.Get()")
+	}
+	return ret, nil
+}
+
+// Forgets the received timestamp or failed request with the given ID.
+func (s *Spool) Remove(id uint64) Error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(spoolDoneBucket).Delete(spoolKey(id)); err != nil {
+			return err
+		}
+		return tx.Bucket(spoolFailedBucket).Delete(spoolKey(id))
+	})
+	if err != nil {
+		return wrapErrorf(err, "Spool.Remove()")
+	}
+	return nil
+}
+
+// Returns the number of requests for which we did not receive
+// a timestamp yet.
+func (s *Spool) Pending() int {
+	var ret int
+	s.db.View(func(tx *bolt.Tx) error {
+		ret = tx.Bucket(spoolPendingBucket).Stats().KeyN
+		return nil
+	})
+	return ret
+}
+
+// Returns the number of requests that failed permanently and have not
+// been removed yet.
+func (s *Spool) Failed() int {
+	var ret int
+	s.db.View(func(tx *bolt.Tx) error {
+		ret = tx.Bucket(spoolFailedBucket).Stats().KeyN
+		return nil
+	})
+	return ret
+}
+
+// Sends the pending requests until the spool is closed.
+func (s *Spool) work() {
+	defer s.wg.Done()
+	for {
+		next, ok := s.sendPending()
+		if !ok {
+			return
+		}
+		var timer <-chan time.Time
+		if !next.IsZero() {
+			timer = time.After(time.Until(next))
+		}
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.wake:
+		case <-timer:
+		}
+	}
+}
+
+// Sends the pending requests that are due.  Returns when the next request
+// is due (or zero, if there are none) and false if the spool is closed.
+func (s *Spool) sendPending() (next time.Time, ok bool) {
+	type pending struct {
+		id    uint64
+		entry spoolEntry
+	}
+	var due, corrupt []pending
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(spoolPendingBucket).ForEach(func(k, v []byte) error {
+			var p pending
+			p.id = binary.BigEndian.Uint64(k)
+			if err := json.Unmarshal(v, &p.entry); err != nil {
+				// Don't let a corrupt entry hold up the others.
+				p.entry = spoolEntry{LastError: fmt.Sprintf(
+					"Failed to parse request in spool: %v", err)}
+				corrupt = append(corrupt, p)
+				return nil
+			}
+			due = append(due, p)
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("atum spool: %v", err)
+		return time.Now().Add(s.opts.RetryInterval), true
+	}
+
+	for _, p := range corrupt {
+		log.Printf("atum spool: request %d: %s", p.id, p.entry.LastError)
+		if err := s.update(p.id, &p.entry, spoolFailedBucket); err != nil {
+			log.Printf("atum spool: %v", err)
+			continue
+		}
+		if s.opts.OnFailed != nil {
+			s.opts.OnFailed(p.id, errorf("%s", p.entry.LastError))
+		}
+	}
+
+	for _, p := range due {
+		if s.ctx.Err() != nil {
+			return time.Time{}, false
+		}
+		if p.entry.NextTry.After(time.Now()) {
+			if next.IsZero() || p.entry.NextTry.Before(next) {
+				next = p.entry.NextTry
+			}
+			continue
+		}
+		ts, err := SendRequestContext(s.ctx, s.opts.ServerUrl,
+			p.entry.Request, s.opts.RequestOptions)
+		if s.ctx.Err() != nil {
+			return time.Time{}, false
+		}
+		if err != nil {
+			p.entry.Attempts++
+			p.entry.LastError = err.Error()
+			if serr, ok := err.(*ServerError); ok && serr.Code.Permanent() {
+				if err2 := s.update(p.id, &p.entry,
+					spoolFailedBucket); err2 != nil {
+					log.Printf("atum spool: %v", err2)
+					continue
+				}
+				if s.opts.OnFailed != nil {
+					s.opts.OnFailed(p.id, err)
+				}
+				continue
+			}
+			wait := s.opts.RetryInterval << uint(p.entry.Attempts-1)
+			if wait > s.opts.MaxRetryInterval || wait <= 0 {
+				wait = s.opts.MaxRetryInterval
+			}
+			p.entry.NextTry = time.Now().Add(wait)
+			if next.IsZero() || p.entry.NextTry.Before(next) {
+				next = p.entry.NextTry
+			}
+			if err2 := s.update(p.id, &p.entry,
+				spoolPendingBucket); err2 != nil {
+				log.Printf("atum spool: %v", err2)
+			}
+			continue
+		}
+		p.entry.Stamp = ts
+		p.entry.LastError = ""
+		if err2 := s.update(p.id, &p.entry, spoolDoneBucket); err2 != nil {
+			log.Printf("atum spool: %v", err2)
+			continue
+		}
+		if s.opts.OnStamped != nil {
+			s.opts.OnStamped(p.id, ts)
+		}
+	}
+	return next, true
+}
+
+// Stores the entry in the given bucket, removing it from the pending
+// bucket if it's done or failed.
+func (s *Spool) update(id uint64, entry *spoolEntry, bucket []byte) error {
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucket).Put(spoolKey(id), buf); err != nil {
+			return err
+		}
+		if bytes.Equal(bucket, spoolPendingBucket) {
+			return nil
+		}
+		return tx.Bucket(spoolPendingBucket).Delete(spoolKey(id))
+	})
+}
@@ -0,0 +1,237 @@
+package atum_test
+
+import (
+	"github.com/bwesterb/go-atum"
+	"github.com/bwesterb/go-atum/server"
+
+	bolt "go.etcd.io/bbolt"
+
+	"bytes"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Answers all requests as if the server is down.
+var unavailableHandler = http.HandlerFunc(func(w http.ResponseWriter,
+	r *http.Request) {
+	http.Error(w, "Down for maintenance", http.StatusServiceUnavailable)
+})
+
+// Records the IDs passed to the OnStamped and OnFailed callbacks of a spool.
+type spoolEvents struct {
+	stamped chan uint64
+	failed  chan uint64
+}
+
+func newSpoolEvents(opts *atum.SpoolOptions) *spoolEvents {
+	ev := &spoolEvents{
+		stamped: make(chan uint64, 10),
+		failed:  make(chan uint64, 10),
+	}
+	opts.OnStamped = func(id uint64, ts *atum.Timestamp) { ev.stamped <- id }
+	opts.OnFailed = func(id uint64, err atum.Error) { ev.failed <- id }
+	return ev
+}
+
+func waitFor(t *testing.T, c chan uint64, what string) uint64 {
+	select {
+	case id := <-c:
+		return id
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for %s request", what)
+		return 0
+	}
+}
+
+func openSpool(t *testing.T, path string, opts atum.SpoolOptions) *atum.Spool {
+	s, err := atum.OpenSpool(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// Checks the spool holds a valid timestamp on the nonce with the given ID.
+func checkSpooled(t *testing.T, s *atum.Spool, id uint64, nonce []byte) {
+	ts, err := s.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts == nil {
+		t.Fatalf("no timestamp for request %d", id)
+	}
+	valid, err := ts.Verify(nonce)
+	if err != nil || !valid {
+		t.Fatalf("timestamp of request %d rejected: %v", id, err)
+	}
+}
+
+func TestSpoolReopen(t *testing.T) {
+	srv := newServer(t, server.Config{})
+	sh := &swapHandler{h: unavailableHandler}
+	url, _ := serve(t, sh)
+	path := filepath.Join(t.TempDir(), "spool.bolt")
+	opts := atum.SpoolOptions{
+		ServerUrl:        url,
+		RetryInterval:    10 * time.Millisecond,
+		MaxRetryInterval: 50 * time.Millisecond,
+	}
+
+	s := openSpool(t, path, opts)
+	nonce := []byte("some nonce")
+	id, err := s.Enqueue(nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The request is sent once the spool is reopened and the server is up.
+	sh.set(srv)
+	ev := newSpoolEvents(&opts)
+	s = openSpool(t, path, opts)
+	defer s.Close()
+	if got := waitFor(t, ev.stamped, "stamped"); got != id {
+		t.Fatalf("request %d stamped instead of %d", got, id)
+	}
+	checkSpooled(t, s, id, nonce)
+	if s.Pending() != 0 {
+		t.Fatalf("%d requests pending", s.Pending())
+	}
+}
+
+func TestSpoolPermanentError(t *testing.T) {
+	opts := atum.SpoolOptions{ServerUrl: startServer(t, server.Config{})}
+	ev := newSpoolEvents(&opts)
+	s := openSpool(t, filepath.Join(t.TempDir(), "spool.bolt"), opts)
+	defer s.Close()
+
+	badId, err := s.Enqueue(bytes.Repeat([]byte("x"), 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := []byte("some nonce")
+	id, err := s.Enqueue(nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := waitFor(t, ev.failed, "failed"); got != badId {
+		t.Fatalf("request %d failed instead of %d", got, badId)
+	}
+	if got := waitFor(t, ev.stamped, "stamped"); got != id {
+		t.Fatalf("request %d stamped instead of %d", got, id)
+	}
+	checkSpooled(t, s, id, nonce)
+	if _, err := s.Get(badId); err == nil {
+		t.Fatal("failed request has no error")
+	}
+	if s.Failed() != 1 || s.Pending() != 0 {
+		t.Fatalf("%d requests failed and %d pending", s.Failed(), s.Pending())
+	}
+	if err := s.Remove(badId); err != nil {
+		t.Fatal(err)
+	}
+	if s.Failed() != 0 {
+		t.Fatalf("%d requests failed after removal", s.Failed())
+	}
+}
+
+func TestSpoolCorruptEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool.bolt")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket([]byte("pending"))
+		if err != nil {
+			return err
+		}
+		if err = bucket.SetSequence(1); err != nil {
+			return err
+		}
+		return bucket.Put([]byte{0, 0, 0, 0, 0, 0, 0, 1}, []byte("garbage"))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := atum.SpoolOptions{ServerUrl: startServer(t, server.Config{})}
+	ev := newSpoolEvents(&opts)
+	s := openSpool(t, path, opts)
+	defer s.Close()
+	nonce := []byte("some nonce")
+	id, err := s.Enqueue(nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The corrupt entry is set aside and doesn't block the other.
+	if got := waitFor(t, ev.failed, "failed"); got != 1 {
+		t.Fatalf("request %d failed instead of 1", got)
+	}
+	if got := waitFor(t, ev.stamped, "stamped"); got != id {
+		t.Fatalf("request %d stamped instead of %d", got, id)
+	}
+	checkSpooled(t, s, id, nonce)
+	if _, err := s.Get(1); err == nil ||
+		!strings.Contains(err.Error(), "parse") {
+		t.Fatalf("corrupt request has wrong error: %v", err)
+	}
+}
+
+// Records the times of the requests it receives, and fails them.
+type recordingHandler struct {
+	mux   sync.Mutex
+	times []time.Time
+	done  chan struct{}
+	n     int // close done after this many requests
+}
+
+func (h *recordingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.Lock()
+	h.times = append(h.times, time.Now())
+	if len(h.times) == h.n {
+		close(h.done)
+	}
+	h.mux.Unlock()
+	unavailableHandler(w, r)
+}
+
+func TestSpoolBackoff(t *testing.T) {
+	h := &recordingHandler{done: make(chan struct{}), n: 5}
+	url, _ := serve(t, h)
+	interval := 20 * time.Millisecond
+	s := openSpool(t, filepath.Join(t.TempDir(), "spool.bolt"),
+		atum.SpoolOptions{
+			ServerUrl:        url,
+			RetryInterval:    interval,
+			MaxRetryInterval: 4 * interval,
+		})
+	defer s.Close()
+	if _, err := s.Enqueue([]byte("some nonce")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-h.done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for retries")
+	}
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	for i, want := range []time.Duration{interval, 2 * interval,
+		4 * interval, 4 * interval} {
+		if got := h.times[i+1].Sub(h.times[i]); got < want {
+			t.Fatalf("attempt %d came %v after the previous instead of %v",
+				i+2, got, want)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package stamper
+
+import (
+	"github.com/bwesterb/go-xmssmt"
+
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Options for a KeyStore.  See OpenKeyStore().
+type KeyStoreOptions struct {
+	// The XMSS[MT] instance used for newly generated keys.
+	// Defaults to XMSSMT-SHA2_40/2_512.
+	Params string
+
+	// A warning is issued when the key in use has fewer signatures left.
+	// Defaults to 1% of the signatures of a key.
+	WarnThreshold uint64
+
+	// Called (once per key) when the key in use has fewer than WarnThreshold
+	// signatures left.  Logs a message if nil.
+	Warn func(remaining uint64)
+
+	// When the key in use has this many signatures left (or fewer), a fresh
+	// key is generated and used instead.  Defaults to 0: the key is only
+	// replaced when it is exhausted.
+	RolloverThreshold uint64
+}
+
+// Manages the XMSS[MT] keys of an Atum server stored in a directory.
+//
+// The keys are stored as <dir>/xmssmt-<n> together with the cache and
+// lock files maintained by go-xmssmt.  The key with the highest <n> is
+// the one in use: older keys are kept, but never used again.
+//
+// Reusing an XMSS[MT] signature index is catastrophic.  A KeyStore relies
+// on go-xmssmt to write (and fsync) the signature sequence number to disk
+// before a signature is created, and generates new keys under a temporary
+// name before moving them into place, so that a crash at any point never
+// causes a signature index to be reused.
+type KeyStore struct {
+	dir  string
+	opts KeyStoreOptions
+
+	mux    sync.Mutex
+	sk     *xmssmt.PrivateKey
+	pk     []byte // serialized public key of sk
+	n      int    // index of the key in use
+	warned bool   // whether we warned about the key in use
+}
+
+// Opens the KeyStore in the given directory, creating the directory
+// and a first key, if necessary.
+//
+// NOTE Do not forget to Close() the KeyStore.
+func OpenKeyStore(dir string, opts KeyStoreOptions) (*KeyStore, error) {
+	if opts.Params == "" {
+		opts.Params = "XMSSMT-SHA2_40/2_512"
+	}
+	if _, err := xmssmt.NewContextFromName2(opts.Params); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	ks := &KeyStore{dir: dir, opts: opts}
+	n, err := ks.lastKey()
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		err = ks.generate(0)
+	} else {
+		err = ks.load(n)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+func (ks *KeyStore) keyPath(n int) string {
+	return filepath.Join(ks.dir, fmt.Sprintf("xmssmt-%d", n))
+}
+
+// Returns the index of the newest key in the directory or -1 if there
+// is none.
+func (ks *KeyStore) lastKey() (int, error) {
+	entries, err := ioutil.ReadDir(ks.dir)
+	if err != nil {
+		return 0, err
+	}
+	var ns []int
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, "xmssmt-") {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(name, "xmssmt-"))
+		if err != nil {
+			continue // a .cache, .lock, .tmp or .new file
+		}
+		ns = append(ns, n)
+	}
+	if len(ns) == 0 {
+		return -1, nil
+	}
+	sort.Ints(ns)
+	return ns[len(ns)-1], nil
+}
+
+// Loads the n-th key and makes it the one in use.
+func (ks *KeyStore) load(n int) error {
+	sk, pk, lostSigs, err := xmssmt.LoadPrivateKey(ks.keyPath(n))
+	if err != nil {
+		return err
+	}
+	if lostSigs != 0 {
+		log.Printf("atum keystore: %d signatures were lost from %s",
+			lostSigs, ks.keyPath(n))
+	}
+	return ks.use(n, sk, pk)
+}
+
+// Generates the n-th key and makes it the one in use.
+func (ks *KeyStore) generate(n int) error {
+	ctx, err := xmssmt.NewContextFromName2(ks.opts.Params)
+	if err != nil {
+		return err
+	}
+
+	// Generate the key under a temporary name and only move it into place
+	// when it's complete, so that a crash halfway never leaves a broken
+	// key behind that would be picked up as the one in use.  go-xmssmt
+	// rewrites the key file by its path, so we move it while it's closed.
+	path := ks.keyPath(n)
+	tmpPath := path + ".new"
+	for _, suffix := range []string{"", ".cache"} {
+		if err := os.Remove(tmpPath + suffix); err != nil &&
+			!os.IsNotExist(err) {
+			return err
+		}
+	}
+	sk, _, err := ctx.GenerateKeyPair(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err = sk.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath+".cache", path+".cache"); err != nil &&
+		!os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	if err := syncDir(ks.dir); err != nil {
+		return err
+	}
+	return ks.load(n)
+}
+
+// Writes the entries of the directory to disk.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+func (ks *KeyStore) use(n int, sk *xmssmt.PrivateKey,
+	pk *xmssmt.PublicKey) error {
+	pkBuf, err := pk.MarshalBinary()
+	if err != nil {
+		sk.Close()
+		return err
+	}
+	if ks.sk != nil {
+		if err := ks.sk.Close(); err != nil {
+			log.Printf("atum keystore: closing %s: %v", ks.keyPath(ks.n), err)
+		}
+	}
+	ks.sk = sk
+	ks.pk = pkBuf
+	ks.n = n
+	ks.warned = false
+	return nil
+}
+
+// Returns the number of signatures left on the key in use.
+func (ks *KeyStore) remaining() uint64 {
+	params := ks.sk.Context().Params()
+	return params.MaxSignatureSeqNo() - uint64(ks.sk.SeqNo()) + 1
+}
+
+func (ks *KeyStore) warnThreshold() uint64 {
+	if ks.opts.WarnThreshold != 0 {
+		return ks.opts.WarnThreshold
+	}
+	params := ks.sk.Context().Params()
+	return params.MaxSignatureSeqNo() / 100
+}
+
+// Returns the number of signatures left on the key in use.
+func (ks *KeyStore) Remaining() uint64 {
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+	return ks.remaining()
+}
+
+// Returns the serialized public key of the key in use.
+func (ks *KeyStore) PublicKey() []byte {
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+	return ks.pk
+}
+
+// Generates a fresh key and uses it from now on.
+func (ks *KeyStore) Rollover() error {
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+	return ks.generate(ks.n + 1)
+}
+
+// Signs the message, rolling over to a fresh key if required.  Returns
+// the signature and the serialized public key with which it was created.
+func (ks *KeyStore) SignWithKey(msg []byte) (sig, pk []byte, err error) {
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+
+	if ks.remaining() <= ks.opts.RolloverThreshold {
+		if err = ks.generate(ks.n + 1); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	xsig, err := ks.sk.Sign(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	sig, err = xsig.MarshalBinary()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if remaining := ks.remaining(); !ks.warned &&
+		remaining < ks.warnThreshold() {
+		ks.warned = true
+		if ks.opts.Warn != nil {
+			ks.opts.Warn(remaining)
+		} else {
+			log.Printf("atum keystore: only %d signatures left on %s",
+				remaining, ks.keyPath(ks.n))
+		}
+	}
+
+	return sig, ks.pk, nil
+}
+
+// Closes the key in use.
+func (ks *KeyStore) Close() error {
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+	return ks.sk.Close()
+}
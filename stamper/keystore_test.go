@@ -0,0 +1,130 @@
+package stamper
+
+import (
+	"github.com/bwesterb/go-xmssmt"
+
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A small instance, which is quick to generate.
+const testParams = "XMSSMT-SHA2_20/4_256"
+
+// Signs msg and returns the signature, its sequence number and the key.
+func testSign(t *testing.T, ks *KeyStore, msg string) ([]byte, uint64, []byte) {
+	sig, pk, err := ks.SignWithKey([]byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err2 := xmssmt.Verify(pk, sig, []byte(msg))
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	if !ok {
+		t.Fatalf("signature on %q is invalid", msg)
+	}
+	var xsig xmssmt.Signature
+	if err = xsig.UnmarshalBinary(sig); err != nil {
+		t.Fatal(err)
+	}
+	return sig, uint64(xsig.SeqNo()), pk
+}
+
+func TestKeyStoreCrash(t *testing.T) {
+	dir := t.TempDir()
+	ks, err := OpenKeyStore(dir, KeyStoreOptions{Params: testParams})
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := make(map[uint64]bool)
+	for i := 0; i < 3; i++ {
+		_, seqNo, _ := testSign(t, ks, fmt.Sprintf("before %d", i))
+		used[seqNo] = true
+	}
+	pk := ks.PublicKey()
+
+	// Crash between signing and writing the state: the KeyStore is never
+	// closed, so the sequence number is never updated on Close().
+	ks2, err := OpenKeyStore(dir, KeyStoreOptions{Params: testParams})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks2.Close()
+	if !bytes.Equal(ks2.PublicKey(), pk) {
+		t.Fatal("a new key was generated after the crash")
+	}
+	for i := 0; i < 3; i++ {
+		_, seqNo, _ := testSign(t, ks2, fmt.Sprintf("after %d", i))
+		if used[seqNo] {
+			t.Fatalf("signature index %d was reused", seqNo)
+		}
+		used[seqNo] = true
+	}
+}
+
+func TestKeyStoreRollover(t *testing.T) {
+	dir := t.TempDir()
+	ks, err := OpenKeyStore(dir, KeyStoreOptions{Params: testParams})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ks.Remaining() != 1<<20 {
+		t.Fatalf("fresh key has %d signatures left", ks.Remaining())
+	}
+	pk0 := ks.PublicKey()
+
+	// Roll over after two signatures.
+	ks.opts.RolloverThreshold = ks.Remaining() - 2
+	var warned uint64
+	ks.opts.WarnThreshold = ks.Remaining()
+	ks.opts.Warn = func(remaining uint64) { warned = remaining }
+	_, _, pk := testSign(t, ks, "first")
+	if !bytes.Equal(pk, pk0) {
+		t.Fatal("rolled over too soon")
+	}
+	if warned != 1<<20-1 {
+		t.Fatalf("warned with %d signatures left", warned)
+	}
+	testSign(t, ks, "second")
+	_, seqNo, pk := testSign(t, ks, "third")
+	if bytes.Equal(pk, pk0) {
+		t.Fatal("did not roll over")
+	}
+	if seqNo != 0 {
+		t.Fatalf("first signature of the new key has index %d", seqNo)
+	}
+	if err = ks.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash while generating a key leaves a temporary key behind,
+	// which must be ignored.
+	tmpPath := filepath.Join(dir, "xmssmt-2.new")
+	if err = ioutil.WriteFile(tmpPath, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ks, err = OpenKeyStore(dir, KeyStoreOptions{Params: testParams})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks.Close()
+	if !bytes.Equal(ks.PublicKey(), pk) {
+		t.Fatal("did not load the newest key")
+	}
+	if err = ks.Rollover(); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(ks.PublicKey(), pk) {
+		t.Fatal("Rollover() did not generate a new key")
+	}
+	if _, err = os.Stat(tmpPath); !os.IsNotExist(err) {
+		t.Fatalf("temporary key was left behind: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "xmssmt-2")); err != nil {
+		t.Fatal(err)
+	}
+}
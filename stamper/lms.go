@@ -0,0 +1,148 @@
+package stamper
+
+import (
+	"github.com/bwesterb/go-atum/lms"
+
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// A LMS/HSS private key together with its state, stored on the filesystem.
+//
+// The private key is stored at <path> and the index of the next signature
+// at <path>.state.  The state is written and fsync()ed before a signature
+// is created, so that a signature index is never used twice, even if
+// we crash halfway.
+//
+// The state file is locked (with flock() on unix) while the key is open,
+// so that two processes can't use the same key at the same time.
+type LMSKey struct {
+	mux   sync.Mutex
+	sk    *lms.PrivateKey
+	state *os.File
+	next  uint64 // index of the next signature
+}
+
+// Generates a new LMS/HSS key and stores it at the given path.
+//
+// Refuses to overwrite an existing key.
+func GenerateLMSKey(path string, params lms.Params) (*LMSKey, error) {
+	sk, err := lms.GenerateKey(params)
+	if err != nil {
+		return nil, err
+	}
+	skBuf, err := sk.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err = file.Write(skBuf); err != nil {
+		return nil, err
+	}
+	if err = file.Sync(); err != nil {
+		return nil, err
+	}
+	state, err := os.OpenFile(path+".state",
+		os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = lockFile(state); err != nil {
+		state.Close()
+		return nil, err
+	}
+	key := &LMSKey{sk: sk, state: state}
+	if err = key.writeState(0); err != nil {
+		state.Close()
+		return nil, err
+	}
+	return key, nil
+}
+
+// Loads the LMS/HSS key stored at the given path.
+//
+// NOTE Do not forget to Close() the key.
+func LoadLMSKey(path string) (*LMSKey, error) {
+	skBuf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sk, err := lms.ParsePrivateKey(skBuf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	state, err := os.OpenFile(path+".state", os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = lockFile(state); err != nil {
+		state.Close()
+		return nil, err
+	}
+	var buf [8]byte
+	if _, err = state.ReadAt(buf[:], 0); err != nil {
+		state.Close()
+		return nil, fmt.Errorf("%s.state: %v", path, err)
+	}
+	return &LMSKey{
+		sk:    sk,
+		state: state,
+		next:  binary.BigEndian.Uint64(buf[:]),
+	}, nil
+}
+
+// Writes the index of the next signature to disk.
+func (key *LMSKey) writeState(next uint64) error {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], next)
+	if _, err := key.state.WriteAt(buf[:], 0); err != nil {
+		return err
+	}
+	return key.state.Sync()
+}
+
+// Returns the serialized HSS public key.
+func (key *LMSKey) PublicKey() []byte {
+	return key.sk.PublicKey()
+}
+
+// Returns the parameters of the key.
+func (key *LMSKey) Params() lms.Params {
+	return key.sk.Params()
+}
+
+// Returns the number of signatures that can still be created.
+func (key *LMSKey) Remaining() uint64 {
+	key.mux.Lock()
+	defer key.mux.Unlock()
+	return key.sk.Params().MaxSignatures() - key.next
+}
+
+// Signs the message with the next unused signature index.
+func (key *LMSKey) Sign(msg []byte) ([]byte, error) {
+	key.mux.Lock()
+	if key.next >= key.sk.Params().MaxSignatures() {
+		key.mux.Unlock()
+		return nil, fmt.Errorf("LMS key is exhausted")
+	}
+	index := key.next
+	if err := key.writeState(index + 1); err != nil {
+		key.mux.Unlock()
+		return nil, err
+	}
+	key.next++
+	key.mux.Unlock()
+	return key.sk.Sign(index, msg)
+}
+
+// Closes the state file, which releases the lock on the key.
+func (key *LMSKey) Close() error {
+	return key.state.Close()
+}
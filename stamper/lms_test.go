@@ -0,0 +1,81 @@
+package stamper
+
+import (
+	"github.com/bwesterb/go-atum/lms"
+
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+// Two levels with the same parameters, so that the signature of the lower
+// tree is as long as the signature on the message.
+const testLMSParams = "H5_W8/H5_W8"
+
+// Size of a serialized LMS public key.
+const testLMSPkSize = 4 + 4 + 16 + 32
+
+// Signs msg with the key and checks the signature.
+func testLMSSign(t *testing.T, key *LMSKey, msg string) []byte {
+	sig, err := key.Sign([]byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := lms.Verify(key.PublicKey(), sig, []byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatalf("signature on %q is invalid", msg)
+	}
+	return sig
+}
+
+// Returns the part of a signature by a testLMSParams key before the
+// signature on the message: the signature on the lower tree and its
+// public key.
+func upperLMSSig(sig []byte) []byte {
+	sigLen := (len(sig) - 4 - testLMSPkSize) / 2
+	return sig[:4+sigLen+testLMSPkSize]
+}
+
+func TestLMSKeyReload(t *testing.T) {
+	params, err := lms.ParseParams(testLMSParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "lms.key")
+	key, err := GenerateLMSKey(path, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig1 := testLMSSign(t, key, "before")
+	if err = key.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// After reloading, the lower tree is derived and signed again, with
+	// the same one-time key of the upper tree.
+	key, err = LoadLMSKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Close()
+	sig2 := testLMSSign(t, key, "after")
+
+	info1, err := lms.ParseSignature(sig1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info2, err := lms.ParseSignature(sig2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info1.Index() != 0 || info2.Index() != 1 {
+		t.Fatalf("signed with indices %d and %d instead of 0 and 1",
+			info1.Index(), info2.Index())
+	}
+	if !bytes.Equal(upperLMSSig(sig1), upperLMSSig(sig2)) {
+		t.Fatal("the upper tree signed the lower tree twice differently")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package stamper
+
+import (
+	"os"
+)
+
+// Locking files is only implemented on unix; elsewhere it's up to the
+// user not to open a key twice.
+func lockFile(f *os.File) error {
+	return nil
+}
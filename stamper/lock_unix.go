@@ -0,0 +1,21 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package stamper
+
+import (
+	"golang.org/x/sys/unix"
+
+	"fmt"
+	"os"
+)
+
+// Takes an exclusive lock on the file, which is released when it's closed.
+// Fails if another process holds the lock.
+func lockFile(f *os.File) error {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		return fmt.Errorf("%s: in use by another process", f.Name())
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+package stamper
+
+import (
+	"github.com/bwesterb/go-atum"
+	"github.com/bwesterb/go-xmssmt"
+
+	"golang.org/x/crypto/ed25519"
+
+	"fmt"
+)
+
+// A signing backend used to create timestamps.  See Stamp().
+type Signer interface {
+	// The signature algorithm used by the signer.
+	Alg() atum.SignatureAlgorithm
+
+	// The serialized public key of the signer.
+	PublicKey() []byte
+
+	// Signs the message.
+	Sign(msg []byte) ([]byte, error)
+}
+
+// A Signer that might change its public key between calls, such as
+// a KeyStore that rolls over to a new key.  For these, Stamp() uses
+// SignWithKey() to learn which public key was used for the signature.
+type KeyChangingSigner interface {
+	Signer
+
+	// Signs the message and returns the signature together with
+	// the serialized public key that was used.
+	SignWithKey(msg []byte) (sig, pk []byte, err error)
+}
+
+// Create a timestamp on the nonce at the given time with the signer.
+func Stamp(signer Signer, time int64, nonce []byte) (*atum.Timestamp, error) {
+	var ts atum.Timestamp
+	var err error
+	msg := atum.EncodeTimeNonce(time, nonce)
+	ts.Time = time
+	ts.Sig.Alg = signer.Alg()
+	if kcSigner, ok := signer.(KeyChangingSigner); ok {
+		ts.Sig.Data, ts.Sig.PublicKey, err = kcSigner.SignWithKey(msg)
+	} else {
+		ts.Sig.PublicKey = signer.PublicKey()
+		ts.Sig.Data, err = signer.Sign(msg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ts, nil
+}
+
+// Create a hybrid timestamp on the nonce at the given time, which is signed
+// by each of the signers.
+func StampHybrid(signers []Signer, time int64, nonce []byte) (
+	*atum.Timestamp, error) {
+	stamps := make([]*atum.Timestamp, len(signers))
+	for i, signer := range signers {
+		var err error
+		stamps[i], err = Stamp(signer, time, nonce)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", signer.Alg(), err)
+		}
+	}
+	return CreateHybridTimestamp(stamps...)
+}
+
+type ed25519Signer struct {
+	sk ed25519.PrivateKey
+}
+
+// Returns a Signer for the given Ed25519 private key.
+func NewEd25519Signer(sk ed25519.PrivateKey) Signer {
+	return &ed25519Signer{sk: sk}
+}
+
+func (s *ed25519Signer) Alg() atum.SignatureAlgorithm {
+	return atum.Ed25519
+}
+
+func (s *ed25519Signer) PublicKey() []byte {
+	return []byte(s.sk.Public().(ed25519.PublicKey))
+}
+
+func (s *ed25519Signer) Sign(msg []byte) ([]byte, error) {
+	return ed25519.Sign(s.sk, msg), nil
+}
+
+type xmssmtSigner struct {
+	sk *xmssmt.PrivateKey
+	pk []byte
+}
+
+// Returns a Signer for the given XMSS[MT] private key.
+func NewXMSSMTSigner(sk *xmssmt.PrivateKey) (Signer, error) {
+	pk, err := sk.PublicKey().MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return &xmssmtSigner{sk: sk, pk: pk}, nil
+}
+
+func (s *xmssmtSigner) Alg() atum.SignatureAlgorithm {
+	return atum.XMSSMT
+}
+
+func (s *xmssmtSigner) PublicKey() []byte {
+	return s.pk
+}
+
+func (s *xmssmtSigner) Sign(msg []byte) ([]byte, error) {
+	sig, err := s.sk.Sign(msg)
+	if err != nil {
+		return nil, err
+	}
+	return sig.MarshalBinary()
+}
+
+func (key *LMSKey) Alg() atum.SignatureAlgorithm {
+	return atum.LMS
+}
+
+func (ks *KeyStore) Alg() atum.SignatureAlgorithm {
+	return atum.XMSSMT
+}
+
+func (ks *KeyStore) Sign(msg []byte) ([]byte, error) {
+	sig, _, err := ks.SignWithKey(msg)
+	return sig, err
+}
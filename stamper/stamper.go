@@ -2,6 +2,10 @@
 //
 // You want to use this package if you are writing an Atum server.  If you
 // just want to request an Atum timestamp, use github.com/bwesterb/go-atum.
+//
+// Timestamps are created by a Signer: see Stamp().  This package contains
+// Signers for Ed25519 and XMSS[MT] private keys, for XMSS[MT] keys managed
+// by a KeyStore and for stateful LMS keys.
 package stamper
 
 import (
@@ -9,9 +13,14 @@ import (
 	"github.com/bwesterb/go-xmssmt"
 
 	"golang.org/x/crypto/ed25519"
+
+	"encoding/hex"
+	"fmt"
 )
 
 // Create an Ed25519 timestamp
+//
+// For other signature algorithms and signing backends, see Stamp().
 func CreateEd25519Timestamp(sk ed25519.PrivateKey, pk ed25519.PublicKey,
 	time int64, nonce []byte) (ts atum.Timestamp) {
 	msg := atum.EncodeTimeNonce(time, nonce)
@@ -23,6 +32,8 @@ func CreateEd25519Timestamp(sk ed25519.PrivateKey, pk ed25519.PublicKey,
 }
 
 // Create an XMSSMT timestamp
+//
+// For other signature algorithms and signing backends, see Stamp().
 func CreateXMSSMTTimestamp(sk *xmssmt.PrivateKey, pk *xmssmt.PublicKey,
 	time int64, nonce []byte) (*atum.Timestamp, error) {
 	var ts atum.Timestamp
@@ -45,3 +56,33 @@ func CreateXMSSMTTimestamp(sk *xmssmt.PrivateKey, pk *xmssmt.PublicKey,
 	ts.Sig.PublicKey = pkBytes
 	return &ts, nil
 }
+
+// Combines timestamps on the same time and nonce into a single hybrid
+// timestamp, which carries the signatures of all of them.  A signature
+// by the same algorithm and public key as one before is left out.
+func CreateHybridTimestamp(stamps ...*atum.Timestamp) (*atum.Timestamp, error) {
+	if len(stamps) == 0 {
+		return nil, fmt.Errorf("No timestamps to combine")
+	}
+	ts := *stamps[0]
+	ts.ExtraSigs = nil
+	seen := map[string]bool{sigKey(ts.Sig): true}
+	for _, other := range stamps {
+		if other.Time != ts.Time {
+			return nil, fmt.Errorf("Timestamps are set at different times")
+		}
+		for _, sig := range other.Signatures() {
+			if seen[sigKey(sig)] {
+				continue
+			}
+			seen[sigKey(sig)] = true
+			ts.ExtraSigs = append(ts.ExtraSigs, sig)
+		}
+	}
+	return &ts, nil
+}
+
+// Identifies the signer of the signature by its algorithm and public key.
+func sigKey(sig atum.Signature) string {
+	return string(sig.Alg) + "-" + hex.EncodeToString(sig.PublicKey)
+}
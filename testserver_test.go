@@ -0,0 +1,67 @@
+package atum_test
+
+import (
+	"github.com/bwesterb/go-atum"
+	"github.com/bwesterb/go-atum/lms"
+	"github.com/bwesterb/go-atum/server"
+	"github.com/bwesterb/go-atum/stamper"
+
+	"golang.org/x/crypto/ed25519"
+
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// Returns an Ed25519 signer with a fresh key.
+func newEd25519Signer(t *testing.T) stamper.Signer {
+	_, sk, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stamper.NewEd25519Signer(sk)
+}
+
+// Returns an LMS signer with a fresh key of small height.
+func newLMSSigner(t *testing.T) stamper.Signer {
+	params, err := lms.ParseParams("H5_W8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := stamper.GenerateLMSKey(
+		filepath.Join(t.TempDir(), "lms.key"), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { key.Close() })
+	return key
+}
+
+// Creates an Atum server with the given configuration (with an Ed25519
+// signer if none is set) and sets an empty client cache.
+func newServer(t *testing.T, cfg server.Config) *server.Server {
+	atum.SetCache(atum.NewBoltCache(filepath.Join(t.TempDir(), "cache.bolt")))
+	if len(cfg.Signers) == 0 {
+		cfg.Signers = []stamper.Signer{newEd25519Signer(t)}
+	}
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// Serves the handler over http.  Returns the url and the test server.
+func serve(t *testing.T, handler http.Handler) (string, *httptest.Server) {
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return ts.URL + "/", ts
+}
+
+// Starts an Atum server as newServer() does.  Returns its url.
+func startServer(t *testing.T, cfg server.Config) string {
+	url, _ := serve(t, newServer(t, cfg))
+	return url
+}
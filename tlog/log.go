@@ -0,0 +1,175 @@
+package tlog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// An append-only log of entries stored in a file.
+//
+// The entries are stored as a 32-bit big endian length followed by
+// the entry itself.  Every entry is fsync()ed to disk before Append returns.
+type Log struct {
+	mux     sync.Mutex
+	file    *os.File
+	entries [][]byte
+	h       hasher
+	broken  error // set if a partial entry couldn't be removed
+}
+
+// Opens the log stored at the given path, creating it if it does not exist.
+// If path is empty, the log is only kept in memory.
+//
+// A partially written entry at the end of the file (left by a crash) is
+// removed.
+//
+// NOTE Do not forget to Close() the log.
+func OpenLog(path string) (*Log, error) {
+	l := &Log{h: hasher{memo: make(map[[2]uint64][]byte)}}
+	if path == "" {
+		return l, nil
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := ioutil.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	var offset int
+	for offset+4 <= len(buf) {
+		size := int(binary.BigEndian.Uint32(buf[offset : offset+4]))
+		if offset+4+size > len(buf) {
+			break
+		}
+		l.add(buf[offset+4 : offset+4+size])
+		offset += 4 + size
+	}
+	if offset != len(buf) {
+		if err = file.Truncate(int64(offset)); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if _, err = file.Seek(int64(offset), io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	l.file = file
+	return l, nil
+}
+
+func (l *Log) add(entry []byte) {
+	l.entries = append(l.entries, entry)
+	l.h.leafs = append(l.h.leafs, LeafHash(entry))
+}
+
+// Appends the entry to the log and returns its index.
+//
+// If the entry can't be written, what was written of it is removed again,
+// so that later entries don't end up after a partial one.  If that fails
+// too, the log refuses further entries.
+func (l *Log) Append(entry []byte) (uint64, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.file != nil {
+		if l.broken != nil {
+			return 0, l.broken
+		}
+		offset, err := l.file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, err
+		}
+		buf := make([]byte, 4+len(entry))
+		binary.BigEndian.PutUint32(buf, uint32(len(entry)))
+		copy(buf[4:], entry)
+		_, err = l.file.Write(buf)
+		if err == nil {
+			err = l.file.Sync()
+		}
+		if err != nil {
+			if err2 := l.file.Truncate(offset); err2 != nil {
+				l.broken = fmt.Errorf("Failed to remove partial entry: %v", err2)
+			} else if _, err2 = l.file.Seek(offset, io.SeekStart); err2 != nil {
+				l.broken = fmt.Errorf("Failed to remove partial entry: %v", err2)
+			}
+			return 0, err
+		}
+	}
+	l.add(append([]byte(nil), entry...))
+	return uint64(len(l.entries) - 1), nil
+}
+
+// Returns the number of entries in the log.
+func (l *Log) Size() uint64 {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return uint64(len(l.entries))
+}
+
+// Returns the root hash of the tree of the first size entries.
+func (l *Log) RootHash(size uint64) ([]byte, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if size > uint64(len(l.entries)) {
+		return nil, fmt.Errorf("Log has only %d entries", len(l.entries))
+	}
+	return l.h.hash(0, size), nil
+}
+
+// Returns the proof that the entry with the given index is included in the
+// tree of the first size entries.
+func (l *Log) InclusionProof(index, size uint64) ([][]byte, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if size > uint64(len(l.entries)) {
+		return nil, fmt.Errorf("Log has only %d entries", len(l.entries))
+	}
+	if index >= size {
+		return nil, fmt.Errorf("Index %d is not in tree of size %d",
+			index, size)
+	}
+	return l.h.path(index, 0, size), nil
+}
+
+// Returns the proof that the tree of the first size2 entries extends
+// the tree of the first size1 entries.
+func (l *Log) ConsistencyProof(size1, size2 uint64) ([][]byte, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if size2 > uint64(len(l.entries)) {
+		return nil, fmt.Errorf("Log has only %d entries", len(l.entries))
+	}
+	if size1 > size2 {
+		return nil, fmt.Errorf("Tree of size %d can't extend tree of size %d",
+			size2, size1)
+	}
+	if size1 == 0 || size1 == size2 {
+		return nil, nil
+	}
+	return l.h.subproof(size1, 0, size2, true), nil
+}
+
+// Returns the entries with index start up to (but not including) end.
+func (l *Log) Entries(start, end uint64) ([][]byte, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if end > uint64(len(l.entries)) || start > end {
+		return nil, fmt.Errorf("Log has only %d entries", len(l.entries))
+	}
+	return append([][]byte(nil), l.entries[start:end]...), nil
+}
+
+// Closes the file backing the log.
+func (l *Log) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,129 @@
+package tlog
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Appends the test leafs to the log at the given path and closes it.
+func writeTestLog(t *testing.T, path string) {
+	l, err := OpenLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leaf := range testLeafs {
+		if _, err = l.Append(leaf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Checks that the log holds the test leafs followed by the extra entries.
+func checkTestLog(t *testing.T, l *Log, extra ...[]byte) {
+	want := append(append([][]byte(nil), testLeafs...), extra...)
+	if l.Size() != uint64(len(want)) {
+		t.Fatalf("log has %d entries instead of %d", l.Size(), len(want))
+	}
+	entries, err := l.Entries(0, l.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalProofs(entries, want) {
+		t.Fatalf("log has the wrong entries")
+	}
+	root, err := l.RootHash(uint64(len(testLeafs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(root, unhex(t, testRoots[len(testRoots)-1])) {
+		t.Fatalf("root hash of the test leafs is %x", root)
+	}
+}
+
+func TestLogReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	writeTestLog(t, path)
+
+	l, err := OpenLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestLog(t, l)
+	index, err := l.Append([]byte("more"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != uint64(len(testLeafs)) {
+		t.Fatalf("appended entry has index %d", index)
+	}
+	l.Close()
+
+	if l, err = OpenLog(path); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	checkTestLog(t, l, []byte("more"))
+}
+
+func TestLogPartialEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	writeTestLog(t, path)
+
+	// A crash while writing an entry of 16 bytes leaves part of it.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write([]byte{0, 0, 0, 16, 1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	l, err := OpenLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestLog(t, l)
+	if _, err = l.Append([]byte("more")); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	if l, err = OpenLog(path); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	checkTestLog(t, l, []byte("more"))
+}
+
+func TestLogAppendFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	writeTestLog(t, path)
+	l, err := OpenLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Writes to a read-only file fail, and so does removing what was
+	// written, after which the log should refuse further entries.
+	rw := l.file
+	if l.file, err = os.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer rw.Close()
+	for i := 0; i < 2; i++ {
+		if _, err = l.Append([]byte("more")); err == nil {
+			t.Fatal("append to read-only file succeeded")
+		}
+	}
+	if l.broken == nil {
+		t.Fatal("log accepts entries after failing to remove a partial one")
+	}
+	checkTestLog(t, l)
+}
@@ -0,0 +1,203 @@
+// Append-only Merkle tree logs as used by Certificate Transparency.
+//
+// The hashes, inclusion proofs and consistency proofs are those of RFC 6962
+// (and RFC 9162) with SHA-256.
+package tlog
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// Returns the hash of a leaf with the given data.
+func LeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// Returns the hash of an inner node with the given children.
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Returns the largest power of two smaller than n, for n > 1.
+func splitPoint(n uint64) uint64 {
+	k := uint64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// Verifies that the leaf with the given hash is at the given index in the
+// tree of the given size with the given root.
+func VerifyInclusion(index, size uint64, leafHash []byte, proof [][]byte,
+	root []byte) bool {
+	r, ok := RootFromInclusionProof(index, size, leafHash, proof)
+	return ok && bytes.Equal(r, root)
+}
+
+// Returns the root of the tree of the given size in which the leaf with the
+// given hash is at the given index, according to the inclusion proof.
+// Returns false if the proof is malformed.
+func RootFromInclusionProof(index, size uint64, leafHash []byte,
+	proof [][]byte) ([]byte, bool) {
+	if index >= size {
+		return nil, false
+	}
+	fn := index
+	sn := size - 1
+	r := leafHash
+	for _, p := range proof {
+		if sn == 0 {
+			return nil, false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return r, sn == 0
+}
+
+// Verifies that the tree of size2 with root2 extends the tree of
+// size1 with root1.
+func VerifyConsistency(size1, size2 uint64, root1, root2 []byte,
+	proof [][]byte) bool {
+	if size1 > size2 {
+		return false
+	}
+	if size1 == size2 {
+		return len(proof) == 0 && bytes.Equal(root1, root2)
+	}
+	if size1 == 0 {
+		return len(proof) == 0
+	}
+	if size1&(size1-1) == 0 {
+		proof = append([][]byte{root1}, proof...)
+	}
+	if len(proof) == 0 {
+		return false
+	}
+	fn := size1 - 1
+	sn := size2 - 1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr := proof[0]
+	sr := proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(fr, root1) && bytes.Equal(sr, root2)
+}
+
+// A Merkle tree over a fixed list of leafs kept in memory.
+// See Log for a tree that grows.
+type Tree struct {
+	h hasher
+}
+
+// Creates the tree with the given leaf hashes.  See LeafHash().
+func NewTree(leafHashes [][]byte) *Tree {
+	return &Tree{h: hasher{
+		leafs: leafHashes,
+		memo:  make(map[[2]uint64][]byte),
+	}}
+}
+
+// Returns the root hash of the tree.
+func (t *Tree) RootHash() []byte {
+	return t.h.hash(0, uint64(len(t.h.leafs)))
+}
+
+// Returns the proof that the leaf with the given index is in the tree.
+func (t *Tree) InclusionProof(index uint64) [][]byte {
+	return t.h.path(index, 0, uint64(len(t.h.leafs)))
+}
+
+// Computes Merkle tree hashes over a list of leaf hashes.  Hashes of
+// complete subtrees never change, so they are memoized.
+type hasher struct {
+	leafs [][]byte
+	memo  map[[2]uint64][]byte
+}
+
+// Returns the hash of the tree over leafs[start:start+n].
+func (h *hasher) hash(start, n uint64) []byte {
+	if n == 1 {
+		return h.leafs[start]
+	}
+	if n == 0 {
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	}
+	complete := n&(n-1) == 0
+	if complete {
+		if ret, ok := h.memo[[2]uint64{start, n}]; ok {
+			return ret
+		}
+	}
+	k := splitPoint(n)
+	ret := nodeHash(h.hash(start, k), h.hash(start+k, n-k))
+	if complete {
+		h.memo[[2]uint64{start, n}] = ret
+	}
+	return ret
+}
+
+// Returns the inclusion proof of leaf m in leafs[start:start+n].
+func (h *hasher) path(m, start, n uint64) [][]byte {
+	if n == 1 {
+		return nil
+	}
+	k := splitPoint(n)
+	if m < k {
+		return append(h.path(m, start, k), h.hash(start+k, n-k))
+	}
+	return append(h.path(m-k, start+k, n-k), h.hash(start, k))
+}
+
+// Returns the consistency proof of the first m leafs
+// in leafs[start:start+n].
+func (h *hasher) subproof(m, start, n uint64, b bool) [][]byte {
+	if m == n {
+		if b {
+			return nil
+		}
+		return [][]byte{h.hash(start, m)}
+	}
+	k := splitPoint(n)
+	if m <= k {
+		return append(h.subproof(m, start, k, b), h.hash(start+k, n-k))
+	}
+	return append(h.subproof(m-k, start+k, n-k, false), h.hash(start, k))
+}
@@ -0,0 +1,203 @@
+package tlog
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// The leafs of the test vectors of the Certificate Transparency reference
+// implementations, which use the hashes of RFC 6962 and RFC 9162.
+var testLeafs = [][]byte{
+	{},
+	{0x00},
+	{0x10},
+	{0x20, 0x21},
+	{0x30, 0x31},
+	{0x40, 0x41, 0x42, 0x43},
+	{0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57},
+	{0x60, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67,
+		0x68, 0x69, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f},
+}
+
+// testRoots[i] is the root hash of the tree of the first i+1 testLeafs.
+var testRoots = []string{
+	"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+	"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+	"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+	"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+	"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+	"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+}
+
+var testInclusionProofs = []struct {
+	index, size uint64
+	proof       []string
+}{
+	{0, 1, nil},
+	{0, 8, []string{
+		"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+		"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+		"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+	}},
+	{5, 8, []string{
+		"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+		"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+		"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	}},
+	{2, 3, []string{
+		"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	}},
+	{1, 5, []string{
+		"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+		"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+		"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+	}},
+}
+
+var testConsistencyProofs = []struct {
+	size1, size2 uint64
+	proof        []string
+}{
+	{1, 1, nil},
+	{1, 8, []string{
+		"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+		"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+		"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+	}},
+	{6, 8, []string{
+		"0ebc5d3437fbe2db158b9f126a1d118e308181031d0a949f8dededebc558ef6a",
+		"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+		"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	}},
+	{2, 5, []string{
+		"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+		"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+	}},
+}
+
+func unhex(t *testing.T, s string) []byte {
+	ret, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func unhexProof(t *testing.T, proof []string) [][]byte {
+	ret := make([][]byte, len(proof))
+	for i, s := range proof {
+		ret[i] = unhex(t, s)
+	}
+	return ret
+}
+
+// Returns an in-memory log with the test leafs.
+func testLog(t *testing.T) *Log {
+	l, err := OpenLog("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leaf := range testLeafs {
+		if _, err = l.Append(leaf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return l
+}
+
+func equalProofs(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRootHash(t *testing.T) {
+	l := testLog(t)
+	for i, root := range testRoots {
+		got, err := l.RootHash(uint64(i + 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, unhex(t, root)) {
+			t.Fatalf("root of tree of size %d is %x instead of %s",
+				i+1, got, root)
+		}
+	}
+
+	leafHashes := make([][]byte, len(testLeafs))
+	for i, leaf := range testLeafs {
+		leafHashes[i] = LeafHash(leaf)
+	}
+	if got := NewTree(leafHashes).RootHash(); !bytes.Equal(got,
+		unhex(t, testRoots[len(testRoots)-1])) {
+		t.Fatalf("root of Tree is %x", got)
+	}
+}
+
+func TestInclusionProof(t *testing.T) {
+	l := testLog(t)
+	for _, tc := range testInclusionProofs {
+		want := unhexProof(t, tc.proof)
+		got, err := l.InclusionProof(tc.index, tc.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalProofs(got, want) {
+			t.Fatalf("inclusion proof of %d in tree of size %d is %x",
+				tc.index, tc.size, got)
+		}
+
+		root := unhex(t, testRoots[tc.size-1])
+		leafHash := LeafHash(testLeafs[tc.index])
+		if !VerifyInclusion(tc.index, tc.size, leafHash, want, root) {
+			t.Fatalf("inclusion proof of %d in tree of size %d rejected",
+				tc.index, tc.size)
+		}
+		if VerifyInclusion(tc.index, tc.size, LeafHash([]byte("other")),
+			want, root) {
+			t.Fatalf("inclusion proof of %d in tree of size %d accepted "+
+				"for another leaf", tc.index, tc.size)
+		}
+		if tc.size > 1 && VerifyInclusion(tc.index+1, tc.size+1, leafHash,
+			want, root) {
+			t.Fatalf("inclusion proof of %d in tree of size %d accepted "+
+				"for another index", tc.index, tc.size)
+		}
+	}
+}
+
+func TestConsistencyProof(t *testing.T) {
+	l := testLog(t)
+	for _, tc := range testConsistencyProofs {
+		want := unhexProof(t, tc.proof)
+		got, err := l.ConsistencyProof(tc.size1, tc.size2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalProofs(got, want) {
+			t.Fatalf("consistency proof between sizes %d and %d is %x",
+				tc.size1, tc.size2, got)
+		}
+
+		root1 := unhex(t, testRoots[tc.size1-1])
+		root2 := unhex(t, testRoots[tc.size2-1])
+		if !VerifyConsistency(tc.size1, tc.size2, root1, root2, want) {
+			t.Fatalf("consistency proof between sizes %d and %d rejected",
+				tc.size1, tc.size2)
+		}
+		if tc.size1 != tc.size2 && VerifyConsistency(tc.size1, tc.size2,
+			root2, root2, want) {
+			t.Fatalf("consistency proof between sizes %d and %d accepted "+
+				"for another root", tc.size1, tc.size2)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package atum
+
+import (
+	"github.com/bwesterb/go-atum/tlog"
+
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Fetches a json document from the Atum server.  Returns false (and no error)
+// if the server responds with 404 Not Found.
+func getJson(url string, v interface{}) (bool, Error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return false, wrapErrorf(err, "http.Get()")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, errorf("Failed to fetch %s: %s", url, resp.Status)
+	}
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, wrapErrorf(err, "ioutil.ReadAll()")
+	}
+	if err = json.Unmarshal(buf, v); err != nil {
+		return false, wrapErrorf(err, "json.Unmarshal()")
+	}
+	return true, nil
+}
+
+// Fetches the latest tree head of the transparency log of the Atum server
+// and checks its signature.
+//
+// The tree head is checked to be consistent with the last tree head of the
+// server we saw, which protects against a server that shows different
+// clients different logs.
+//
+// Returns nil (and no error) if the server does not keep a transparency log.
+func FetchTreeHead(serverUrl string) (*TreeHead, Error) {
+	head, _, _, err := fetchTreeHead(serverUrl)
+	return head, err
+}
+
+// Like FetchTreeHead(), but also returns the previous tree head we saw
+// of the server (if any) and the consistency proof between the two.
+func fetchTreeHead(serverUrl string) (head, prev *TreeHead,
+	consistency [][]byte, err Error) {
+	if !strings.HasSuffix(serverUrl, "/") {
+		serverUrl += "/"
+	}
+	head = new(TreeHead)
+	found, err := getJson(serverUrl+"log/treeHead", head)
+	if err != nil || !found {
+		return nil, nil, nil, err
+	}
+	if err = head.Verify(serverUrl); err != nil {
+		return nil, nil, nil, err
+	}
+
+	prev = extendedCache().GetTreeHead(serverUrl)
+	if prev == nil || prev.Size == 0 {
+		extendedCache().StoreTreeHead(serverUrl, *head)
+		return head, nil, nil, nil
+	}
+
+	// We might get a tree head older than the one we saw before, in which
+	// case we check the consistency the other way around.
+	older, newer := prev, head
+	if head.Size < prev.Size {
+		older, newer = head, prev
+	}
+	consistency, err = FetchConsistencyProof(serverUrl, older.Size, newer.Size)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !tlog.VerifyConsistency(older.Size, newer.Size, older.RootHash,
+		newer.RootHash, consistency) {
+		return nil, nil, nil, errorf(
+			"Transparency log of %s is inconsistent with tree head seen before",
+			serverUrl)
+	}
+	if newer != head {
+		return head, nil, nil, nil
+	}
+	extendedCache().StoreTreeHead(serverUrl, *head)
+	return head, prev, consistency, nil
+}
+
+// Fetches the proof that the tree of the first size2 entries of the
+// transparency log of the Atum server extends the tree of the first size1
+// entries.
+func FetchConsistencyProof(serverUrl string, size1, size2 uint64) (
+	[][]byte, Error) {
+	if !strings.HasSuffix(serverUrl, "/") {
+		serverUrl += "/"
+	}
+	var resp LogProofResponse
+	found, err := getJson(fmt.Sprintf("%slog/consistency?first=%d&second=%d",
+		serverUrl, size1, size2), &resp)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errorf("Server does not keep a transparency log")
+	}
+	return resp.Proof, nil
+}
+
+// Fetches the entries of the transparency log of the Atum server with index
+// start up to (but not including) end.  The server might return fewer
+// entries than requested.
+func FetchLogEntries(serverUrl string, start, end uint64) ([][]byte, Error) {
+	if !strings.HasSuffix(serverUrl, "/") {
+		serverUrl += "/"
+	}
+	var resp LogEntriesResponse
+	found, err := getJson(fmt.Sprintf("%slog/entries?start=%d&end=%d",
+		serverUrl, start, end), &resp)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errorf("Server does not keep a transparency log")
+	}
+	if uint64(len(resp.Entries)) > end-start {
+		return nil, errorf("Server returned too many log entries")
+	}
+	return resp.Entries, nil
+}
+
+// Returns whether the proof is complete.  If the server had not signed
+// a tree head including the entry of a timestamp yet, the timestamp is
+// returned with a proof with just the Index, which is completed when
+// the timestamp is verified later on.
+func (p *LogProof) Complete() bool {
+	return p.TreeHead.Size > p.Index
+}
+
+// Fetches the proof that the timestamp on the given nonce is included
+// in the transparency log at the given index.  If the server has not signed
+// a tree head including the entry after the given number of tries, returns
+// an incomplete proof with just the index.
+func fetchLogProof(serverUrl string, ts *Timestamp, nonce []byte,
+	index uint64, tries int) (*LogProof, Error) {
+	// The server might not have signed a tree head including our entry yet.
+	var head, prev *TreeHead
+	var consistency [][]byte
+	var err Error
+	for try := 0; ; try++ {
+		head, prev, consistency, err = fetchTreeHead(serverUrl)
+		if err != nil {
+			return nil, err
+		}
+		if head == nil {
+			return nil, errorf("Server does not keep a transparency log")
+		}
+		if head.Size > index {
+			break
+		}
+		if try+1 >= tries {
+			return &LogProof{Index: index}, nil
+		}
+		time.Sleep(time.Second)
+	}
+
+	var resp LogProofResponse
+	found, err := getJson(fmt.Sprintf("%slog/inclusion?index=%d&size=%d",
+		serverUrl, index, head.Size), &resp)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errorf("Server does not keep a transparency log")
+	}
+	ret := &LogProof{
+		Index:            index,
+		TreeHead:         *head,
+		InclusionProof:   resp.Proof,
+		PreviousTreeHead: prev,
+		ConsistencyProof: consistency,
+	}
+	if !ret.verifyInclusion(ts, nonce) {
+		return nil, errorf(
+			"Server returned an invalid proof of inclusion in transparency log")
+	}
+	return ret, nil
+}
+
+// Checks the signature on the tree head and whether it was set with
+// a public key of the server.
+func (th *TreeHead) Verify(serverUrl string) Error {
+	valid, err := th.Sig.verifyMessage(th.SignedMessage())
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return errorf("Tree head has an invalid signature")
+	}
+	trusted, err := verifyServerKey(serverUrl, th.Sig, th.Time)
+	if err != nil {
+		return err
+	}
+	if !trusted {
+		return errorf("Tree head is signed by an untrusted public key")
+	}
+	return nil
+}
+
+func (p *LogProof) verifyInclusion(ts *Timestamp, nonce []byte) bool {
+	return tlog.VerifyInclusion(p.Index, p.TreeHead.Size,
+		tlog.LeafHash(ts.LogEntry(nonce)), p.InclusionProof,
+		p.TreeHead.RootHash)
+}
+
+// Checks the proof of inclusion in the transparency log on the timestamp.
+func (ts *Timestamp) verifyLogProof(nonce []byte) (valid bool, err Error) {
+	p := ts.Log
+	if !p.verifyInclusion(ts, nonce) {
+		// Most likely the message does not match the timestamp, which
+		// is not an error, just like an invalid signature.
+		return false, nil
+	}
+	if err = p.TreeHead.Verify(ts.ServerUrl); err != nil {
+		return false, err
+	}
+	if p.PreviousTreeHead != nil {
+		prev := p.PreviousTreeHead
+		if !tlog.VerifyConsistency(prev.Size, p.TreeHead.Size, prev.RootHash,
+			p.TreeHead.RootHash, p.ConsistencyProof) {
+			return false, errorf(
+				"Invalid consistency proof between tree heads of transparency log")
+		}
+		if err = prev.Verify(ts.ServerUrl); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
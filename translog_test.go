@@ -0,0 +1,87 @@
+package atum_test
+
+import (
+	"github.com/bwesterb/go-atum"
+	"github.com/bwesterb/go-atum/server"
+	"github.com/bwesterb/go-atum/stamper"
+
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A handler that passes requests on to a handler that can be swapped.
+type swapHandler struct {
+	mux sync.Mutex
+	h   http.Handler
+}
+
+func (s *swapHandler) set(h http.Handler) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.h = h
+}
+
+func (s *swapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.Lock()
+	h := s.h
+	s.mux.Unlock()
+	h.ServeHTTP(w, r)
+}
+
+// Requests n timestamps from the server.
+func stampN(t *testing.T, url string, n int) {
+	for i := 0; i < n; i++ {
+		_, err := atum.SendRequest(url, atum.Request{
+			Nonce: []byte(fmt.Sprintf("%s %d", url, i)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Starts two servers with the same key, which put 2 and 3 timestamps in
+// their transparency logs.  Returns the url at which the first is served
+// and the handler behind it, and the second server.
+func startForkedServers(t *testing.T) (string, *swapHandler, *server.Server) {
+	cfg := server.Config{Signers: []stamper.Signer{newEd25519Signer(t)}}
+	forked := newServer(t, cfg)
+	forkedUrl, _ := serve(t, forked)
+	stampN(t, forkedUrl, 3)
+
+	sh := &swapHandler{h: newServer(t, cfg)}
+	url, _ := serve(t, sh)
+	stampN(t, url, 2)
+	return url, sh, forked
+}
+
+func TestInconsistentTreeHead(t *testing.T) {
+	url, sh, forked := startForkedServers(t)
+
+	head, err := atum.FetchTreeHead(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Size != 2 {
+		t.Fatalf("tree head has size %d instead of 2", head.Size)
+	}
+
+	// A consistent tree head is accepted.
+	stampN(t, url, 1)
+	if head, err = atum.FetchTreeHead(url); err != nil {
+		t.Fatal(err)
+	}
+	if head.Size != 3 {
+		t.Fatalf("tree head has size %d instead of 3", head.Size)
+	}
+
+	// A tree head of a different log with the same key is not.
+	sh.set(forked)
+	if _, err = atum.FetchTreeHead(url); err == nil ||
+		!strings.Contains(err.Error(), "inconsistent") {
+		t.Fatalf("inconsistent tree head accepted: %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+// Cosign the tree heads of the transparency logs of Atum servers.
+//
+// A witness periodically fetches the signed tree head of the transparency
+// log of the Atum servers it watches, checks that it is consistent with the
+// tree heads of the server it saw before, and cosigns it with its own
+// Ed25519 key.  The cosignature is sent to the server, which publishes it.
+// Clients that require cosignatures of several independent witnesses
+// (see atum.VerificationPolicy) are protected against a server that shows
+// different clients different logs, without trusting a single operator.
+package witness
+
+import (
+	"github.com/bwesterb/go-atum"
+	"github.com/bwesterb/go-atum/tlog"
+	"golang.org/x/crypto/ed25519"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Configuration of a witness.  See New().
+type Config struct {
+	// The urls of the Atum servers to witness
+	Servers []string
+
+	// The Ed25519 private key of the witness
+	Key ed25519.PrivateKey
+
+	// File in which the witness keeps the latest tree head it saw of each
+	// server.  If empty, they are only kept in memory.
+	StatePath string
+
+	// How often to fetch and cosign the tree heads.  Defaults to 5 minutes.
+	Interval time.Duration
+}
+
+// A witness.  Use New() to create one.
+type Witness struct {
+	cfg Config
+
+	mux   sync.Mutex
+	heads map[string]atum.TreeHead // latest tree head seen per server
+}
+
+// Creates a new witness with the given configuration.
+func New(cfg Config) (*Witness, error) {
+	if len(cfg.Key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("Invalid Ed25519 private key")
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = 5 * time.Minute
+	}
+	for i := range cfg.Servers {
+		cfg.Servers[i] = normalizeUrl(cfg.Servers[i])
+	}
+	w := &Witness{
+		cfg:   cfg,
+		heads: make(map[string]atum.TreeHead),
+	}
+	if cfg.StatePath == "" {
+		return w, nil
+	}
+	buf, err := ioutil.ReadFile(cfg.StatePath)
+	if os.IsNotExist(err) {
+		return w, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(buf, &w.heads); err != nil {
+		return nil, fmt.Errorf("%s: %v", cfg.StatePath, err)
+	}
+	return w, nil
+}
+
+func normalizeUrl(serverUrl string) string {
+	if !strings.HasSuffix(serverUrl, "/") {
+		return serverUrl + "/"
+	}
+	return serverUrl
+}
+
+// Returns the Ed25519 public key of the witness.
+func (w *Witness) PublicKey() ed25519.PublicKey {
+	return w.cfg.Key.Public().(ed25519.PublicKey)
+}
+
+// Cosigns the tree heads of all servers every Interval until the
+// context is done.  Errors are logged.
+func (w *Witness) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		for _, serverUrl := range w.cfg.Servers {
+			if err := w.Witness(serverUrl); err != nil {
+				log.Printf("atum witness: %s: %v", serverUrl, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Fetches, checks and cosigns the latest tree head of the given server.
+//
+// The currently published cosigned tree head of the server is cosigned
+// first, so that witnesses converge on the same tree head: the server only
+// publishes a newer cosigned tree head once it has as many cosignatures.
+func (w *Witness) Witness(serverUrl string) error {
+	serverUrl = normalizeUrl(serverUrl)
+
+	cosigned, err := atum.FetchCosignedTreeHead(serverUrl)
+	if err != nil {
+		return err
+	}
+	if cosigned != nil && cosigned.CountCosignatures(serverUrl,
+		[][]byte{w.PublicKey()}) == 0 {
+		cosigned.Cosignatures = nil
+		if err := w.cosign(serverUrl, *cosigned); err != nil {
+			return err
+		}
+	}
+
+	var head atum.TreeHead
+	if err := getJson(serverUrl+"log/treeHead", &head); err != nil {
+		return err
+	}
+	if head.Cosignatures != nil {
+		return fmt.Errorf("Tree head unexpectedly has cosignatures")
+	}
+	return w.cosign(serverUrl, head)
+}
+
+// Checks the tree head and sends our cosignature on it to the server.
+func (w *Witness) cosign(serverUrl string, head atum.TreeHead) error {
+	if err := head.Verify(serverUrl); err != nil {
+		return err
+	}
+	if err := w.checkConsistency(serverUrl, head); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	req := atum.CosignRequest{
+		TreeHead: head,
+		Cosignature: atum.Cosignature{
+			PublicKey: w.PublicKey(),
+			Time:      now,
+			Sig: ed25519.Sign(w.cfg.Key,
+				head.CosignedMessage(serverUrl, now)),
+		},
+	}
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(serverUrl+"log/cosign", "application/json",
+		bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Server rejected cosignature: %s: %s",
+			resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Checks that the tree head is consistent with the latest tree head we
+// saw of the server, and stores it if it is newer.
+func (w *Witness) checkConsistency(serverUrl string, head atum.TreeHead) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	prev, ok := w.heads[serverUrl]
+	if ok && prev.Size != 0 {
+		older, newer := &prev, &head
+		if head.Size < prev.Size {
+			older, newer = &head, &prev
+		}
+		proof, err := atum.FetchConsistencyProof(serverUrl, older.Size,
+			newer.Size)
+		if err != nil {
+			return err
+		}
+		if !tlog.VerifyConsistency(older.Size, newer.Size, older.RootHash,
+			newer.RootHash, proof) {
+			return fmt.Errorf("Tree head of size %d is inconsistent with "+
+				"tree head of size %d seen before", head.Size, prev.Size)
+		}
+		if head.Size <= prev.Size {
+			return nil
+		}
+	}
+
+	head.Cosignatures = nil
+	w.heads[serverUrl] = head
+	return w.save()
+}
+
+// Writes the latest tree heads to the state file, if there is one.
+// Requires w.mux.
+func (w *Witness) save() error {
+	if w.cfg.StatePath == "" {
+		return nil
+	}
+	buf, err := json.MarshalIndent(w.heads, "", " ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(w.cfg.StatePath), 0700); err != nil {
+		return err
+	}
+	tmpPath := w.cfg.StatePath + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, w.cfg.StatePath)
+}
+
+func getJson(url string, v interface{}) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to fetch %s: %s", url, resp.Status)
+	}
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
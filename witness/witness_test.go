@@ -0,0 +1,116 @@
+package witness_test
+
+import (
+	"github.com/bwesterb/go-atum"
+	"github.com/bwesterb/go-atum/server"
+	"github.com/bwesterb/go-atum/stamper"
+	"github.com/bwesterb/go-atum/witness"
+
+	"golang.org/x/crypto/ed25519"
+
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// A handler that passes requests on to a handler that can be swapped.
+type swapHandler struct {
+	mux sync.Mutex
+	h   http.Handler
+}
+
+func (s *swapHandler) set(h http.Handler) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.h = h
+}
+
+func (s *swapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.Lock()
+	h := s.h
+	s.mux.Unlock()
+	h.ServeHTTP(w, r)
+}
+
+func newKey(t *testing.T) ed25519.PrivateKey {
+	_, sk, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sk
+}
+
+// Serves the handler and requests n timestamps from it.
+func serveAndStamp(t *testing.T, h http.Handler, n int) string {
+	hs := httptest.NewServer(h)
+	t.Cleanup(hs.Close)
+	for i := 0; i < n; i++ {
+		_, err := atum.SendRequest(hs.URL, atum.Request{
+			Nonce: []byte(fmt.Sprintf("%s %d", hs.URL, i)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return hs.URL + "/"
+}
+
+// Creates a server with the given key that accepts cosignatures of the
+// given witness.
+func newServer(t *testing.T, sk ed25519.PrivateKey,
+	witnessPk []byte) *server.Server {
+	srv, err := server.New(server.Config{
+		Signers:   []stamper.Signer{stamper.NewEd25519Signer(sk)},
+		Witnesses: [][]byte{witnessPk},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func TestWitnessInconsistent(t *testing.T) {
+	atum.SetCache(atum.NewBoltCache(filepath.Join(t.TempDir(), "cache.bolt")))
+	sk := newKey(t)
+	statePath := filepath.Join(t.TempDir(), "witness.json")
+	cfg := witness.Config{Key: newKey(t), StatePath: statePath}
+	w, err := witness.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two servers with the same key, but different logs.
+	forked := newServer(t, sk, w.PublicKey())
+	serveAndStamp(t, forked, 3)
+	sh := &swapHandler{h: newServer(t, sk, w.PublicKey())}
+	url := serveAndStamp(t, sh, 2)
+
+	if err = w.Witness(url); err != nil {
+		t.Fatal(err)
+	}
+	head, err := atum.FetchCosignedTreeHead(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head == nil || head.Size != 2 || head.CountCosignatures(url,
+		[][]byte{w.PublicKey()}) != 1 {
+		t.Fatalf("tree head not cosigned: %+v", head)
+	}
+
+	// A restarted witness remembers the tree head and refuses to cosign
+	// the tree head of the other log.
+	if w, err = witness.New(cfg); err != nil {
+		t.Fatal(err)
+	}
+	sh.set(forked)
+	if err = w.Witness(url); err == nil {
+		t.Fatal("witness cosigned inconsistent tree head")
+	}
+	if head = forked.CosignedTreeHead(); head != nil {
+		t.Fatalf("forked server has cosigned tree head: %+v", head)
+	}
+}